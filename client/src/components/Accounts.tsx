@@ -0,0 +1,66 @@
+import { Button, Table } from "flowbite-react";
+import React, { useEffect, useState } from "react";
+import { apiPost, endpoint, useAuthProviders } from "..";
+
+type IdentityInfo = {
+  provider: string,
+  title: string,
+  subject: string,
+  email: string,
+  linked: string,
+  lastUsed?: string,
+};
+
+// AccountSettings lists the accounts the logged in user can log in through,
+// and lets them link more and unlink them.
+export function AccountSettings() {
+  const [identities, setIdentities] = useState<IdentityInfo[]>([]);
+  const [error, setError] = useState<string|null>(null);
+  const providers = useAuthProviders();
+
+  useEffect(() => {
+    (async () => {
+      const resp = await fetch(`${endpoint}/api/list_identities`, {credentials: "include", mode: "cors"});
+      if (resp.ok) {
+        setIdentities(await resp.json());
+      }
+    })();
+  }, []);
+
+  const unlink = async (identity: IdentityInfo) => {
+    try {
+      await apiPost("/api/unlink_identity", {provider: identity.provider, subject: identity.subject});
+      setIdentities(identities.filter(i => i !== identity));
+      setError(null);
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  return <div className="flex max-w-xl flex-col gap-4 mt-8 m-auto">
+    <h1 className="text-2xl font-bold">Linked accounts</h1>
+    <p className="text-gray-600">You can log in through any of these accounts.</p>
+    {identities.length > 0 && <Table>
+      <Table.Head>
+        <Table.HeadCell>Account</Table.HeadCell>
+        <Table.HeadCell>Email</Table.HeadCell>
+        <Table.HeadCell>Last used</Table.HeadCell>
+        <Table.HeadCell />
+      </Table.Head>
+      <Table.Body>
+        {identities.map(i => <Table.Row key={`${i.provider}/${i.subject}`}>
+          <Table.Cell>{i.title}</Table.Cell>
+          <Table.Cell>{i.email}</Table.Cell>
+          <Table.Cell>{i.lastUsed ? new Date(i.lastUsed).toLocaleDateString() : "Never"}</Table.Cell>
+          <Table.Cell>
+            <Button size="xs" color="failure" disabled={identities.length === 1} onClick={() => unlink(i)}>Unlink</Button>
+          </Table.Cell>
+        </Table.Row>)}
+      </Table.Body>
+    </Table>}
+    {providers.map(p => <Button key={p.name} color="light" href={`${endpoint}/auth/${p.name}/link?return_to=/editor`}>
+      Link a {p.title} account
+    </Button>)}
+    {error ? <span className="text-red-700">{error}</span> : null}
+  </div>;
+}
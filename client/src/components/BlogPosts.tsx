@@ -0,0 +1,216 @@
+import { Badge, Button, Checkbox, FileInput, Label, Table, Textarea, TextInput } from "flowbite-react";
+import React, { FormEvent, useEffect, useState } from "react";
+import { apiPost, endpoint, errorMessage, isError } from "..";
+
+type PostInfo = {
+  id: string,
+  slug: string,
+  title: string,
+  body?: string,
+  tags: string[],
+  coverImageURL?: string,
+  published: boolean,
+  publishDate?: string,
+  updated: string,
+};
+
+// PostForm holds a post being edited. Tags are typed separated by commas, and
+// the publish date as the local time a datetime-local input uses.
+type PostForm = {
+  id?: string,
+  slug: string,
+  title: string,
+  body: string,
+  tags: string,
+  coverImageURL: string,
+  published: boolean,
+  publishDate: string,
+};
+
+const emptyForm: PostForm = {slug: "", title: "", body: "", tags: "", coverImageURL: "", published: false, publishDate: ""};
+
+function toLocalInput(date?: string): string {
+  if (!date) {
+    return "";
+  }
+  const d = new Date(date);
+  return new Date(d.getTime() - d.getTimezoneOffset() * 60000).toISOString().slice(0, 16);
+}
+
+async function apiGet(path: string): Promise<any> {
+  const resp = await fetch(`${endpoint}${path}`, {credentials: "include", mode: "cors"});
+  const json = await resp.json().catch(() => null);
+  if (!resp.ok) {
+    throw new Error(isError(json) ? errorMessage(json) : resp.statusText);
+  }
+  return json;
+}
+
+function postStatus(post: PostInfo): string {
+  if (!post.published) {
+    return "Draft";
+  } else if (post.publishDate && new Date(post.publishDate) > new Date()) {
+    return "Scheduled";
+  }
+  return "Published";
+}
+
+// BlogSettings lists the logged in user's blog posts, and lets them write,
+// edit, publish and delete them.
+export function BlogSettings() {
+  const [posts, setPosts] = useState<PostInfo[]>([]);
+  const [form, setForm] = useState<PostForm|null>(null);
+  const [error, setError] = useState<string|null>(null);
+
+  useEffect(() => {
+    apiGet("/api/list_posts").then(setPosts).catch(e => setError(`${e}`));
+  }, []);
+
+  const edit = async (id: string) => {
+    try {
+      const post: PostInfo = await apiGet(`/api/get_post?id=${encodeURIComponent(id)}`);
+      setForm({
+        id: post.id,
+        slug: post.slug,
+        title: post.title,
+        body: post.body ?? "",
+        tags: post.tags.join(", "),
+        coverImageURL: post.coverImageURL ?? "",
+        published: post.published,
+        publishDate: toLocalInput(post.publishDate),
+      });
+      setError(null);
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  const save = async (e: FormEvent) => {
+    e.preventDefault();
+    if (form === null) {
+      return;
+    }
+    try {
+      const post: PostInfo = await apiPost("/api/save_post", {
+        id: form.id,
+        slug: form.slug,
+        title: form.title,
+        body: form.body,
+        tags: form.tags.split(",").map(t => t.trim()).filter(t => t !== ""),
+        coverImageURL: form.coverImageURL,
+        published: form.published,
+        publishDate: form.publishDate ? new Date(form.publishDate).toISOString() : "",
+      });
+      setPosts([post, ...posts.filter(p => p.id !== post.id)]);
+      setForm(null);
+      setError(null);
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  const remove = async (id: string) => {
+    if (!window.confirm("Delete this post? This cannot be undone.")) {
+      return;
+    }
+    try {
+      await apiPost("/api/delete_post", {id});
+      setPosts(posts.filter(p => p.id !== id));
+      if (form?.id === id) {
+        setForm(null);
+      }
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  const uploadCover = async (f: File) => {
+    try {
+      const resp = await fetch(`${endpoint}/api/upload_image`, {
+        method: "POST",
+        headers: {'Content-Type': f.type},
+        body: f,
+        credentials: "include",
+        mode: "cors"
+      });
+      if (!resp.ok) {
+        setError(`Error uploading image: ${await resp.text()}`);
+        return;
+      }
+      const {url} = await resp.json();
+      setForm(form => form && {...form, coverImageURL: url});
+    } catch (e) {
+      setError(`Error uploading image: ${e}`);
+    }
+  };
+
+  if (form !== null) {
+    return <form className="flex max-w-2xl flex-col gap-4 mt-8 m-auto" onSubmit={save}>
+      <h1 className="text-2xl font-bold">{form.id ? "Edit post" : "New post"}</h1>
+      <div>
+        <Label htmlFor="post-title" value="Title" />
+        <TextInput id="post-title" required maxLength={200} value={form.title} onChange={e => setForm({...form, title: e.target.value})} />
+      </div>
+      <div>
+        <Label htmlFor="post-slug" value="Slug" />
+        <TextInput id="post-slug" placeholder="Made from the title if left empty" maxLength={100} value={form.slug}
+          onChange={e => setForm({...form, slug: e.target.value})} />
+      </div>
+      <div>
+        <Label htmlFor="post-body" value="Body (Markdown)" />
+        <Textarea id="post-body" rows={16} value={form.body} onChange={e => setForm({...form, body: e.target.value})} />
+      </div>
+      <div>
+        <Label htmlFor="post-tags" value="Tags" />
+        <TextInput id="post-tags" placeholder="design, rust, side-projects" value={form.tags} onChange={e => setForm({...form, tags: e.target.value})} />
+      </div>
+      <div>
+        <Label htmlFor="post-cover" value="Cover image" />
+        {form.coverImageURL && <div className="flex items-center gap-2 mb-2">
+          <img className="h-16 rounded" src={form.coverImageURL} alt="" />
+          <Button size="xs" color="light" onClick={() => setForm({...form, coverImageURL: ""})}>Remove</Button>
+        </div>}
+        <FileInput id="post-cover" accept="image/*" onChange={e => e.target.files?.[0] && uploadCover(e.target.files[0])} />
+      </div>
+      <div className="flex items-center gap-2">
+        <Checkbox id="post-published" checked={form.published} onChange={e => setForm({...form, published: e.target.checked})} />
+        <Label htmlFor="post-published" value="Published" />
+      </div>
+      <div>
+        <Label htmlFor="post-date" value="Publish date" />
+        <TextInput id="post-date" type="datetime-local" value={form.publishDate} onChange={e => setForm({...form, publishDate: e.target.value})} />
+        <p className="text-sm text-gray-600 mt-1">Published posts with a date in the future appear on your blog then. Left empty, it is set when the post is first published.</p>
+      </div>
+      <div className="flex gap-2">
+        <Button type="submit">Save</Button>
+        <Button color="light" onClick={() => setForm(null)}>Cancel</Button>
+      </div>
+      {error ? <span className="text-red-700">{error}</span> : null}
+    </form>;
+  }
+
+  return <div className="flex max-w-2xl flex-col gap-4 mt-8 m-auto">
+    <h1 className="text-2xl font-bold">Blog</h1>
+    {posts.length > 0 && <Table>
+      <Table.Head>
+        <Table.HeadCell>Title</Table.HeadCell>
+        <Table.HeadCell>Status</Table.HeadCell>
+        <Table.HeadCell>Updated</Table.HeadCell>
+        <Table.HeadCell />
+      </Table.Head>
+      <Table.Body>
+        {posts.map(p => <Table.Row key={p.id}>
+          <Table.Cell>{p.title}</Table.Cell>
+          <Table.Cell><Badge className="w-fit" color={p.published ? "success" : "gray"}>{postStatus(p)}</Badge></Table.Cell>
+          <Table.Cell>{new Date(p.updated).toLocaleDateString()}</Table.Cell>
+          <Table.Cell className="flex gap-2">
+            <Button size="xs" color="light" onClick={() => edit(p.id)}>Edit</Button>
+            <Button size="xs" color="failure" onClick={() => remove(p.id)}>Delete</Button>
+          </Table.Cell>
+        </Table.Row>)}
+      </Table.Body>
+    </Table>}
+    <Button color="light" onClick={() => { setForm(emptyForm); setError(null); }}>Write a post</Button>
+    {error ? <span className="text-red-700">{error}</span> : null}
+  </div>;
+}
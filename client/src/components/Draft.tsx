@@ -0,0 +1,68 @@
+import { Alert, Button } from "flowbite-react";
+import React, { useEffect, useState } from "react";
+import { apiPost, endpoint } from "..";
+
+type DraftStatus = {
+  hasDraft: boolean,
+  lastSaved?: string,
+};
+
+// DraftNotice tells the logged in user about a draft they pushed with the
+// folio command, which the editor does not change, and lets them publish or
+// discard it. onPublished is called once the draft has replaced their
+// portfolio.
+export function DraftNotice({onPublished}: {onPublished: () => void}) {
+  const [status, setStatus] = useState<DraftStatus>({hasDraft: false});
+  const [error, setError] = useState<string|null>(null);
+
+  useEffect(() => {
+    (async () => {
+      const resp = await fetch(`${endpoint}/api/draft_status`, {credentials: "include", mode: "cors"});
+      if (resp.ok) {
+        setStatus(await resp.json());
+      }
+    })();
+  }, []);
+
+  if (!status.hasDraft) {
+    return null;
+  }
+
+  const publish = async () => {
+    if (!window.confirm("Replace your portfolio with the draft? Changes made in the editor since will be lost.")) {
+      return;
+    }
+    try {
+      await apiPost("/api/publish");
+      setStatus({hasDraft: false});
+      onPublished();
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  const discard = async () => {
+    if (!window.confirm("Discard the draft? This cannot be undone.")) {
+      return;
+    }
+    try {
+      await apiPost("/api/discard_draft");
+      setStatus({hasDraft: false});
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  return <Alert color="warning" className="m-2">
+    <div className="flex flex-wrap items-center gap-2">
+      <span className="grow">
+        You have an unpublished draft
+        {status.lastSaved && <> from {new Date(status.lastSaved).toLocaleString()}</>}.
+        The editor changes your published portfolio, not the draft.
+      </span>
+      <Button size="xs" onClick={publish}>Publish draft</Button>
+      <Button size="xs" color="light" onClick={discard}>Discard draft</Button>
+    </div>
+    {error && <p className="mt-2 text-red-600">{error}</p>}
+  </Alert>;
+}
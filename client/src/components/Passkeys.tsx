@@ -0,0 +1,152 @@
+import { Button, Label, Table, TextInput } from "flowbite-react";
+import React, { FormEvent, useEffect, useState } from "react";
+import { apiPost, endpoint } from "..";
+
+// The server sends and expects binary fields of WebAuthn options and
+// credentials as base64url strings, which the browser wants as buffers.
+
+function fromBase64url(s: string): ArrayBuffer {
+  const base64 = s.replace(/-/g, "+").replace(/_/g, "/");
+  const bytes = Uint8Array.from(atob(base64), c => c.charCodeAt(0));
+  return bytes.buffer;
+}
+
+function toBase64url(buffer: ArrayBuffer|null): string|undefined {
+  if (buffer === null) {
+    return undefined;
+  }
+  const s = btoa(String.fromCharCode(...new Uint8Array(buffer)));
+  return s.replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+// logInWithPasskey logs in with any passkey the browser has for the site,
+// returning the username of who logged in.
+export async function logInWithPasskey(): Promise<string> {
+  const {publicKey} = await apiPost("/api/passkey/begin_login");
+  const credential = await navigator.credentials.get({
+    publicKey: {
+      ...publicKey,
+      challenge: fromBase64url(publicKey.challenge),
+      allowCredentials: [],
+    },
+  }) as PublicKeyCredential|null;
+  if (credential === null) {
+    throw new Error("No passkey was chosen");
+  }
+
+  const response = credential.response as AuthenticatorAssertionResponse;
+  const {username} = await apiPost("/api/passkey/finish_login", {
+    id: credential.id,
+    rawId: toBase64url(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64url(response.clientDataJSON),
+      authenticatorData: toBase64url(response.authenticatorData),
+      signature: toBase64url(response.signature),
+      userHandle: toBase64url(response.userHandle),
+    },
+  });
+  return username;
+}
+
+// registerPasskey makes a passkey for the logged in user, called name.
+async function registerPasskey(name: string): Promise<PasskeyInfo> {
+  const {publicKey} = await apiPost("/api/passkey/begin_registration");
+  const credential = await navigator.credentials.create({
+    publicKey: {
+      ...publicKey,
+      challenge: fromBase64url(publicKey.challenge),
+      user: {...publicKey.user, id: fromBase64url(publicKey.user.id)},
+      excludeCredentials: (publicKey.excludeCredentials ?? []).map((c: {id: string}) => ({...c, id: fromBase64url(c.id)})),
+    },
+  }) as PublicKeyCredential|null;
+  if (credential === null) {
+    throw new Error("No passkey was made");
+  }
+
+  const response = credential.response as AuthenticatorAttestationResponse;
+  return await apiPost(`/api/passkey/finish_registration?name=${encodeURIComponent(name)}`, {
+    id: credential.id,
+    rawId: toBase64url(credential.rawId),
+    type: credential.type,
+    response: {
+      clientDataJSON: toBase64url(response.clientDataJSON),
+      attestationObject: toBase64url(response.attestationObject),
+      transports: response.getTransports?.() ?? [],
+    },
+  });
+}
+
+type PasskeyInfo = {
+  id: string,
+  name: string,
+  transports: string[],
+  backedUp: boolean,
+  created: string,
+  lastUsed?: string,
+};
+
+// PasskeySettings lists the logged in user's passkeys, and lets them add and
+// remove them.
+export function PasskeySettings() {
+  const [passkeys, setPasskeys] = useState<PasskeyInfo[]>([]);
+  const [name, setName] = useState("");
+  const [error, setError] = useState<string|null>(null);
+
+  useEffect(() => {
+    (async () => {
+      const resp = await fetch(`${endpoint}/api/list_passkeys`, {credentials: "include", mode: "cors"});
+      if (resp.ok) {
+        setPasskeys(await resp.json());
+      }
+    })();
+  }, []);
+
+  const add = async (e: FormEvent) => {
+    e.preventDefault();
+    try {
+      const passkey = await registerPasskey(name);
+      setPasskeys([...passkeys, passkey]);
+      setName("");
+      setError(null);
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  const remove = async (id: string) => {
+    try {
+      await apiPost("/api/delete_passkey", {id});
+      setPasskeys(passkeys.filter(p => p.id !== id));
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  return <div className="flex max-w-xl flex-col gap-4 mt-8 m-auto">
+    <h1 className="text-2xl font-bold">Passkeys</h1>
+    <p className="text-gray-600">Passkeys let you log in with your fingerprint, face or device PIN instead of another account.</p>
+    {passkeys.length > 0 && <Table>
+      <Table.Head>
+        <Table.HeadCell>Name</Table.HeadCell>
+        <Table.HeadCell>Added</Table.HeadCell>
+        <Table.HeadCell>Last used</Table.HeadCell>
+        <Table.HeadCell />
+      </Table.Head>
+      <Table.Body>
+        {passkeys.map(p => <Table.Row key={p.id}>
+          <Table.Cell>{p.name}</Table.Cell>
+          <Table.Cell>{new Date(p.created).toLocaleDateString()}</Table.Cell>
+          <Table.Cell>{p.lastUsed ? new Date(p.lastUsed).toLocaleDateString() : "Never"}</Table.Cell>
+          <Table.Cell><Button size="xs" color="failure" onClick={() => remove(p.id)}>Remove</Button></Table.Cell>
+        </Table.Row>)}
+      </Table.Body>
+    </Table>}
+    <form className="flex flex-col gap-2" onSubmit={add}>
+      <Label htmlFor="passkey-name" value="Name" />
+      <TextInput id="passkey-name" placeholder="My laptop" maxLength={64} value={name} onChange={e => setName(e.target.value)} />
+      <Button type="submit" color="light">Add a passkey</Button>
+    </form>
+    {error ? <span className="text-red-700">{error}</span> : null}
+  </div>;
+}
@@ -2,7 +2,8 @@ import React, {createContext, ReactNode, useContext, useEffect, useRef, useState
 import {Button, FileInput, Label, Modal, TextInput} from "flowbite-react";
 import { endpoint } from "../index";
 import Markdown from "react-markdown";
-import {Portfolio, defaultSection, defaultProject, Project} from "../types/portfolio";
+import {Portfolio, newSection, newProject, Project} from "../types/portfolio";
+import { Link } from "react-router-dom";
 import { defaultTheme, Theme } from '../themes/theme';
 import { HiLocationMarker, HiTrash } from 'react-icons/hi';
 import { MdAddLink } from "react-icons/md";
@@ -68,6 +69,8 @@ import { SaveStatus } from '../routes/editor';
 type EditorArg = {
   update: () => void,
   editable: boolean,
+  linkHref: (section: number, project: number, link: string) => string,
+  permalinks: boolean,
   setModal: (m: ReactNode|null) => void,
   theme: Theme,
   gen: number,
@@ -81,9 +84,14 @@ type ModalArgs = {
   delete: () => void,
 };
 
-export function PortfolioComponent({initialPortfolio, setPortfolio}: {
+export function PortfolioComponent({initialPortfolio, setPortfolio, linkHref, permalinks = false}: {
   initialPortfolio: Portfolio,
   setPortfolio: ((p: Portfolio) => void)|null,
+  // linkHref maps the link of project j in section i to the href used for it.
+  linkHref?: (section: number, project: number, link: string) => string,
+  // permalinks links each project to its permalink page, which only
+  // published projects have.
+  permalinks?: boolean,
 }) {
   let portfolio: Portfolio = structuredClone(initialPortfolio);
   const theme = defaultTheme(portfolio);
@@ -98,7 +106,9 @@ export function PortfolioComponent({initialPortfolio, setPortfolio}: {
 
   const incrementGen = () => setGen(gen + 1);
 
-  return <EditorContext.Provider value={{update, editable, setModal, theme, gen, incrementGen}} >
+  const href = linkHref ?? ((_i: number, _j: number, link: string) => link);
+
+  return <EditorContext.Provider value={{update, editable, linkHref: href, permalinks, setModal, theme, gen, incrementGen}} >
     <div>
       <div className={theme.holder}>
         <div className={theme.sidebar}>
@@ -141,12 +151,12 @@ export function PortfolioComponent({initialPortfolio, setPortfolio}: {
               </div>
               <ul className={theme.section.list}>
                 {section.projects.map((_, j) => (
-                  <ProjectComponent key={`${i}-${j}-${gen}`} projectKey={`${i}-${j}-${gen}`} array={section.projects} index={j} />
+                  <ProjectComponent key={`${i}-${j}-${gen}`} projectKey={`${i}-${j}-${gen}`} array={section.projects} sectionIndex={i} index={j} />
                 ))}
                 <li>
                   <AddButton
                     array={section.projects}
-                    new={defaultProject}
+                    new={newProject}
                     className={theme.project.add}
                   />
                 </li>
@@ -156,7 +166,7 @@ export function PortfolioComponent({initialPortfolio, setPortfolio}: {
           ))}
           <AddButton
             array={portfolio.sections}
-            new={defaultSection}
+            new={newSection}
             className={theme.section.add}
           />
         </div>
@@ -185,9 +195,9 @@ function DeleteModal({what, onDelete}: {what: string, onDelete: () => void}) {
   </>
 }
 
-function ProjectComponent({projectKey, array, index}: {projectKey: string, array: Project[], index: number}) {
+function ProjectComponent({projectKey, array, sectionIndex, index}: {projectKey: string, array: Project[], sectionIndex: number, index: number}) {
   const project = array[index];
-  const {editable, theme, setModal, gen} = useContext(EditorContext);
+  const {editable, linkHref, permalinks, theme, setModal, gen} = useContext(EditorContext);
 
   const inner = <>
     <div className="w-full flex flex-row">
@@ -216,12 +226,13 @@ function ProjectComponent({projectKey, array, index}: {projectKey: string, array
 
   return <li className={theme.project.item}>
     {project.link && !editable
-    ? <a className={`${theme.project.content} block`} href={project.link} target="_blank">
+    ? <a className={`${theme.project.content} block`} href={linkHref(sectionIndex, index, project.link)} target="_blank">
       {inner}
     </a>
     : <div className={theme.project.content}>
       {inner}
     </div>}
+    {permalinks && !editable && project.id && <Link className="block px-4 pb-2 text-xs hover:underline" to={`/p/${project.id}`}>Permalink</Link>}
   </li>
 }
 
@@ -524,7 +535,7 @@ function MarkdownParagraph<T extends string>(props: {
 
 function AddButton<T>(props: {
   array: T[],
-  new: T,
+  new: () => T,
   className?: string,
   placeholder?: string,
 }) {
@@ -537,7 +548,7 @@ function AddButton<T>(props: {
   return <button
     className={`${theme.addButton} ${props.className}`}
     onClick={() => {
-      props.array.push(props.new);
+      props.array.push(props.new());
       incrementGen();
       update();
     }}
@@ -0,0 +1,116 @@
+import { Alert, Button, Checkbox, Label, Select, Table, TextInput } from "flowbite-react";
+import React, { FormEvent, useEffect, useState } from "react";
+import { apiPost, endpoint } from "..";
+
+type TokenScope = "read" | "write_portfolio" | "upload_images";
+
+const scopeNames: {[S in TokenScope]: string} = {
+  read: "Read your portfolio and drafts",
+  write_portfolio: "Change your portfolio and drafts",
+  upload_images: "Upload images",
+};
+
+const scopes = Object.keys(scopeNames) as TokenScope[];
+
+type TokenInfo = {
+  id: string,
+  name: string,
+  scopes: TokenScope[],
+  created: string,
+  expires?: string,
+  lastUsed?: string,
+};
+
+// TokenSettings lists the logged in user's personal access tokens, which the
+// folio command and other scripts use to call the API, and lets them create
+// and revoke them.
+export function TokenSettings() {
+  const [tokens, setTokens] = useState<TokenInfo[]>([]);
+  const [name, setName] = useState("");
+  const [chosen, setChosen] = useState<TokenScope[]>(scopes);
+  const [days, setDays] = useState(90);
+  const [created, setCreated] = useState<string|null>(null);
+  const [error, setError] = useState<string|null>(null);
+
+  useEffect(() => {
+    (async () => {
+      const resp = await fetch(`${endpoint}/api/list_tokens`, {credentials: "include", mode: "cors"});
+      if (resp.ok) {
+        setTokens(await resp.json());
+      }
+    })();
+  }, []);
+
+  const toggle = (scope: TokenScope, on: boolean) => {
+    setChosen(on ? [...chosen, scope] : chosen.filter(s => s !== scope));
+  };
+
+  const create = async (e: FormEvent) => {
+    e.preventDefault();
+    try {
+      const {token, ...info} = await apiPost("/api/create_token", {name, scopes: chosen, expiresInDays: days});
+      setTokens([...tokens, info]);
+      setCreated(token);
+      setName("");
+      setError(null);
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  const revoke = async (id: string) => {
+    try {
+      await apiPost("/api/revoke_token", {id});
+      setTokens(tokens.filter(t => t.id !== id));
+    } catch (e) {
+      setError(`${e}`);
+    }
+  };
+
+  return <div className="flex max-w-2xl flex-col gap-4 mt-8 m-auto">
+    <h1 className="text-2xl font-bold">Access tokens</h1>
+    <p className="text-gray-600">
+      Tokens let the <code>folio</code> command and your own scripts use your account. Pass one
+      with <code>-token</code> or <code>$FOLIO_TOKEN</code>.
+    </p>
+    {created && <Alert color="success" onDismiss={() => setCreated(null)}>
+      <p className="mb-2">Copy your new token now. It will not be shown again.</p>
+      <code className="break-all select-all">{created}</code>
+    </Alert>}
+    {tokens.length > 0 && <Table>
+      <Table.Head>
+        <Table.HeadCell>Name</Table.HeadCell>
+        <Table.HeadCell>Scopes</Table.HeadCell>
+        <Table.HeadCell>Expires</Table.HeadCell>
+        <Table.HeadCell>Last used</Table.HeadCell>
+        <Table.HeadCell />
+      </Table.Head>
+      <Table.Body>
+        {tokens.map(t => <Table.Row key={t.id}>
+          <Table.Cell>{t.name}</Table.Cell>
+          <Table.Cell>{t.scopes.join(", ")}</Table.Cell>
+          <Table.Cell>{t.expires ? new Date(t.expires).toLocaleDateString() : "Never"}</Table.Cell>
+          <Table.Cell>{t.lastUsed ? new Date(t.lastUsed).toLocaleDateString() : "Never"}</Table.Cell>
+          <Table.Cell><Button size="xs" color="failure" onClick={() => revoke(t.id)}>Revoke</Button></Table.Cell>
+        </Table.Row>)}
+      </Table.Body>
+    </Table>}
+    <form className="flex flex-col gap-2" onSubmit={create}>
+      <Label htmlFor="token-name" value="Name" />
+      <TextInput id="token-name" placeholder="My laptop" required maxLength={64} value={name} onChange={e => setName(e.target.value)} />
+      {scopes.map(s => <div key={s} className="flex items-center gap-2">
+        <Checkbox id={`token-scope-${s}`} checked={chosen.includes(s)} onChange={e => toggle(s, e.target.checked)} />
+        <Label htmlFor={`token-scope-${s}`} value={scopeNames[s]} />
+      </div>)}
+      <Label htmlFor="token-expires" value="Expires" />
+      <Select id="token-expires" value={days} onChange={e => setDays(+e.target.value)}>
+        <option value={30}>In 30 days</option>
+        <option value={90}>In 90 days</option>
+        <option value={365}>In a year</option>
+        <option value={0}>Never</option>
+      </Select>
+      <Button type="submit" color="light" disabled={chosen.length === 0}>Create a token</Button>
+    </form>
+    {error ? <span className="text-red-700">{error}</span> : null}
+  </div>;
+}
@@ -1,4 +1,4 @@
-import React from 'react';
+import React, { useEffect, useState } from 'react';
 import ReactDOM from 'react-dom/client';
 import './index.css';
 import {createBrowserRouter, RouterProvider, useParams} from "react-router-dom";
@@ -6,6 +6,11 @@ import {Signup} from "./routes/signup";
 import { Landing } from './routes/landing';
 import { Editor } from './routes/editor';
 import { Userpage } from './routes/userpage';
+import { Shared } from './routes/shared';
+import { Directory } from './routes/directory';
+import { BlogIndex, BlogPostPage } from './routes/blog';
+import { ProjectPage } from './routes/project';
+import { EmailLogin } from './routes/loginemail';
 
 const root = ReactDOM.createRoot(
   document.getElementById('root') as HTMLElement
@@ -28,6 +33,23 @@ export function errorMessage(e: ApiError): string {
   return `Error ${e.errorCode}: ${e.errorMessage}`;
 }
 
+// apiPost posts body to path as JSON, returning the response's JSON or
+// throwing its error message.
+export async function apiPost(path: string, body?: unknown): Promise<any> {
+  const resp = await fetch(`${endpoint}${path}`, {
+    method: "POST",
+    headers: {'Content-Type': 'application/json'},
+    body: body === undefined ? undefined : JSON.stringify(body),
+    credentials: "include",
+    mode: "cors"
+  });
+  const json = await resp.json().catch(() => null);
+  if (!resp.ok) {
+    throw new Error(isError(json) ? errorMessage(json) : resp.statusText);
+  }
+  return json;
+}
+
 export async function checkLoginStatus() {
   try {
     const response = await fetch(`${endpoint}/api/get_login`, {
@@ -45,10 +67,47 @@ export async function checkLoginStatus() {
   return false;
 }
 
+export type AuthProvider = {
+  name: string,
+  title: string,
+};
+
+// useAuthProviders returns the providers users can log in through, once they
+// are loaded.
+export function useAuthProviders(): AuthProvider[] {
+  const [providers, setProviders] = useState<AuthProvider[]>([]);
+
+  useEffect(() => {
+    (async () => {
+      try {
+        const resp = await fetch(`${endpoint}/api/auth_providers`);
+        if (resp.ok) {
+          setProviders(await resp.json());
+        }
+      } catch (error) {
+        console.error('Error loading login providers:', error);
+      }
+    })();
+  }, []);
+
+  return providers;
+}
+
+// Portfolios served on a custom domain are at its root, and the server names
+// the portfolio's user in the page since the URL does not.
+const customDomainUser = document
+  .querySelector('meta[name="foliospot:username"]')
+  ?.getAttribute("content");
+
+// Pages on a portfolio's own host cannot send the server's cookies, and the
+// server refuses cross-origin requests from them that try to, so public API
+// calls only include credentials from the frontend.
+export const publicCredentials: RequestCredentials = customDomainUser ? "omit" : "include";
+
 const router = createBrowserRouter([
   {
     path: "/",
-    element: <Landing />
+    element: customDomainUser ? <Userpage username={customDomainUser} /> : <Landing />
   },
   {
     path: "/:userid",
@@ -61,6 +120,30 @@ const router = createBrowserRouter([
   {
     path: "/editor",
     element: <Editor />
+  },
+  {
+    path: "/share/:token",
+    element: <Shared />
+  },
+  {
+    path: "/directory",
+    element: <Directory />
+  },
+  {
+    path: "/blog/:username",
+    element: <BlogIndex />
+  },
+  {
+    path: "/blog/:username/:slug",
+    element: <BlogPostPage />
+  },
+  {
+    path: "/p/:id",
+    element: <ProjectPage />
+  },
+  {
+    path: "/login/email",
+    element: <EmailLogin />
   }
 ])
 
@@ -0,0 +1,147 @@
+import { useEffect, useState } from "react";
+import { Link, useNavigate, useParams, useSearchParams } from "react-router-dom";
+import { Pagination } from "flowbite-react";
+import Markdown from "react-markdown";
+import { endpoint, errorMessage, isError, publicCredentials } from "..";
+
+type BlogPost = {
+  id: string,
+  slug: string,
+  title: string,
+  body?: string,
+  excerpt: string,
+  tags: string[],
+  coverImageURL?: string,
+  publishDate?: string,
+};
+
+type BlogPage = {
+  posts: BlogPost[],
+  total: number,
+  page: number,
+  pageSize: number,
+};
+
+// useBlogAPI fetches path from the API, following users who changed their
+// username to their new blog URL.
+function useBlogAPI<T>(path: string, movedPath: (username: string) => string): T | string | null {
+  const [result, setResult] = useState<T|string|null>(null);
+  const navigate = useNavigate();
+  const {username} = useParams();
+
+  useEffect(() => {
+    (async () => {
+      let url = `${endpoint}${path}`;
+      const grant = sessionStorage.getItem(`grant:${username}`);
+      if (grant) {
+        url += `&grant=${encodeURIComponent(grant)}`;
+      }
+      try {
+        const resp = await fetch(url, {
+          method: "GET",
+          headers: {'Content-Type': 'application/json'},
+          credentials: publicCredentials,
+          mode: "cors"
+        });
+        const body = await resp.json().catch(() => null);
+        if (!resp.ok) {
+          if (isError(body) && body.errorCode === 301
+            && "errorData" in body && !!body.errorData && typeof body.errorData === "object"
+            && "username" in body.errorData && typeof body.errorData.username === "string") {
+            navigate(movedPath(body.errorData.username), {replace: true});
+            return;
+          }
+          setResult(isError(body) ? errorMessage(body) : resp.statusText);
+          return;
+        }
+        setResult(body);
+      } catch (error) {
+        console.log(error);
+      }
+    })();
+  }, [path]);
+
+  return result;
+}
+
+function formatDate(date?: string) {
+  return date ? new Date(date).toLocaleDateString(undefined, {dateStyle: "long"}) : "";
+}
+
+function Tags({username, tags}: {username: string, tags: string[]}) {
+  return <div className="flex flex-wrap gap-2">
+    {tags.map(tag => <Link key={tag} className="text-sm text-blue-600 hover:underline"
+      to={`/blog/${username}?tag=${encodeURIComponent(tag)}`}>#{tag}</Link>)}
+  </div>
+}
+
+// BlogIndex lists a user's published posts.
+export function BlogIndex() {
+  const {username = ""} = useParams();
+  const [params, setParams] = useSearchParams();
+  const page = useBlogAPI<BlogPage>(
+    `/api/blog_posts?username=${encodeURIComponent(username)}&${params}`,
+    moved => `/blog/${moved}?${params}`,
+  );
+
+  if (page === null) {
+    return null;
+  } else if (typeof page === "string") {
+    return <p>Error: {page}</p>
+  }
+
+  const tag = params.get("tag");
+  const goToPage = (n: number) => {
+    const next = new URLSearchParams(params);
+    next.set("page", n.toString());
+    setParams(next);
+  };
+
+  return <div className="max-w-3xl mx-auto px-4 py-12">
+    <h1 className="text-3xl font-bold mb-2"><Link className="hover:underline" to={`/${username}`}>{username}</Link>'s blog</h1>
+    <div className="flex gap-4 mb-8 text-sm">
+      {tag && <Link className="text-blue-600 hover:underline" to={`/blog/${username}`}>All posts</Link>}
+      <a className="text-blue-600 hover:underline" href={`${endpoint}/blog/${username}/feed.xml`}>Atom feed</a>
+    </div>
+    {page.posts.length === 0 && <p className="text-gray-600">No posts yet.</p>}
+    <ul className="flex flex-col gap-10">
+      {page.posts.map(post => <li key={post.id}>
+        {post.coverImageURL && <img className="w-full max-h-64 object-cover rounded-lg mb-4" src={post.coverImageURL} alt="" />}
+        <Link className="text-2xl font-semibold hover:underline" to={`/blog/${username}/${post.slug}`}>{post.title}</Link>
+        <p className="text-sm text-gray-600 mb-2">{formatDate(post.publishDate)}</p>
+        <p className="text-gray-800 mb-2">{post.excerpt}</p>
+        <Tags username={username} tags={post.tags} />
+      </li>)}
+    </ul>
+    {page.total > page.pageSize && <Pagination className="mt-8"
+      currentPage={page.page}
+      totalPages={Math.ceil(page.total / page.pageSize)}
+      onPageChange={goToPage} />}
+  </div>
+}
+
+// BlogPostPage shows one published post.
+export function BlogPostPage() {
+  const {username = "", slug = ""} = useParams();
+  const post = useBlogAPI<BlogPost>(
+    `/api/blog_post?username=${encodeURIComponent(username)}&slug=${encodeURIComponent(slug)}`,
+    moved => `/blog/${moved}/${slug}`,
+  );
+
+  if (post === null) {
+    return null;
+  } else if (typeof post === "string") {
+    return <p>Error: {post}</p>
+  }
+
+  return <article className="max-w-3xl mx-auto px-4 py-12">
+    <Link className="text-sm text-blue-600 hover:underline" to={`/blog/${username}`}>← {username}'s blog</Link>
+    {post.coverImageURL && <img className="w-full max-h-96 object-cover rounded-lg my-6" src={post.coverImageURL} alt="" />}
+    <h1 className="text-4xl font-bold mt-4 mb-2">{post.title}</h1>
+    <p className="text-sm text-gray-600 mb-4">{formatDate(post.publishDate)}</p>
+    <Tags username={username} tags={post.tags} />
+    <div className="mt-8">
+      <Markdown className="unreset">{post.body}</Markdown>
+    </div>
+  </article>
+}
@@ -0,0 +1,96 @@
+import { FormEvent, useEffect, useState } from "react";
+import { useSearchParams } from "react-router-dom";
+import { Button, Pagination, TextInput } from "flowbite-react";
+import { endpoint, errorMessage, isError } from "..";
+
+type Highlighted = {text: string, match?: boolean}[];
+
+type DirectoryResult = {
+  username: string,
+  url: string,
+  name: Highlighted,
+  location: Highlighted,
+  snippet: Highlighted,
+};
+
+type DirectoryPage = {
+  results: DirectoryResult[],
+  total: number,
+  page: number,
+  pageSize: number,
+};
+
+function Highlight({parts}: {parts: Highlighted}) {
+  return <>{parts.map((part, i) => part.match ? <mark key={i}>{part.text}</mark> : part.text)}</>
+}
+
+// Directory lets people search every listed portfolio.
+export function Directory() {
+  const [params, setParams] = useSearchParams();
+  const [q, setQ] = useState(params.get("q") ?? "");
+  const [location, setLocation] = useState(params.get("location") ?? "");
+  const [page, setPage] = useState<DirectoryPage|string|null>(null);
+
+  useEffect(() => {
+    (async () => {
+      try {
+        const resp = await fetch(`${endpoint}/api/search_directory?${params}`, {
+          method: "GET",
+          headers: {'Content-Type': 'application/json'},
+          mode: "cors"
+        });
+        const body = await resp.json().catch(() => null);
+        if (!resp.ok) {
+          setPage(isError(body) ? errorMessage(body) : resp.statusText);
+          return;
+        }
+        setPage(body);
+      } catch (error) {
+        console.log(error);
+      }
+    })();
+  }, [params]);
+
+  const search = (e: FormEvent) => {
+    e.preventDefault();
+    const next = new URLSearchParams();
+    if (q) next.set("q", q);
+    if (location) next.set("location", location);
+    setParams(next);
+  };
+
+  const goToPage = (n: number) => {
+    const next = new URLSearchParams(params);
+    next.set("page", n.toString());
+    setParams(next);
+  };
+
+  return <div className="max-w-3xl mx-auto px-4 py-12">
+    <h1 className="text-3xl font-bold mb-6">Find a portfolio</h1>
+    <form className="flex flex-col sm:flex-row gap-2 mb-8" onSubmit={search}>
+      <TextInput className="flex-1" placeholder="Name, skill or project" value={q}
+        onChange={e => setQ(e.target.value)} />
+      <TextInput placeholder="Location" value={location}
+        onChange={e => setLocation(e.target.value)} />
+      <Button type="submit">Search</Button>
+    </form>
+
+    {typeof page === "string" && <p>Error: {page}</p>}
+    {page && typeof page !== "string" && <>
+      {page.results.length === 0 && <p className="text-gray-600">No portfolios found.</p>}
+      <ul className="flex flex-col gap-6">
+        {page.results.map(result => <li key={result.username}>
+          <a className="text-xl font-semibold text-blue-600 hover:underline" href={result.url}>
+            <Highlight parts={result.name.length ? result.name : [{text: result.username}]} />
+          </a>
+          {result.location.length > 0 && <p className="text-sm text-gray-600"><Highlight parts={result.location} /></p>}
+          <p className="text-gray-800"><Highlight parts={result.snippet} /></p>
+        </li>)}
+      </ul>
+      {page.total > page.pageSize && <Pagination className="mt-8"
+        currentPage={page.page}
+        totalPages={Math.ceil(page.total / page.pageSize)}
+        onPageChange={goToPage} />}
+    </>}
+  </div>
+}
@@ -4,9 +4,14 @@ import { PortfolioComponent } from "../components/Portfolio";
 import { Font, Portfolio } from "../types/portfolio";
 import { Link } from "react-router-dom";
 import { Label, RangeSlider, Select, Tabs, Toast } from "flowbite-react";
-import {HiCheck, HiOutlinePencil, HiOutlinePencilAlt, HiGlobeAlt, HiInformationCircle, HiExclamation} from "react-icons/hi";
+import {HiCheck, HiOutlinePencil, HiOutlinePencilAlt, HiGlobeAlt, HiInformationCircle, HiExclamation, HiFingerPrint, HiNewspaper, HiKey, HiUserGroup} from "react-icons/hi";
 import {HiGlobeAmericas, HiPaintBrush} from "react-icons/hi2";
 import { defaultTheme } from "../themes/theme";
+import { PasskeySettings } from "../components/Passkeys";
+import { BlogSettings } from "../components/BlogPosts";
+import { TokenSettings } from "../components/Tokens";
+import { AccountSettings } from "../components/Accounts";
+import { DraftNotice } from "../components/Draft";
 
 const colors = [
   "slate",
@@ -64,6 +69,9 @@ export type SaveStatus = {
 export function Editor() {
   const [portfolio, setPortfolio] = useState<Portfolio|string|null>(null);
   const [saveStatus, setSaveStatus] = useState<SaveStatus|null>(null);
+  // loads counts the times the portfolio was loaded, so the editor starts over
+  // when a published draft replaces it.
+  const [loads, setLoads] = useState(0);
 
   const statusMessage = (s: SaveStatus) => ('info' in s) ? s.info : s.error;
 
@@ -88,7 +96,7 @@ export function Editor() {
         console.log(error);
       }
     })();
-  }, []);
+  }, [loads]);
 
   if (portfolio === null) {
     return null;
@@ -119,15 +127,16 @@ export function Editor() {
   };
 
   return <>
+  <DraftNotice onPublished={() => setLoads(loads + 1)} />
   <Tabs style="fullWidth" className="editor-tabs gap-0" onActiveTabChange={e => {
     setSaveStatus(null);
-    if (e === 3) window.location.href = `${endpoint}/api/logout`;
+    if (e === 7) window.location.href = `${endpoint}/api/logout`;
   }}>
     <Tabs.Item active title="Editor" className="py-3" icon={HiOutlinePencilAlt}>
-      <PortfolioComponent initialPortfolio={portfolio} setPortfolio={updatePortfolio} />
+      <PortfolioComponent key={loads} initialPortfolio={portfolio} setPortfolio={updatePortfolio} />
     </Tabs.Item>
     <Tabs.Item title="Public Mode" icon={HiGlobeAmericas}>
-      <PortfolioComponent initialPortfolio={portfolio} setPortfolio={null} />
+      <PortfolioComponent key={loads} initialPortfolio={portfolio} setPortfolio={null} />
     </Tabs.Item>
     <Tabs.Item title="Theme Editor" icon={HiPaintBrush}>
       <div className="flex max-w-md flex-col gap-2 mt-8 m-auto">
@@ -144,6 +153,18 @@ export function Editor() {
         <ColorPicker portfolio={portfolio} setPortfolio={updatePortfolio} field={"accentColor"} />
       </div>
     </Tabs.Item>
+    <Tabs.Item title="Blog" icon={HiNewspaper}>
+      <BlogSettings />
+    </Tabs.Item>
+    <Tabs.Item title="Accounts" icon={HiUserGroup}>
+      <AccountSettings />
+    </Tabs.Item>
+    <Tabs.Item title="Passkeys" icon={HiFingerPrint}>
+      <PasskeySettings />
+    </Tabs.Item>
+    <Tabs.Item title="Tokens" icon={HiKey}>
+      <TokenSettings />
+    </Tabs.Item>
     <Tabs.Item title="Logout" onClick={() => window.location.href = `${endpoint}/api/logout`}>
     </Tabs.Item>
   </Tabs>
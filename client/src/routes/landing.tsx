@@ -1,11 +1,23 @@
 import { Button, Spinner } from "flowbite-react";
 import { ChangeEvent, useEffect, useRef, useState } from "react";
 import { useNavigate } from "react-router-dom";
-import { checkLoginStatus, endpoint } from "..";
+import { checkLoginStatus, endpoint, useAuthProviders } from "..";
+import { logInWithPasskey } from "../components/Passkeys";
 
 export function Landing() {
   const navigate = useNavigate();
   const nameRef = useRef<HTMLInputElement|null>(null);
+  const providers = useAuthProviders();
+  const [passkeyError, setPasskeyError] = useState<string|null>(null);
+
+  const passkeyLogin = async () => {
+    try {
+      await logInWithPasskey();
+      navigate("/editor");
+    } catch (e) {
+      setPasskeyError(`${e}`);
+    }
+  };
 
   const signup = () => {
     if (nameRef.current !== null && nameRef.current.value !== "") {
@@ -59,7 +71,10 @@ return (
         </div>
         <div className="flex flex-col items-center gap-2">
           <Button gradientDuoTone="purpleToBlue" onClick={signup}>Claim your username →</Button>
-          <a className="text-sm text-gray-600 underline" href={`${endpoint}/auth/google/login`}>or login with Google</a>
+          {providers.map(p => <a key={p.name} className="text-sm text-gray-600 underline" href={`${endpoint}/auth/${p.name}/login`}>or login with {p.title}</a>)}
+          <a className="text-sm text-gray-600 underline" href="/login/email">or login with email</a>
+          <button className="text-sm text-gray-600 underline" onClick={passkeyLogin}>or login with a passkey</button>
+          {passkeyError ? <span className="text-sm text-red-700">{passkeyError}</span> : null}
         </div>
       </div>
 
@@ -83,11 +98,15 @@ return (
 
       <div className="text-center mb-16">
         <h3 className="text-2xl font-semibold text-gray-800 mb-4">Join thousands of professionals showcasing their work</h3>
+        <a className="text-blue-500 hover:underline" href="/directory">Browse their portfolios</a>
       </div>
 
       <footer className="text-center text-gray-600">
         <p>&copy; 2024 foliospot.io. All rights reserved.</p>
-        <a className="text-blue-500 hover:underline" href={`${endpoint}/auth/google/login`}>Log in with Google</a>
+        <div className="flex justify-center gap-4">
+          {providers.map(p => <a key={p.name} className="text-blue-500 hover:underline" href={`${endpoint}/auth/${p.name}/login`}>Log in with {p.title}</a>)}
+          <a className="text-blue-500 hover:underline" href="/login/email">Log in with email</a>
+        </div>
       </footer>
     </div>
   </div>
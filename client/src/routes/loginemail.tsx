@@ -0,0 +1,87 @@
+import { Alert, Button, Label, TextInput } from "flowbite-react";
+import React, { FormEvent, useState } from "react";
+import { useLocation } from "react-router-dom";
+import { endpoint, errorMessage, isError } from "..";
+
+// requestLoginLink emails a login link to email, signing up as username if
+// one is given. It returns an error message, or null if the link was sent.
+export async function requestLoginLink(email: string, username?: string, returnTo?: string): Promise<string|null> {
+  try {
+    const resp = await fetch(`${endpoint}/api/request_login_link`, {
+      method: "POST",
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({email, username, returnTo}),
+      credentials: "include",
+      mode: "cors"
+    });
+    if (!resp.ok) {
+      const body = await resp.json().catch(() => null);
+      return isError(body) ? errorMessage(body) : resp.statusText;
+    }
+    return null;
+  } catch (error) {
+    return `${error}`;
+  }
+}
+
+// EmailLogin asks for an address to send a login link to or, opened from such
+// a link, logs in with it. Logging in takes a click, so that mail scanners
+// opening the link do not use it up, and another if the link was asked for
+// from a different browser.
+export function EmailLogin() {
+  const location = useLocation();
+  const queryParams = new URLSearchParams(location.search);
+  const token = queryParams.get("token");
+  const confirm = queryParams.get("confirm");
+  const expired = !!queryParams.get("expired");
+
+  const [email, setEmail] = useState("");
+  const [status, setStatus] = useState<"sent"|string|null>(null);
+
+  if (token && confirm) {
+    return <form className="flex max-w-md flex-col gap-4 mt-8 m-auto" action={`${endpoint}/auth/email/login`} method="POST">
+      <h1 className="text-xl font-bold">Log in as {queryParams.get("email")}?</h1>
+      <Alert color="warning">
+        This login link was asked for from a different browser. Only continue if you asked for it yourself,
+        or you will be logged into someone else's account.
+      </Alert>
+      <input type="hidden" name="token" value={token} />
+      <input type="hidden" name="confirm" value={confirm} />
+      <Button type="submit">Log in →</Button>
+    </form>;
+  }
+
+  if (token) {
+    return <form className="flex max-w-md flex-col gap-4 mt-8 m-auto" action={`${endpoint}/auth/email/login`} method="POST">
+      <h1 className="text-xl font-bold">Log in to foliospot</h1>
+      <input type="hidden" name="token" value={token} />
+      <Button type="submit">Continue →</Button>
+    </form>;
+  }
+
+  const submit = async (e: FormEvent) => {
+    e.preventDefault();
+    setStatus(await requestLoginLink(email) ?? "sent");
+  };
+
+  if (status === "sent") {
+    return <p className="max-w-md m-auto mt-8">Check your inbox for a link to log in. It works for 15 minutes.</p>;
+  }
+
+  return <form className="flex max-w-md flex-col gap-4 mt-8 m-auto" onSubmit={submit}>
+    {
+      expired
+        ? <Alert color="failure">That login link has expired or was already used. Ask for a new one below.</Alert>
+        : null
+    }
+    <h1 className="text-xl font-bold">Log in with email</h1>
+    <div>
+      <div className="mb-2 block">
+        <Label htmlFor="email" value="Email" />
+      </div>
+      <TextInput id="email" type="email" required value={email} onChange={e => setEmail(e.target.value)} />
+    </div>
+    <Button type="submit" color="light">Email me a login link →</Button>
+    {status ? <span className="text-red-700">{status}</span> : null}
+  </form>;
+}
@@ -0,0 +1,84 @@
+import { useEffect, useState } from "react";
+import { Link, useParams } from "react-router-dom";
+import Markdown from "react-markdown";
+import { endpoint, errorMessage, isError, publicCredentials } from "..";
+import { Font, Project } from "../types/portfolio";
+import { defaultTheme } from "../themes/theme";
+
+type PermalinkedProject = {
+  project: Project,
+  section: string,
+  owner: {
+    username: string,
+    firstName: string,
+    lastName: string,
+    portfolioURL: string,
+  },
+  theme: {
+    sidebarColor: string,
+    backgroundColor: string,
+    projectColor: string,
+    accentColor: string,
+    font: Font,
+  },
+};
+
+// ProjectPage is the permalink page of one project, shown in its owner's
+// colors.
+export function ProjectPage() {
+  const {id = ""} = useParams();
+  const [found, setFound] = useState<PermalinkedProject|string|null>(null);
+
+  useEffect(() => {
+    (async () => {
+      try {
+        const resp = await fetch(`${endpoint}/api/project?id=${encodeURIComponent(id)}`, {
+          method: "GET",
+          headers: {'Content-Type': 'application/json'},
+          credentials: publicCredentials,
+          mode: "cors"
+        });
+        const body = await resp.json().catch(() => null);
+        if (!resp.ok) {
+          setFound(isError(body) ? errorMessage(body) : resp.statusText);
+          return;
+        }
+        setFound(body);
+      } catch (error) {
+        console.log(error);
+      }
+    })();
+  }, [id]);
+
+  if (found === null) {
+    return null;
+  } else if (typeof found === "string") {
+    return <p>Error: {found}</p>
+  }
+
+  const {project, owner} = found;
+  const theme = defaultTheme({
+    ...found.theme,
+    firstName: owner.firstName,
+    lastName: owner.lastName,
+    location: "",
+    bio: "",
+    sections: [],
+  });
+  const name = `${owner.firstName} ${owner.lastName}`.trim() || owner.username;
+
+  return <div className={`${theme.holder} min-h-screen justify-center`}>
+    <article className="max-w-3xl w-full px-4 py-12">
+      <Link className="text-sm hover:underline" to={`/${owner.username}`}>← {name}'s portfolio</Link>
+      {found.section && <p className="mt-6 text-sm font-bold uppercase">{found.section}</p>}
+      <div className={`${theme.project.item} mt-2 p-8`}>
+        <h1 className="text-3xl font-black mb-4">{project.name}</h1>
+        {project.imageURL && <img className="rounded-xl max-w-full mb-6" src={project.imageURL} alt={project.name} />}
+        <Markdown className="unreset">{project.description}</Markdown>
+        {project.link && <a className="inline-block mt-6 font-bold underline" href={project.link} target="_blank" rel="noreferrer">
+          Visit project
+        </a>}
+      </div>
+    </article>
+  </div>
+}
@@ -0,0 +1,42 @@
+import { useEffect, useState } from "react";
+import { useParams } from "react-router-dom";
+import { Portfolio } from "../types/portfolio";
+import { endpoint, errorMessage, isError } from "..";
+import { PortfolioComponent } from "../components/Portfolio";
+
+// Shared shows a draft sent through a share link.
+export function Shared() {
+  const {token} = useParams();
+  const [portfolio, setPortfolio] = useState<Portfolio|string|null>(null);
+
+  useEffect(() => {
+    (async () => {
+      let url = `${endpoint}/api/shared_portfolio?token=${encodeURIComponent(token ?? "")}`;
+      try {
+        let resp = await fetch(url, {
+          method: "GET",
+          headers: {'Content-Type': 'application/json'},
+          mode: "cors"
+        });
+
+        if (!resp.ok) {
+          const body = await resp.json().catch(() => null);
+          setPortfolio(isError(body) ? errorMessage(body) : resp.statusText);
+          return;
+        }
+
+        setPortfolio(await resp.json());
+      } catch (error) {
+        console.log(error);
+      }
+    })();
+  }, []);
+
+  if (portfolio === null) {
+    return null;
+  } else if (typeof portfolio === "string") {
+    return <p>Error: {portfolio}</p>
+  }
+
+  return <PortfolioComponent initialPortfolio={portfolio} setPortfolio={null} />
+}
@@ -1,6 +1,7 @@
 import {Alert, Button, Checkbox, Label, TextInput} from "flowbite-react";
 import React, { ChangeEvent, useEffect, useRef, useState } from "react";
-import { endpoint } from "..";
+import { endpoint, useAuthProviders } from "..";
+import { requestLoginLink } from "./loginemail";
 import { Form, useLocation } from "react-router-dom";
 
 enum FormStatus {
@@ -15,7 +16,7 @@ export function Signup() {
   const queryParams = new URLSearchParams(location.search);
 
   const finish = !!queryParams.get("finish");
-  const error = !!queryParams.get("error");
+  const error = queryParams.get("error");
 
   const [username, setUsername] = useState(queryParams.get("name") ?? "");
   const [helper, setHelper] = useState("");
@@ -23,6 +24,15 @@ export function Signup() {
   const lastRequestID = useRef(0);
 
   const [signupError, setSignupError] = useState<string|null>(null);
+  const providers = useAuthProviders();
+  const [email, setEmail] = useState("");
+  const [emailSent, setEmailSent] = useState(false);
+
+  const signupWithEmail = async () => {
+    const error = await requestLoginLink(email, username, "/editor");
+    setSignupError(error);
+    setEmailSent(error === null);
+  };
 
   const checkUsername = async (username: string) => {
     const currentRequestID = ++lastRequestID.current;
@@ -63,8 +73,8 @@ export function Signup() {
       return;
     }
 
-    if (!/^[a-zA-Z0-9_-]+$/.test(username)) {
-      setHelper("Username must only contain letters, numbers, underscores, and dashes.")
+    if (!/^[a-z0-9]([a-z0-9-]*[a-z0-9])?$/.test(username)) {
+      setHelper("Username must only contain lowercase letters, numbers, and dashes, and cannot start or end with a dash.")
       setStatus(FormStatus.Bad);
       return;
     }
@@ -92,7 +102,7 @@ export function Signup() {
   return <div>
     <form
       className="flex max-w-md flex-col gap-4 mt-8 m-auto"
-      action={`${endpoint}/auth/google/signup`}
+      action={providers.length > 0 ? `${endpoint}/auth/${providers[0].name}/signup` : undefined}
       method="GET"
     >
     {
@@ -101,7 +111,12 @@ export function Signup() {
         : null
     }
     {
-      error
+      error === "email_taken"
+        ? <Alert color="failure">
+            An account already uses this email address. Log in to it, then link this account from the Accounts
+            tab of the editor.
+          </Alert>
+        : error
         ? <Alert color="failure">An error occurred while signing you up. Please try again.</Alert>
         : null
     }
@@ -122,12 +137,25 @@ export function Signup() {
           </p>}
         />
       </div>
-      <Button color="light" type="submit" disabled={status === FormStatus.Bad || status === FormStatus.Checking}>
+      {providers.map(p => <Button key={p.name} color="light" type="submit"
+        formAction={`${endpoint}/auth/${p.name}/signup`}
+        disabled={status === FormStatus.Bad || status === FormStatus.Checking}>
         <div className="flex flex-row gap-2">
-          <img className="h-6" src="/icons/icons8-google.svg" />
-          <span>Sign up with Google →</span>
+          {p.name === "google" && <img className="h-6" src="/icons/icons8-google.svg" />}
+          <span>Sign up with {p.title} →</span>
         </div>
+      </Button>)}
+      <div>
+        <div className="mb-2 block">
+          <Label htmlFor="email" value="Or sign up with your email" />
+        </div>
+        <TextInput id="email" type="email" value={email} onChange={e => setEmail(e.target.value)} />
+      </div>
+      <Button color="light" type="button" onClick={signupWithEmail}
+        disabled={email === "" || status !== FormStatus.Good}>
+        Email me a sign-up link →
       </Button>
+      {emailSent ? <span>Check your inbox for a link to finish signing up. It works for 15 minutes.</span> : null}
       {signupError ? <span className="text-red-700">Error signing up: {signupError}</span> : null}
     </form>
   </div>;
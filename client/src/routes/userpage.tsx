@@ -1,26 +1,62 @@
-import { useEffect, useState } from "react";
-import { useParams } from "react-router-dom";
+import { FormEvent, useEffect, useState } from "react";
+import { useNavigate, useParams } from "react-router-dom";
+import { Button, Label, Textarea, TextInput } from "flowbite-react";
 import { Portfolio } from "../types/portfolio";
-import { endpoint } from "..";
+import { endpoint, errorMessage, isError, publicCredentials } from "..";
 import { PortfolioComponent } from "../components/Portfolio";
 
-export function Userpage() {
-  const {userid} = useParams();
-  const [portfolio, setPortfolio] = useState<Portfolio|string|null>(null);
+type Locked = { locked: true, error?: string };
+
+function isLocked(e: unknown): boolean {
+  return isError(e) && e.errorCode === 401
+    && "errorData" in e && !!e.errorData && typeof e.errorData === "object"
+    && "protected" in e.errorData && e.errorData.protected === true;
+}
+
+// movedTo returns the new username of a user who changed theirs.
+function movedTo(e: unknown): string | null {
+  if (isError(e) && e.errorCode === 301
+    && "errorData" in e && !!e.errorData && typeof e.errorData === "object"
+    && "username" in e.errorData && typeof e.errorData.username === "string") {
+    return e.errorData.username;
+  }
+  return null;
+}
+
+export function Userpage({username}: {username?: string}) {
+  const params = useParams();
+  const userid = username ?? params.userid;
+  const [portfolio, setPortfolio] = useState<Portfolio|Locked|string|null>(null);
+  const [grant, setGrant] = useState(() => sessionStorage.getItem(`grant:${userid}`));
+  const navigate = useNavigate();
 
   useEffect(() => {
     (async () => {
       let url = `${endpoint}/api/get_portfolio?username=${userid}`;
+      if (document.referrer) {
+        url += `&ref=${encodeURIComponent(document.referrer)}`;
+      }
+      if (grant) {
+        url += `&grant=${encodeURIComponent(grant)}`;
+      }
       try {
         let resp = await fetch(url, {
           method: "GET",
           headers: {'Content-Type': 'application/json'},
-          credentials: "include",
+          credentials: publicCredentials,
           mode: "cors"
         });
 
         if (!resp.ok) {
-          setPortfolio(await resp.text());
+          const body = await resp.json().catch(() => null);
+          const moved = movedTo(body);
+          if (moved) {
+            navigate(`/${moved}`, {replace: true});
+          } else if (isLocked(body)) {
+            setPortfolio({locked: true});
+          } else {
+            setPortfolio(isError(body) ? errorMessage(body) : resp.statusText);
+          }
           return;
         }
 
@@ -29,13 +65,117 @@ export function Userpage() {
         console.log(error);
       }
     })();
-  }, []);
+  }, [grant, userid]);
+
+  const unlock = async (password: string) => {
+    const resp = await fetch(`${endpoint}/api/unlock_portfolio`, {
+      method: "POST",
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({username: userid, password}),
+      mode: "cors"
+    });
+    const body = await resp.json().catch(() => null);
+    if (!resp.ok) {
+      setPortfolio({locked: true, error: isError(body) ? body.errorMessage : resp.statusText});
+      return;
+    }
+    sessionStorage.setItem(`grant:${userid}`, body.grant);
+    setGrant(body.grant);
+  };
 
   if (portfolio === null) {
     return null;
   } else if (typeof portfolio === "string") {
     return <p>Error: {portfolio}</p>
+  } else if ("locked" in portfolio) {
+    return <PasswordPrompt error={portfolio.error} unlock={unlock} />
+  }
+
+  // Links go through the server so the owner can see which projects get
+  // clicked.
+  const linkHref = (i: number, j: number, link: string) => {
+    const id = portfolio.sections[i].projects[j].id;
+    if (!id) {
+      return link;
+    }
+    let href = `${endpoint}/p/${encodeURIComponent(id)}/go`;
+    if (grant) {
+      href += `?grant=${encodeURIComponent(grant)}`;
+    }
+    return href;
+  };
+
+  return <>
+    <PortfolioComponent initialPortfolio={portfolio} setPortfolio={null} linkHref={linkHref} permalinks />
+    <ContactForm username={userid ?? ""} grant={grant} />
+  </>
+}
+
+function ContactForm({username, grant}: {username: string, grant: string|null}) {
+  const [name, setName] = useState("");
+  const [email, setEmail] = useState("");
+  const [message, setMessage] = useState("");
+  const [website, setWebsite] = useState("");
+  const [status, setStatus] = useState<"sent"|string|null>(null);
+
+  const submit = async (e: FormEvent) => {
+    e.preventDefault();
+    let url = `${endpoint}/api/contact`;
+    if (grant) {
+      url += `?grant=${encodeURIComponent(grant)}`;
+    }
+    const resp = await fetch(url, {
+      method: "POST",
+      headers: {'Content-Type': 'application/json'},
+      body: JSON.stringify({username, name, email, message, website}),
+      credentials: publicCredentials,
+      mode: "cors"
+    });
+    if (!resp.ok) {
+      const body = await resp.json().catch(() => null);
+      setStatus(isError(body) ? errorMessage(body) : resp.statusText);
+      return;
+    }
+    setStatus("sent");
+  };
+
+  if (status === "sent") {
+    return <p className="max-w-md m-auto my-16">Thanks, your message was sent.</p>
   }
 
-  return <PortfolioComponent initialPortfolio={portfolio} setPortfolio={null} />
-}
\ No newline at end of file
+  return <form className="flex max-w-md flex-col gap-4 m-auto my-16" onSubmit={submit}>
+    <h2 className="text-xl font-bold">Get in touch</h2>
+    <Label htmlFor="contact-name" value="Name" />
+    <TextInput id="contact-name" required maxLength={100} value={name}
+      onChange={e => setName(e.target.value)} />
+    <Label htmlFor="contact-email" value="Email" />
+    <TextInput id="contact-email" type="email" required value={email}
+      onChange={e => setEmail(e.target.value)} />
+    {/* Hidden from people; bots that fill it in are ignored. */}
+    <input className="hidden" tabIndex={-1} autoComplete="off" aria-hidden="true"
+      name="website" value={website} onChange={e => setWebsite(e.target.value)} />
+    <Label htmlFor="contact-message" value="Message" />
+    <Textarea id="contact-message" required rows={5} maxLength={5000} value={message}
+      onChange={e => setMessage(e.target.value)} />
+    {status && <p className="text-red-600">{status}</p>}
+    <Button type="submit">Send</Button>
+  </form>
+}
+
+function PasswordPrompt({error, unlock}: {error?: string, unlock: (password: string) => void}) {
+  const [password, setPassword] = useState("");
+
+  const submit = (e: FormEvent) => {
+    e.preventDefault();
+    unlock(password);
+  };
+
+  return <form className="flex max-w-md flex-col gap-4 mt-16 m-auto" onSubmit={submit}>
+    <h1 className="text-2xl font-bold">This portfolio is password protected</h1>
+    <Label htmlFor="password" value="Password" />
+    <TextInput id="password" type="password" required value={password}
+      color={error ? "failure" : undefined} helperText={error}
+      onChange={e => setPassword(e.target.value)} />
+    <Button type="submit">View portfolio</Button>
+  </form>
+}
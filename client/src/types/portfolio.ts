@@ -19,16 +19,27 @@ export type Section = {
 };
 
 export type Project = {
+  // id stays the same when the project is renamed or moved, and names its
+  // permalink page /p/{id}.
+  id?: string,
   name: string,
   description: string,
   link?: string,
   imageURL?: string,
 };
 
-export const defaultProject: Project = {
-  description: "", name: ""
-};
+const projectIDAlphabet = "abcdefghijklmnopqrstuvwxyz234567";
 
-export const defaultSection: Section = {
-  projects: [defaultProject], title: ""
-};
+// newProjectID returns a random project ID of the form the server expects.
+export function newProjectID(): string {
+  const bytes = crypto.getRandomValues(new Uint8Array(12));
+  return Array.from(bytes, b => projectIDAlphabet[b % projectIDAlphabet.length]).join("");
+}
+
+export function newProject(): Project {
+  return {id: newProjectID(), description: "", name: ""};
+}
+
+export function newSection(): Section {
+  return {projects: [newProject()], title: ""};
+}
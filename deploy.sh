@@ -13,5 +13,5 @@ npm run build
 rsync -urv build/ $REMOTE:$CLIENT_DIR
 cd ..
 
-scp server/main.go server/go.mod server/go.sum $REMOTE:$SERVER_DIR
-ssh -l $USERNAME $HOST "cd $SERVER_DIR ; go build"
\ No newline at end of file
+rsync -urv --include '*/' --include '*.go' --include 'templates/***' --include 'fonts/***' --include go.mod --include go.sum --exclude '*' server/ $REMOTE:$SERVER_DIR
+ssh -l $USERNAME $HOST "cd $SERVER_DIR ; go build -tags sqlite_fts5"
\ No newline at end of file
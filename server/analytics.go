@@ -0,0 +1,416 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/phuslu/iploc"
+	"nmilo.ca/portfolio/apis"
+)
+
+// Analytics count views of public portfolios without keeping anything that
+// identifies a visitor. A visitor is a hash of their IP address and user agent
+// with a salt that changes every day and is deleted the day after, so the same
+// visitor can be counted once per day but cannot be followed across days or
+// traced back to an address. Each day's views are rolled up into totals once
+// the day is over, and the individual views are deleted. Clicks on project
+// links are counted per day as they happen.
+
+const analyticsDay = "2006-01-02"
+
+const (
+	maxAnalyticsDays     = 366
+	defaultAnalyticsDays = 30
+	analyticsRollupEvery = time.Hour
+)
+
+// Device classes of a view.
+const (
+	deviceDesktop = "desktop"
+	deviceMobile  = "mobile"
+	deviceTablet  = "tablet"
+	deviceBot     = "bot"
+)
+
+var botMarkers = []string{"bot", "crawl", "spider", "slurp", "preview", "fetch", "headless", "curl", "wget", "python", "go-http-client"}
+
+// deviceClass guesses the kind of device a user agent is on.
+func deviceClass(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+	for _, marker := range botMarkers {
+		if strings.Contains(ua, marker) {
+			return deviceBot
+		}
+	}
+	switch {
+	case ua == "":
+		return deviceBot
+	case strings.Contains(ua, "ipad") || strings.Contains(ua, "tablet") || strings.Contains(ua, "android") && !strings.Contains(ua, "mobile"):
+		return deviceTablet
+	case strings.Contains(ua, "mobi") || strings.Contains(ua, "iphone"):
+		return deviceMobile
+	default:
+		return deviceDesktop
+	}
+}
+
+// referrerDomain returns the host of referrer, or "" if there is none or it
+// is one of the site's own pages.
+func referrerDomain(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+	if isOwnHost(host) || baseDomain != "" && (host == baseDomain || strings.HasSuffix(host, "."+baseDomain)) {
+		return ""
+	}
+	return host
+}
+
+// visitorSalt returns the salt of day, creating it if needed and deleting
+// the salts of earlier days.
+func visitorSalt(day string) (string, error) {
+	var salt string
+	err := db.QueryRow(`SELECT salt FROM analytics_salts WHERE day = ?;`, day).Scan(&salt)
+	if err == nil {
+		return salt, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	var b [32]byte
+	Must(rand.Read(b[:]))
+	if _, err := db.Exec(`INSERT OR IGNORE INTO analytics_salts (day, salt) VALUES (?, ?);`, day, hex.EncodeToString(b[:])); err != nil {
+		return "", err
+	}
+	if _, err := db.Exec(`DELETE FROM analytics_salts WHERE day < ?;`, day); err != nil {
+		return "", err
+	}
+
+	err = db.QueryRow(`SELECT salt FROM analytics_salts WHERE day = ?;`, day).Scan(&salt)
+	return salt, err
+}
+
+// recordView records a view of the portfolio of the user with UUID id by the
+// visitor making r. referrer is the page the visitor came from. Views by
+// bots are not recorded.
+func recordView(r *http.Request, id uuid.UUID, referrer string) error {
+	userAgent := r.UserAgent()
+	device := deviceClass(userAgent)
+	if device == deviceBot {
+		return nil
+	}
+
+	day := time.Now().UTC().Format(analyticsDay)
+	salt, err := visitorSalt(day)
+	if err != nil {
+		return err
+	}
+
+	ip := remoteIP(r)
+	sum := sha256.Sum256([]byte(salt + "\x00" + id.String() + "\x00" + ip + "\x00" + userAgent))
+	visitor := hex.EncodeToString(sum[:16])
+
+	var country string
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		country = iploc.IPCountry(addr)
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO page_views (user_uuid, day, visitor, referrer, country, device)
+		VALUES (?, ?, ?, ?, ?, ?);
+	`, id.String(), day, visitor, referrerDomain(referrer), country, device)
+	return err
+}
+
+// recordPublicView records a view of the portfolio of username through r,
+// unless it is its owner looking at it. Errors are only logged, since they
+// should not stop the portfolio from being shown.
+func recordPublicView(r *http.Request, username string) {
+	var idstr string
+	if err := db.QueryRow(`SELECT uuid FROM users WHERE username = ?;`, username).Scan(&idstr); err != nil {
+		return
+	}
+
+	id, err := uuid.Parse(idstr)
+	if err != nil {
+		return
+	}
+
+	if login, err := getLogin(r, scopeRead); err == nil && login == id {
+		return
+	}
+
+	// The client passes on the page's own referrer, since the request's
+	// Referer is the portfolio page itself.
+	referrer := r.URL.Query().Get("ref")
+	if referrer == "" {
+		referrer = r.Referer()
+	}
+
+	if err := recordView(r, id, referrer); err != nil {
+		log.Printf("error recording view of %s: %v\n", username, err)
+	}
+}
+
+// rollupAnalytics adds the views of every day before today to the daily
+// totals, and deletes them.
+func rollupAnalytics() error {
+	today := time.Now().UTC().Format(analyticsDay)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO analytics_daily (user_uuid, day, views, visitors)
+		SELECT user_uuid, day, COUNT(*), COUNT(DISTINCT visitor)
+		FROM page_views WHERE day < ?
+		GROUP BY user_uuid, day
+		ON CONFLICT (user_uuid, day) DO UPDATE SET
+			views = views + excluded.views,
+			visitors = visitors + excluded.visitors;
+	`, today); err != nil {
+		return err
+	}
+
+	for _, dimension := range []string{"referrer", "country", "device"} {
+		if _, err := tx.Exec(fmt.Sprintf(`
+			INSERT INTO analytics_breakdown (user_uuid, day, dimension, value, views)
+			SELECT user_uuid, day, '%[1]s', %[1]s, COUNT(*)
+			FROM page_views WHERE day < ?
+			GROUP BY user_uuid, day, %[1]s
+			ON CONFLICT (user_uuid, day, dimension, value) DO UPDATE SET
+				views = views + excluded.views;
+		`, dimension), today); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM page_views WHERE day < ?;`, today); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// runAnalyticsRollups rolls up analytics every analyticsRollupEvery, forever.
+func runAnalyticsRollups() {
+	for {
+		if err := rollupAnalytics(); err != nil {
+			log.Printf("error rolling up analytics: %v\n", err)
+		}
+		time.Sleep(analyticsRollupEvery)
+	}
+}
+
+type analyticsDayTotal struct {
+	Day      string `json:"day"`
+	Views    int64  `json:"views"`
+	Visitors int64  `json:"visitors"`
+}
+
+type analyticsCount struct {
+	Value string `json:"value"`
+	Views int64  `json:"views"`
+}
+
+type analyticsProjectClicks struct {
+	Project string `json:"project"`
+	Name    string `json:"name"`
+	Clicks  int64  `json:"clicks"`
+}
+
+type analyticsReport struct {
+	From      string                   `json:"from"`
+	To        string                   `json:"to"`
+	Views     int64                    `json:"views"`
+	Visitors  int64                    `json:"visitors"`
+	Days      []analyticsDayTotal      `json:"days"`
+	Referrers []analyticsCount         `json:"referrers"`
+	Countries []analyticsCount         `json:"countries"`
+	Devices   []analyticsCount         `json:"devices"`
+	Clicks    int64                    `json:"clicks"`
+	Projects  []analyticsProjectClicks `json:"projects"`
+}
+
+// analyticsRange returns the range of days asked for by the from and to
+// query parameters of r, which default to the last defaultAnalyticsDays days.
+func analyticsRange(r *http.Request) (string, string, error) {
+	to := time.Now().UTC()
+	if s := r.URL.Query().Get("to"); s != "" {
+		t, err := time.Parse(analyticsDay, s)
+		if err != nil {
+			return "", "", apis.NewError("to must be a date like 2006-01-02", http.StatusBadRequest)
+		}
+		to = t
+	}
+
+	from := to.AddDate(0, 0, 1-defaultAnalyticsDays)
+	if s := r.URL.Query().Get("from"); s != "" {
+		t, err := time.Parse(analyticsDay, s)
+		if err != nil {
+			return "", "", apis.NewError("from must be a date like 2006-01-02", http.StatusBadRequest)
+		}
+		from = t
+	}
+
+	if from.After(to) {
+		return "", "", apis.NewError("from must not be after to", http.StatusBadRequest)
+	}
+	if to.Sub(from) >= maxAnalyticsDays*24*time.Hour {
+		return "", "", apis.NewError(fmt.Sprintf("at most %d days can be asked for at once", maxAnalyticsDays), http.StatusBadRequest)
+	}
+
+	return from.Format(analyticsDay), to.Format(analyticsDay), nil
+}
+
+// analyticsHandler returns the logged in user's analytics for a range of
+// days. Today's views are counted from the views not yet rolled up.
+func analyticsHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeRead)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to, err := analyticsRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	report := analyticsReport{
+		From:      from,
+		To:        to,
+		Days:      make([]analyticsDayTotal, 0),
+		Referrers: make([]analyticsCount, 0),
+		Countries: make([]analyticsCount, 0),
+		Devices:   make([]analyticsCount, 0),
+		Projects:  make([]analyticsProjectClicks, 0),
+	}
+
+	rows, err := db.Query(`
+		SELECT day, SUM(views), SUM(visitors) FROM (
+			SELECT day, views, visitors FROM analytics_daily
+			WHERE user_uuid = ? AND day BETWEEN ? AND ?
+			UNION ALL
+			SELECT day, COUNT(*), COUNT(DISTINCT visitor) FROM page_views
+			WHERE user_uuid = ? AND day BETWEEN ? AND ?
+			GROUP BY day
+		)
+		GROUP BY day
+		ORDER BY day;
+	`, id.String(), from, to, id.String(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d analyticsDayTotal
+		if err := rows.Scan(&d.Day, &d.Views, &d.Visitors); err != nil {
+			return nil, err
+		}
+		report.Views += d.Views
+		report.Visitors += d.Visitors
+		report.Days = append(report.Days, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	breakdowns := map[string]*[]analyticsCount{
+		"referrer": &report.Referrers,
+		"country":  &report.Countries,
+		"device":   &report.Devices,
+	}
+	for dimension, counts := range breakdowns {
+		if *counts, err = analyticsBreakdown(id, dimension, from, to); err != nil {
+			return nil, err
+		}
+	}
+
+	if report.Projects, err = analyticsProjects(id, from, to); err != nil {
+		return nil, err
+	}
+	for _, p := range report.Projects {
+		report.Clicks += p.Clicks
+	}
+
+	return report, nil
+}
+
+// analyticsProjects returns the clicks on each project of the user with UUID
+// id between from and to, most clicked first. Projects are named as they were
+// when last clicked.
+func analyticsProjects(id uuid.UUID, from, to string) ([]analyticsProjectClicks, error) {
+	rows, err := db.Query(`
+		SELECT project, name, total FROM (
+			SELECT project, name, SUM(clicks) OVER (PARTITION BY project) AS total,
+				ROW_NUMBER() OVER (PARTITION BY project ORDER BY day DESC) AS latest
+			FROM project_clicks
+			WHERE user_uuid = ? AND day BETWEEN ? AND ?
+		)
+		WHERE latest = 1
+		ORDER BY total DESC, project;
+	`, id.String(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	projects := make([]analyticsProjectClicks, 0)
+	for rows.Next() {
+		var p analyticsProjectClicks
+		if err := rows.Scan(&p.Project, &p.Name, &p.Clicks); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// analyticsBreakdown returns the views of the user with UUID id between from
+// and to, counted by dimension, most viewed first.
+func analyticsBreakdown(id uuid.UUID, dimension, from, to string) ([]analyticsCount, error) {
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT value, SUM(views) AS total FROM (
+			SELECT value, views FROM analytics_breakdown
+			WHERE user_uuid = ? AND dimension = '%[1]s' AND day BETWEEN ? AND ?
+			UNION ALL
+			SELECT %[1]s, COUNT(*) FROM page_views
+			WHERE user_uuid = ? AND day BETWEEN ? AND ?
+			GROUP BY %[1]s
+		)
+		GROUP BY value
+		ORDER BY total DESC, value;
+	`, dimension), id.String(), from, to, id.String(), from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make([]analyticsCount, 0)
+	for rows.Next() {
+		var c analyticsCount
+		if err := rows.Scan(&c.Value, &c.Views); err != nil {
+			return nil, err
+		}
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
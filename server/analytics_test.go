@@ -0,0 +1,120 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceClass(t *testing.T) {
+	for _, test := range []struct {
+		userAgent, want string
+	}{
+		{"", deviceBot},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", deviceBot},
+		{"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)", deviceDesktop},
+		{"Slackbot-LinkExpanding 1.0 (+https://api.slack.com/robots)", deviceBot},
+		{"curl/8.4.0", deviceBot},
+		{"Go-http-client/1.1", deviceBot},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36", deviceDesktop},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_4) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15", deviceDesktop},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", deviceMobile},
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Mobile Safari/537.36", deviceMobile},
+		{"Mozilla/5.0 (iPad; CPU OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", deviceTablet},
+		{"Mozilla/5.0 (Linux; Android 14; SM-X710) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36", deviceTablet},
+	} {
+		if got := deviceClass(test.userAgent); got != test.want {
+			t.Errorf("deviceClass(%q) = %q, want %q", test.userAgent, got, test.want)
+		}
+	}
+}
+
+func TestReferrerDomain(t *testing.T) {
+	oldFrontend, oldBackend, oldBaseDomain := frontend, backend, baseDomain
+	t.Cleanup(func() { frontend, backend, baseDomain = oldFrontend, oldBackend, oldBaseDomain })
+	frontend = "https://foliospot.io"
+	backend = "https://api.foliospot.io"
+	baseDomain = "foliospot.dev"
+
+	for _, test := range []struct {
+		referrer, want string
+	}{
+		{"", ""},
+		{"not a url", ""},
+		{"https://www.google.com/search?q=ada", "google.com"},
+		{"https://News.YCombinator.com/item?id=1", "news.ycombinator.com"},
+		{"android-app://com.linkedin.android/", "com.linkedin.android"},
+		{"https://foliospot.io/directory", ""},
+		{"https://api.foliospot.io/", ""},
+		{"https://grace.foliospot.dev/", ""},
+		{"https://foliospot.dev/", ""},
+	} {
+		if got := referrerDomain(test.referrer); got != test.want {
+			t.Errorf("referrerDomain(%q) = %q, want %q", test.referrer, got, test.want)
+		}
+	}
+}
+
+const testBrowser = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36"
+
+// viewTestPortfolio views the public portfolio of username from ip with
+// userAgent, coming from referrer.
+func viewTestPortfolio(t *testing.T, username, ip, userAgent, referrer string) {
+	t.Helper()
+	r := sessionRequest(t, "GET", "/api/get_portfolio?username="+username+"&ref="+referrer, "")
+	r.RemoteAddr = ip + ":1234"
+	r.Header.Set("User-Agent", userAgent)
+	recordPublicView(r, username)
+}
+
+func testAnalytics(t *testing.T, id string) analyticsReport {
+	t.Helper()
+	from := time.Now().UTC().AddDate(0, 0, -1).Format(analyticsDay)
+	res, err := analyticsHandler(loggedInRequest(t, "GET", "/api/analytics?from="+from, "", id))
+	if err != nil {
+		t.Fatalf("getting analytics: %v", err)
+	}
+	return res.(analyticsReport)
+}
+
+func TestAnalytics(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+
+	viewTestPortfolio(t, "ada", "192.0.2.1", testBrowser, "https://news.example/item")
+	viewTestPortfolio(t, "ada", "192.0.2.1", testBrowser, "")
+	viewTestPortfolio(t, "ada", "192.0.2.2", testBrowser, "")
+	viewTestPortfolio(t, "ada", "192.0.2.3", "curl/8.4.0", "")
+
+	// The owner's own views are not counted.
+	r := loggedInRequest(t, "GET", "/api/get_portfolio?username=ada", "", ada)
+	r.Header.Set("User-Agent", testBrowser)
+	recordPublicView(r, "ada")
+
+	check := func(when string) {
+		t.Helper()
+		report := testAnalytics(t, ada)
+		if report.Views != 3 || report.Visitors != 2 {
+			t.Errorf("%s: got %d views by %d visitors, want 3 by 2", when, report.Views, report.Visitors)
+		}
+		if len(report.Referrers) == 0 || report.Referrers[0].Value != "" || report.Referrers[0].Views != 2 {
+			t.Errorf("%s: referrers = %+v, want 2 direct views first", when, report.Referrers)
+		}
+	}
+	check("before rolling up")
+
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(analyticsDay)
+	if _, err := db.Exec(`UPDATE page_views SET day = ?;`, yesterday); err != nil {
+		t.Fatal(err)
+	}
+	if err := rollupAnalytics(); err != nil {
+		t.Fatalf("rolling up: %v", err)
+	}
+	var left int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM page_views;`).Scan(&left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 0 {
+		t.Errorf("%d views are left after rolling up", left)
+	}
+	check("after rolling up")
+}
@@ -12,6 +12,7 @@ const sendErrorDetails = true
 // Handler is the main interface for an API server and stores a muxer.
 type Handler struct {
 	frontEndpoint string
+	allowOrigin   func(origin string) bool
 	mux           *http.ServeMux
 }
 
@@ -23,6 +24,23 @@ func NewHandler(frontEndpoint string) Handler {
 	}
 }
 
+// AllowOrigins lets cross-origin requests from origins other than the front
+// end through to handlers added with HandlePublicFunc if allow returns true
+// for them.
+func (h *Handler) AllowOrigins(allow func(origin string) bool) {
+	h.allowOrigin = allow
+}
+
+// corsOrigin returns the origin to allow requests to r from. Only public
+// handlers allow origins other than the front end.
+func (h *Handler) corsOrigin(r *http.Request, public bool) string {
+	origin := r.Header.Get("Origin")
+	if public && origin != "" && origin != h.frontEndpoint && h.allowOrigin != nil && h.allowOrigin(origin) {
+		return origin
+	}
+	return h.frontEndpoint
+}
+
 type sentError struct {
 	ErrorMessage string `json:"errorMessage"`
 	ErrorCode    int    `json:"errorCode"`
@@ -32,6 +50,17 @@ type sentError struct {
 // HandleFunc adds a new handler function to the Handler's muxer.
 // pattern is passed to [http.ServeMux.HandleFunc]
 func (h *Handler) HandleFunc(pattern string, method string, handler func(r *http.Request) (any, error)) {
+	h.handleFunc(pattern, method, handler, false)
+}
+
+// HandlePublicFunc is like HandleFunc, but for handlers that may also be
+// called from the origins allowed by AllowOrigins. Those origins are not
+// allowed to send credentials.
+func (h *Handler) HandlePublicFunc(pattern string, method string, handler func(r *http.Request) (any, error)) {
+	h.handleFunc(pattern, method, handler, true)
+}
+
+func (h *Handler) handleFunc(pattern string, method string, handler func(r *http.Request) (any, error), public bool) {
 	h.mux.HandleFunc(pattern, func(w http.ResponseWriter, r *http.Request) {
 		var displayedError HttpError
 		defer func() {
@@ -64,10 +93,14 @@ func (h *Handler) HandleFunc(pattern string, method string, handler func(r *http
 			}
 		}()
 
-		w.Header().Set("Access-Control-Allow-Origin", h.frontEndpoint)
+		origin := h.corsOrigin(r, public)
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
 		w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
-		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		if origin == h.frontEndpoint {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+// Users can write Markdown blog posts, shown at /blog/{username}. Posts are
+// drafts until published, and published posts only appear once their publish
+// date has passed, so posts can be scheduled. Posts of protected portfolios
+// need the same grant as the portfolio.
+
+const (
+	maxPostsPerUser     = 500
+	maxPostTitleLength  = 200
+	maxPostSlugLength   = 100
+	maxPostBodyLength   = 100000
+	maxPostTags         = 10
+	maxPostTagLength    = 32
+	blogPageSize        = 10
+	blogFeedSize        = 20
+	postExcerptWords    = 50
+	defaultPostSlugBase = "post"
+)
+
+type blogPost struct {
+	ID            string   `json:"id"`
+	Slug          string   `json:"slug"`
+	Title         string   `json:"title"`
+	Body          string   `json:"body,omitempty"`
+	Excerpt       string   `json:"excerpt"`
+	Tags          []string `json:"tags"`
+	CoverImageURL string   `json:"coverImageURL,omitempty"`
+	Published     bool     `json:"published"`
+	PublishDate   string   `json:"publishDate,omitempty"`
+	Created       string   `json:"created"`
+	Updated       string   `json:"updated"`
+}
+
+// blogPostColumns are scanned by scanBlogPost.
+const blogPostColumns = `id, slug, title, body, cover_image_url, published, publish_date, created, updated`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanBlogPost scans blogPostColumns from row, without the post's tags.
+func scanBlogPost(row rowScanner) (blogPost, error) {
+	var post blogPost
+	var publishDate sql.NullString
+	if err := row.Scan(&post.ID, &post.Slug, &post.Title, &post.Body, &post.CoverImageURL, &post.Published, &publishDate, &post.Created, &post.Updated); err != nil {
+		return blogPost{}, err
+	}
+	post.PublishDate = publishDate.String
+	post.Excerpt = excerpt(plainText(post.Body), postExcerptWords)
+	post.Tags = make([]string, 0)
+	return post, nil
+}
+
+// loadBlogPosts runs query, which selects blogPostColumns, and returns the
+// posts with their tags. Bodies are left out unless withBody is true.
+func loadBlogPosts(withBody bool, query string, args ...any) ([]blogPost, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]blogPost, 0)
+	for rows.Next() {
+		post, err := scanBlogPost(rows)
+		if err != nil {
+			return nil, err
+		}
+		if !withBody {
+			post.Body = ""
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range posts {
+		tags, err := db.Query(`SELECT tag FROM blog_post_tags WHERE post_id = ? ORDER BY position;`, posts[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		for tags.Next() {
+			var tag string
+			if err := tags.Scan(&tag); err != nil {
+				tags.Close()
+				return nil, err
+			}
+			posts[i].Tags = append(posts[i].Tags, tag)
+		}
+		if err := tags.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return posts, nil
+}
+
+// loadBlogPost returns the post with id of the user with UUID userID, or
+// [sql.ErrNoRows] if they have none.
+func loadBlogPost(userID uuid.UUID, id string) (blogPost, error) {
+	posts, err := loadBlogPosts(true, `SELECT `+blogPostColumns+` FROM blog_posts WHERE id = ? AND user_uuid = ?;`, id, userID.String())
+	if err != nil {
+		return blogPost{}, err
+	} else if len(posts) == 0 {
+		return blogPost{}, sql.ErrNoRows
+	}
+	return posts[0], nil
+}
+
+// blogPostURL returns the public URL of the post with slug by username.
+func blogPostURL(username, slug string) string {
+	return frontend + "/blog/" + url.PathEscape(username) + "/" + url.PathEscape(slug)
+}
+
+// blogUser returns the UUID of username for the public blog endpoints, which
+// need the same grant as the portfolio.
+func blogUser(r *http.Request, username string) (uuid.UUID, error) {
+	if err := requireUnlocked(r, username); err != nil {
+		return uuid.Nil, err
+	}
+
+	var idstr string
+	err := db.QueryRow(`SELECT uuid FROM users WHERE username = ?;`, username).Scan(&idstr)
+	if errors.Is(err, sql.ErrNoRows) {
+		if renamed, _, ok := renamedUsername(username); ok {
+			return uuid.Nil, errUsernameMoved(renamed)
+		}
+		return uuid.Nil, apis.StatusNotFound
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+
+	return uuid.Parse(idstr)
+}
+
+// isPublic is true for posts that are published and whose publish date has
+// passed. It takes the current time as its only parameter.
+const isPublic = `published = 1 AND publish_date <= ?`
+
+// blogPostsHandler lists the published posts of the user given by the
+// username query parameter, newest first, optionally only those with the tag
+// query parameter. Posts come without their bodies, in pages of blogPageSize
+// chosen by the page query parameter starting at 1.
+func blogPostsHandler(r *http.Request) (any, error) {
+	query := r.URL.Query()
+
+	id, err := blogUser(r, query.Get("username"))
+	if err != nil {
+		return nil, err
+	}
+
+	page := 1
+	if s := query.Get("page"); s != "" {
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			return nil, apis.NewError("page must be a positive integer", http.StatusBadRequest)
+		}
+	}
+
+	where := `user_uuid = ? AND ` + isPublic
+	args := []any{id.String(), time.Now().UTC().Format(time.RFC3339)}
+	if tag := query.Get("tag"); tag != "" {
+		where += ` AND id IN (SELECT post_id FROM blog_post_tags WHERE tag = ?)`
+		args = append(args, tag)
+	}
+
+	var total int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM blog_posts WHERE `+where+`;`, args...).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	posts, err := loadBlogPosts(false, `
+		SELECT `+blogPostColumns+` FROM blog_posts
+		WHERE `+where+`
+		ORDER BY publish_date DESC
+		LIMIT ? OFFSET ?;
+	`, append(args, blogPageSize, (page-1)*blogPageSize)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Posts    []blogPost `json:"posts"`
+		Total    int        `json:"total"`
+		Page     int        `json:"page"`
+		PageSize int        `json:"pageSize"`
+	}{posts, total, page, blogPageSize}, nil
+}
+
+// blogPostHandler returns the published post with the slug query parameter
+// by the user given by the username query parameter.
+func blogPostHandler(r *http.Request) (any, error) {
+	query := r.URL.Query()
+
+	id, err := blogUser(r, query.Get("username"))
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := loadBlogPosts(true, `
+		SELECT `+blogPostColumns+` FROM blog_posts
+		WHERE user_uuid = ? AND slug = ? AND `+isPublic+`;
+	`, id.String(), query.Get("slug"), time.Now().UTC().Format(time.RFC3339))
+	if err != nil {
+		return nil, err
+	} else if len(posts) == 0 {
+		return nil, apis.StatusNotFound
+	}
+
+	return posts[0], nil
+}
+
+// listPostsHandler returns all of the logged in user's posts, including
+// drafts, without their bodies. Most recently updated posts come first.
+func listPostsHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadBlogPosts(false, `
+		SELECT `+blogPostColumns+` FROM blog_posts WHERE user_uuid = ? ORDER BY updated DESC;
+	`, id.String())
+}
+
+// getPostHandler returns the logged in user's post given by the id query
+// parameter.
+func getPostHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeRead)
+	if err != nil {
+		return nil, err
+	}
+
+	post, err := loadBlogPost(id, r.URL.Query().Get("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apis.StatusNotFound
+	}
+	return post, err
+}
+
+// normalizeTags returns tags as slugs without duplicates, in their original
+// order.
+func normalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if tag = folio.Slugify(tag); tag != "" && !slices.Contains(normalized, tag) {
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized
+}
+
+// uniquePostSlug returns base, or base with a number added if another post of
+// the user with UUID userID has it as its slug.
+func uniquePostSlug(tx *sql.Tx, userID uuid.UUID, postID, base string) (string, error) {
+	slug := base
+	for n := 2; ; n++ {
+		var taken bool
+		if err := tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM blog_posts WHERE user_uuid = ? AND slug = ? AND id != ?);
+		`, userID.String(), slug, postID).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// savePostHandler creates a post for the logged in user, or updates one of
+// theirs if the request has an id. New posts without a slug get one made from
+// their title, and updated ones keep theirs. Published posts without a
+// publish date are published now.
+func savePostHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		ID            string   `json:"id"`
+		Slug          string   `json:"slug"`
+		Title         string   `json:"title"`
+		Body          string   `json:"body"`
+		Tags          []string `json:"tags"`
+		CoverImageURL string   `json:"coverImageURL"`
+		Published     bool     `json:"published"`
+		PublishDate   string   `json:"publishDate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	req.Title = strings.TrimSpace(req.Title)
+	if req.Title == "" || utf8.RuneCountInString(req.Title) > maxPostTitleLength {
+		return nil, apis.NewError(fmt.Sprintf("title must be between 1 and %d characters", maxPostTitleLength), http.StatusBadRequest)
+	}
+	if utf8.RuneCountInString(req.Body) > maxPostBodyLength {
+		return nil, apis.NewError(fmt.Sprintf("body must be at most %d characters", maxPostBodyLength), http.StatusBadRequest)
+	}
+	if req.Slug != "" && (req.Slug != folio.Slugify(req.Slug) || len(req.Slug) > maxPostSlugLength) {
+		return nil, apis.NewError(fmt.Sprintf("slug must be at most %d lowercase letters and digits separated by dashes", maxPostSlugLength), http.StatusBadRequest)
+	}
+	req.Tags = normalizeTags(req.Tags)
+	if len(req.Tags) > maxPostTags {
+		return nil, apis.NewError(fmt.Sprintf("posts can have at most %d tags", maxPostTags), http.StatusBadRequest)
+	}
+	for _, tag := range req.Tags {
+		if len(tag) > maxPostTagLength {
+			return nil, apis.NewError(fmt.Sprintf("tags must be at most %d characters", maxPostTagLength), http.StatusBadRequest)
+		}
+	}
+	if req.CoverImageURL != "" {
+		if _, ok := folio.ImageKey(req.CoverImageURL); !ok {
+			return nil, apis.NewError("coverImageURL must be an image uploaded through /api/upload_image", http.StatusBadRequest)
+		}
+	}
+	var publishDate sql.NullString
+	if req.PublishDate != "" {
+		t, err := time.Parse(time.RFC3339, req.PublishDate)
+		if err != nil {
+			return nil, apis.NewError("publishDate must be an RFC 3339 date", http.StatusBadRequest)
+		}
+		publishDate = sql.NullString{String: t.UTC().Format(time.RFC3339), Valid: true}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	creating := req.ID == ""
+	if creating {
+		var count int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM blog_posts WHERE user_uuid = ?;`, id.String()).Scan(&count); err != nil {
+			return nil, err
+		}
+		if count >= maxPostsPerUser {
+			return nil, apis.NewError(fmt.Sprintf("you can have at most %d posts", maxPostsPerUser), http.StatusConflict)
+		}
+		req.ID = uuid.New().String()
+	} else {
+		var existingSlug string
+		var existing sql.NullString
+		err := tx.QueryRow(`SELECT slug, publish_date FROM blog_posts WHERE id = ? AND user_uuid = ?;`, req.ID, id.String()).Scan(&existingSlug, &existing)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, apis.StatusNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		if !publishDate.Valid {
+			publishDate = existing
+		}
+		// Retitling a post keeps its slug, so links to it keep working.
+		if req.Slug == "" {
+			req.Slug = existingSlug
+		}
+	}
+	if req.Published && !publishDate.Valid {
+		publishDate = sql.NullString{String: now, Valid: true}
+	}
+
+	if req.Slug == "" {
+		base := folio.Slugify(req.Title)
+		if len(base) > maxPostSlugLength {
+			base = strings.TrimRight(base[:maxPostSlugLength], "-")
+		}
+		if base == "" {
+			base = defaultPostSlugBase
+		}
+		if req.Slug, err = uniquePostSlug(tx, id, req.ID, base); err != nil {
+			return nil, err
+		}
+	} else {
+		var taken bool
+		if err := tx.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM blog_posts WHERE user_uuid = ? AND slug = ? AND id != ?);
+		`, id.String(), req.Slug, req.ID).Scan(&taken); err != nil {
+			return nil, err
+		} else if taken {
+			return nil, apis.NewError("you already have a post with that slug", http.StatusConflict)
+		}
+	}
+
+	if creating {
+		_, err = tx.Exec(`
+			INSERT INTO blog_posts (id, user_uuid, slug, title, body, cover_image_url, published, publish_date, created, updated)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+		`, req.ID, id.String(), req.Slug, req.Title, req.Body, req.CoverImageURL, req.Published, publishDate, now, now)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE blog_posts
+			SET slug = ?, title = ?, body = ?, cover_image_url = ?, published = ?, publish_date = ?, updated = ?
+			WHERE id = ? AND user_uuid = ?;
+		`, req.Slug, req.Title, req.Body, req.CoverImageURL, req.Published, publishDate, now, req.ID, id.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM blog_post_tags WHERE post_id = ?;`, req.ID); err != nil {
+		return nil, err
+	}
+	for i, tag := range req.Tags {
+		if _, err := tx.Exec(`
+			INSERT INTO blog_post_tags (post_id, tag, position) VALUES (?, ?, ?);
+		`, req.ID, tag, i); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return loadBlogPost(id, req.ID)
+}
+
+func deletePostHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM blog_posts WHERE id = ? AND user_uuid = ?;`, req.ID, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+
+	if _, err := tx.Exec(`DELETE FROM blog_post_tags WHERE post_id = ?;`, req.ID); err != nil {
+		return nil, err
+	}
+
+	return nil, tx.Commit()
+}
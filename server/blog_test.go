@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	for _, test := range []struct {
+		tags, want []string
+	}{
+		{nil, []string{}},
+		{[]string{"Go", "Web Dev", "go", "  ", "web-dev!"}, []string{"go", "web-dev"}},
+		{[]string{"C++", "c"}, []string{"c"}},
+	} {
+		if got := normalizeTags(test.tags); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("normalizeTags(%q) = %q, want %q", test.tags, got, test.want)
+		}
+	}
+}
+
+func savePost(t *testing.T, id, body string) blogPost {
+	t.Helper()
+	res, err := savePostHandler(loggedInRequest(t, "POST", "/api/save_post", body, id))
+	if err != nil {
+		t.Fatalf("saving post %s: %v", body, err)
+	}
+	return res.(blogPost)
+}
+
+func publicPosts(t *testing.T, query string) []blogPost {
+	t.Helper()
+	res, err := blogPostsHandler(sessionRequest(t, "GET", "/api/blog_posts?"+query, ""))
+	if err != nil {
+		t.Fatalf("listing posts: %v", err)
+	}
+	return res.(struct {
+		Posts    []blogPost `json:"posts"`
+		Total    int        `json:"total"`
+		Page     int        `json:"page"`
+		PageSize int        `json:"pageSize"`
+	}).Posts
+}
+
+func postSlugs(posts []blogPost) []string {
+	slugs := []string{}
+	for _, post := range posts {
+		slugs = append(slugs, post.Slug)
+	}
+	return slugs
+}
+
+func TestBlogPosts(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+
+	first := savePost(t, ada, `{"title": "Hello, World!", "body": "First.", "tags": ["Go"], "published": true, "publishDate": "2024-01-01T00:00:00Z"}`)
+	if first.Slug != "hello-world" {
+		t.Errorf("first post has slug %q, want hello-world", first.Slug)
+	}
+	second := savePost(t, ada, `{"title": "Hello, World!", "published": true, "publishDate": "2024-02-01T00:00:00Z"}`)
+	if second.Slug != "hello-world-2" {
+		t.Errorf("post with a repeated title has slug %q, want hello-world-2", second.Slug)
+	}
+	savePost(t, ada, `{"title": "Draft"}`)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	savePost(t, ada, `{"title": "Scheduled", "published": true, "publishDate": "`+future+`"}`)
+
+	if got, want := postSlugs(publicPosts(t, "username=ada")), []string{"hello-world-2", "hello-world"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("public posts are %q, want %q", got, want)
+	}
+	if got, want := postSlugs(publicPosts(t, "username=ada&tag=go")), []string{"hello-world"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("posts tagged go are %q, want %q", got, want)
+	}
+
+	// Retitling a post keeps its slug.
+	renamed := savePost(t, ada, `{"id": "`+first.ID+`", "title": "Renamed", "published": true}`)
+	if renamed.Slug != "hello-world" || renamed.PublishDate != first.PublishDate {
+		t.Errorf("retitled post has slug %q and date %q, want %q and %q", renamed.Slug, renamed.PublishDate, "hello-world", first.PublishDate)
+	}
+
+	res, err := blogPostHandler(sessionRequest(t, "GET", "/api/blog_post?username=ada&slug=hello-world", ""))
+	if err != nil || res.(blogPost).Title != "Renamed" {
+		t.Errorf("getting the post = %v, %v, want it retitled", res, err)
+	}
+	_, err = blogPostHandler(sessionRequest(t, "GET", "/api/blog_post?username=ada&slug=scheduled", ""))
+	if errorStatus(err) != http.StatusNotFound {
+		t.Errorf("getting a scheduled post = %v, want 404", err)
+	}
+
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	_, err = savePostHandler(loggedInRequest(t, "POST", "/api/save_post", `{"id": "`+first.ID+`", "title": "Mine"}`, grace))
+	if errorStatus(err) != http.StatusNotFound {
+		t.Errorf("updating someone else's post = %v, want 404", err)
+	}
+}
+
+func TestBlogFeed(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	savePost(t, ada, `{"title": "Published", "published": true}`)
+	savePost(t, ada, `{"title": "Draft"}`)
+
+	r := httptest.NewRequest("GET", "/blog/ada/feed.xml", nil)
+	r.SetPathValue("username", "ada")
+	w := httptest.NewRecorder()
+	blogFeedHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("feed status %d, want 200", w.Code)
+	}
+	feed := w.Body.String()
+	if !strings.Contains(feed, "<title>Published</title>") || strings.Contains(feed, "Draft") {
+		t.Errorf("feed should have only the published post:\n%s", feed)
+	}
+}
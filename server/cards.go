@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/google/uuid"
+	"github.com/nfnt/resize"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+// Social cards are the OpenGraph images shown when a portfolio is shared.
+// They are generated once per change to the fields drawn on them and cached
+// in S3, keyed by a fingerprint of those fields.
+
+const (
+	cardWidth        = 1200
+	cardHeight       = 630
+	cardSidebarWidth = 480
+	cardPadding      = 56
+
+	// cardGracePeriod is how long a card is kept after being replaced.
+	cardGracePeriod = 7 * 24 * time.Hour
+
+	// cardStyle is part of every fingerprint, and changed along with how
+	// cards are drawn so that cached cards are drawn again.
+	cardStyle = "2"
+)
+
+var (
+	cardRegular = Must(opentype.Parse(goregular.TTF))
+	cardBold    = Must(opentype.Parse(gobold.TTF))
+	cardSerif   = Must(opentype.Parse(serifTTF))
+	cardSerifB  = Must(opentype.Parse(serifBoldTTF))
+	cardMono    = Must(opentype.Parse(gomono.TTF))
+	cardMonoB   = Must(opentype.Parse(gomonobold.TTF))
+)
+
+// cardLocks serializes generating each user's card, since every autosave
+// from the editor may try to refresh the same card.
+var cardLocks = keyedMutex{locks: make(map[string]*keyedLock)}
+
+// keyedMutex is a set of mutexes by key, each kept only while it is held or
+// waited for.
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	users int
+}
+
+func (m *keyedMutex) Lock(key string) {
+	m.mu.Lock()
+	l := m.locks[key]
+	if l == nil {
+		l = &keyedLock{}
+		m.locks[key] = l
+	}
+	l.users++
+	m.mu.Unlock()
+
+	l.Lock()
+}
+
+func (m *keyedMutex) Unlock(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	l := m.locks[key]
+	l.Unlock()
+	if l.users--; l.users == 0 {
+		delete(m.locks, key)
+	}
+}
+
+// cardFingerprint hashes what is drawn on the card of p, published at
+// address.
+func cardFingerprint(p folio.Portfolio, address string) string {
+	h := sha256.New()
+	for _, field := range []string{
+		cardStyle, address, p.FirstName, p.LastName, p.Location, p.Font,
+		p.SidebarColor, p.BackgroundColor, p.ProjectColor,
+		cardImageURL(p),
+	} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// cardImageURL returns the image of the first project that has one.
+func cardImageURL(p folio.Portfolio) string {
+	for _, section := range p.Sections {
+		for _, project := range section.Projects {
+			if project.ImageURL != "" {
+				return project.ImageURL
+			}
+		}
+	}
+	return ""
+}
+
+func parseHexColor(hex string) color.RGBA {
+	v, err := strconv.ParseUint(strings.TrimPrefix(hex, "#"), 16, 32)
+	if err != nil {
+		return color.RGBA{0, 0, 0, 255}
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}
+}
+
+func cardColor(name, fallback string) color.RGBA {
+	hex, ok := folio.TailwindColor(name)
+	if !ok {
+		hex, _ = folio.TailwindColor(fallback)
+	}
+	return parseHexColor(hex)
+}
+
+func cardTextColor(background string) color.Color {
+	if folio.IsDarkColor(background) {
+		return color.White
+	}
+	return color.Black
+}
+
+func cardFace(f *opentype.Font, size float64) font.Face {
+	return Must(opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull}))
+}
+
+// wrapText splits text into lines no wider than width when drawn in face.
+func wrapText(face font.Face, text string, width int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		next := word
+		if line != "" {
+			next = line + " " + word
+		}
+		if line != "" && font.MeasureString(face, next).Ceil() > width {
+			lines = append(lines, line)
+			next = word
+		}
+		line = next
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// drawText draws up to maxLines wrapped lines of text with their top at y,
+// returning the y below the last line. The text is shrunk down to half of size
+// so that long words fit, and cut off with an ellipsis if it still does not.
+func drawText(dst draw.Image, f *opentype.Font, size float64, c color.Color, text string, x, y, width, maxLines int) int {
+	face := cardFace(f, size)
+	for min := size / 2; size > min && !wordsFit(face, text, width); {
+		face.Close()
+		size -= 2
+		face = cardFace(f, size)
+	}
+	defer face.Close()
+
+	lines := wrapText(face, text, width)
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		lines[maxLines-1] += "…"
+	}
+	for i, line := range lines {
+		lines[i] = fitLine(face, line, width)
+	}
+
+	metrics := face.Metrics()
+	d := font.Drawer{Dst: dst, Src: image.NewUniform(c), Face: face}
+	for _, line := range lines {
+		d.Dot = fixed.P(x, y+metrics.Ascent.Ceil())
+		d.DrawString(line)
+		y += metrics.Height.Ceil()
+	}
+	return y
+}
+
+func wordsFit(face font.Face, text string, width int) bool {
+	for _, word := range strings.Fields(text) {
+		if font.MeasureString(face, word).Ceil() > width {
+			return false
+		}
+	}
+	return true
+}
+
+// fitLine cuts line short with an ellipsis if it is wider than width.
+func fitLine(face font.Face, line string, width int) string {
+	if font.MeasureString(face, line).Ceil() <= width {
+		return line
+	}
+	runes := []rune(strings.TrimSuffix(line, "…"))
+	for len(runes) > 0 && font.MeasureString(face, string(runes)+"…").Ceil() > width {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), " ") + "…"
+}
+
+// cardAddress returns the address of username's portfolio as shown on its
+// card, without the scheme.
+func cardAddress(username string) string {
+	url := portfolioURL(username)
+	url = strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	return strings.TrimSuffix(url, "/")
+}
+
+// cardFonts returns the regular and bold faces of font, as in a portfolio's
+// Font, falling back to sans.
+func cardFonts(font string) (regular, bold *opentype.Font) {
+	switch font {
+	case "serif":
+		return cardSerif, cardSerifB
+	case "mono":
+		return cardMono, cardMonoB
+	}
+	return cardRegular, cardBold
+}
+
+// renderCard draws the card for p, published at address: the portfolio's
+// sidebar with the name, location and address on the left, and the first
+// project image, if any, on the right.
+func renderCard(p folio.Portfolio, address string, projectImage image.Image) ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, cardWidth, cardHeight))
+
+	background := cardColor(p.BackgroundColor, defaultPortfolio.BackgroundColor)
+	sidebar := cardColor(p.SidebarColor, defaultPortfolio.SidebarColor)
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, cardSidebarWidth, cardHeight), image.NewUniform(sidebar), image.Point{}, draw.Src)
+
+	regular, bold := cardFonts(p.Font)
+
+	textColor := cardTextColor(p.SidebarColor)
+	textWidth := cardSidebarWidth - 2*cardPadding
+	y := cardPadding
+	y = drawText(img, bold, 64, textColor, p.FirstName, cardPadding, y, textWidth, 2)
+	y = drawText(img, bold, 64, textColor, p.LastName, cardPadding, y, textWidth, 2)
+	if p.Location != "" {
+		drawText(img, regular, 30, textColor, p.Location, cardPadding, y+24, textWidth, 2)
+	}
+	drawText(img, bold, 26, textColor, address, cardPadding, cardHeight-cardPadding-32, textWidth, 1)
+
+	area := image.Rect(cardSidebarWidth+cardPadding, cardPadding, cardWidth-cardPadding, cardHeight-cardPadding)
+	if projectImage != nil {
+		b := projectImage.Bounds()
+		w, h := uint(area.Dx()), uint(0)
+		if b.Dy()*area.Dx() > b.Dx()*area.Dy() {
+			w, h = 0, uint(area.Dy())
+		}
+		scaled := resize.Resize(w, h, projectImage, resize.Lanczos3)
+		sb := scaled.Bounds()
+		at := area.Min.Add(image.Pt((area.Dx()-sb.Dx())/2, (area.Dy()-sb.Dy())/2))
+		draw.Draw(img, sb.Add(at), scaled, sb.Min, draw.Over)
+	} else {
+		project := cardColor(p.ProjectColor, defaultPortfolio.ProjectColor)
+		draw.Draw(img, area, image.NewUniform(project), image.Point{}, draw.Src)
+		var titles []string
+		for _, section := range p.Sections {
+			if section.Title != "" {
+				titles = append(titles, section.Title)
+			}
+		}
+		drawText(img, bold, 40, cardTextColor(p.ProjectColor), strings.Join(titles, " · "), area.Min.X+40, area.Min.Y+40, area.Dx()-80, 6)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func loadCardImage(url string) (image.Image, error) {
+	data, _, err := loadImageFromS3(url)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	return img, err
+}
+
+// errNoCard is returned by socialCardURL for portfolios that get no card.
+var errNoCard = errors.New("portfolio has no social card")
+
+// socialCardURL returns the URL of the up to date card of the user with UUID
+// id, generating it if needed. The portfolio is loaded under its lock, so
+// whichever refresh runs last draws the latest one, whatever order they were
+// started in. Protected portfolios get no card, as it would show who they
+// belong to, and their old card is retired.
+func socialCardURL(id uuid.UUID) (string, error) {
+	cardLocks.Lock(id.String())
+	defer cardLocks.Unlock(id.String())
+
+	var oldFingerprint, oldURL string
+	err := db.QueryRow(`SELECT fingerprint, url FROM social_cards WHERE uuid = ?;`, id.String()).Scan(&oldFingerprint, &oldURL)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return "", err
+	}
+
+	if protected, err := isProtected(id); err != nil {
+		return "", err
+	} else if protected {
+		if oldURL != "" {
+			if _, err := db.Exec(`DELETE FROM social_cards WHERE uuid = ?;`, id.String()); err != nil {
+				return "", err
+			}
+			retireSocialCard(oldURL)
+		}
+		return "", errNoCard
+	}
+
+	var username string
+	if err := db.QueryRow(`SELECT username FROM users WHERE uuid = ?;`, id.String()).Scan(&username); err != nil {
+		return "", err
+	}
+	p, err := loadPortfolio(id)
+	if err != nil {
+		return "", err
+	}
+	address := cardAddress(username)
+	fingerprint := cardFingerprint(p, address)
+	if oldFingerprint == fingerprint {
+		return oldURL, nil
+	}
+
+	var projectImage image.Image
+	if url := cardImageURL(p); url != "" {
+		if projectImage, err = loadCardImage(url); err != nil {
+			log.Printf("leaving image %s off of social card: %v\n", url, err)
+		}
+	}
+
+	data, err := renderCard(p, address, projectImage)
+	if err != nil {
+		return "", err
+	}
+
+	key := fmt.Sprintf("cards/%s-%s.png", id, fingerprint)
+	if _, err := s3svc.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(imageBucket),
+		Key:                  aws.String(key),
+		Body:                 bytes.NewReader(data),
+		ContentType:          aws.String("image/png"),
+		ServerSideEncryption: aws.String("AES256"),
+	}); err != nil {
+		return "", err
+	}
+
+	url := folio.ImageBucketURL + key
+	if _, err := db.Exec(`
+		INSERT INTO social_cards (uuid, fingerprint, url, generated)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (uuid) DO UPDATE SET
+			fingerprint = excluded.fingerprint,
+			url = excluded.url,
+			generated = excluded.generated;
+	`, id.String(), fingerprint, url, time.Now().Format(time.RFC3339)); err != nil {
+		return "", err
+	}
+
+	// The same fingerprint gives the same key, so a card changed back to an
+	// earlier one must not be retired.
+	if oldURL != "" && oldURL != url {
+		retireSocialCard(oldURL)
+	}
+	if _, err := db.Exec(`DELETE FROM retired_social_cards WHERE url = ?;`, url); err != nil {
+		log.Printf("error unretiring social card %s: %v\n", url, err)
+	}
+	deleteRetiredSocialCards()
+
+	return url, nil
+}
+
+// retireSocialCard schedules the card at url for deletion once
+// cardGracePeriod has passed. Cards are not deleted straight away, since pages
+// shared before the change still point scrapers and caches at them.
+func retireSocialCard(url string) {
+	if _, err := db.Exec(`
+		INSERT INTO retired_social_cards (url, delete_after) VALUES (?, ?)
+		ON CONFLICT (url) DO NOTHING;
+	`, url, time.Now().Add(cardGracePeriod).Format(time.RFC3339)); err != nil {
+		log.Printf("error retiring social card %s: %v\n", url, err)
+	}
+}
+
+// deleteRetiredSocialCards deletes the retired cards whose grace period is
+// over.
+func deleteRetiredSocialCards() {
+	rows, err := db.Query(`SELECT url FROM retired_social_cards WHERE delete_after <= ?;`, time.Now().Format(time.RFC3339))
+	if err != nil {
+		log.Printf("error listing retired social cards: %v\n", err)
+		return
+	}
+	var urls []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			log.Printf("error listing retired social cards: %v\n", err)
+			break
+		}
+		urls = append(urls, url)
+	}
+	rows.Close()
+
+	for _, url := range urls {
+		if _, err := s3svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(imageBucket),
+			Key:    aws.String(strings.TrimPrefix(url, folio.ImageBucketURL)),
+		}); err != nil {
+			log.Printf("error deleting old social card %s: %v\n", url, err)
+			continue
+		}
+		if _, err := db.Exec(`DELETE FROM retired_social_cards WHERE url = ?;`, url); err != nil {
+			log.Printf("error deleting old social card %s: %v\n", url, err)
+		}
+	}
+}
+
+// socialCardRefreshes tracks the refreshes started by refreshSocialCard, so
+// they can be waited for before the database they use goes away.
+var socialCardRefreshes sync.WaitGroup
+
+// refreshSocialCard brings the card of the user with UUID id up to date in
+// the background.
+func refreshSocialCard(id uuid.UUID) {
+	socialCardRefreshes.Add(1)
+	go func() {
+		defer socialCardRefreshes.Done()
+		if _, err := socialCardURL(id); err != nil && !errors.Is(err, errNoCard) {
+			log.Printf("error refreshing social card of %s: %v\n", id, err)
+		}
+	}()
+}
+
+// cachedSocialCardURL returns the URL of the last card generated for
+// username, which may be out of date if it is still being refreshed. Users
+// without a card get the URL of socialCardHandler, which generates one.
+func cachedSocialCardURL(username string) string {
+	var url string
+	err := db.QueryRow(`
+		SELECT social_cards.url
+		FROM social_cards JOIN users ON users.uuid = social_cards.uuid
+		WHERE users.username = ?;
+	`, username).Scan(&url)
+	if err != nil {
+		return backend + "/api/social_card?username=" + neturl.QueryEscape(username)
+	}
+	return url
+}
+
+// socialCardHandler redirects to the card of the user given by the username
+// query parameter, generating it first if needed.
+func socialCardHandler(r *http.Request) (any, error) {
+	username := r.URL.Query().Get("username")
+
+	var idstr string
+	err := db.QueryRow(`SELECT uuid FROM users WHERE username = ?;`, username).Scan(&idstr)
+	if errors.Is(err, sql.ErrNoRows) {
+		if renamed, _, ok := renamedUsername(username); ok {
+			return apis.Redirect(backend+"/api/social_card?username="+neturl.QueryEscape(renamed), http.StatusMovedPermanently), nil
+		}
+		return nil, apis.StatusNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(idstr)
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := socialCardURL(id)
+	if errors.Is(err, errNoCard) {
+		return nil, apis.StatusNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return apis.Redirect(url, http.StatusFound), nil
+}
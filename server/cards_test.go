@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+)
+
+func TestKeyedMutex(t *testing.T) {
+	m := keyedMutex{locks: make(map[string]*keyedLock)}
+
+	m.Lock("a")
+	done := make(chan struct{})
+	go func() {
+		m.Lock("b")
+		m.Unlock("b")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("locking b waited for a")
+	}
+
+	var mu sync.Mutex
+	held := false
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.Lock("a")
+			defer m.Unlock("a")
+
+			mu.Lock()
+			if held {
+				t.Error("a was locked twice at once")
+			}
+			held = true
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			held = false
+			mu.Unlock()
+		}()
+	}
+	m.Unlock("a")
+	wg.Wait()
+
+	if len(m.locks) != 0 {
+		t.Errorf("keyedMutex still has %d locks after they were released", len(m.locks))
+	}
+}
+
+func TestCardFonts(t *testing.T) {
+	for font, want := range map[string]string{"sans": "Go", "serif": "DejaVu Serif", "mono": "Go Mono", "unknown": "Go"} {
+		regular, bold := cardFonts(font)
+		for _, f := range []*opentype.Font{regular, bold} {
+			if family, err := f.Name(nil, sfnt.NameIDFamily); err != nil || family != want {
+				t.Errorf("%s card font is in family %q, %v, want %q", font, family, err, want)
+			}
+		}
+	}
+}
+
+func TestSocialCardURL(t *testing.T) {
+	newTestDB(t)
+	id := uuid.MustParse(createTestUser(t, "ada", testIdentity("ada")))
+
+	first, err := socialCardURL(id)
+	if err != nil {
+		t.Fatalf("generating card: %v", err)
+	}
+	if again, err := socialCardURL(id); err != nil || again != first {
+		t.Errorf("unchanged portfolio got card %q, %v; want the cached %q", again, err, first)
+	}
+
+	p, err := loadPortfolio(id)
+	if err != nil {
+		t.Fatalf("loading portfolio: %v", err)
+	}
+	p.FirstName = "Ada"
+	if _, err := db.Exec(`UPDATE users SET portfolio = ? WHERE uuid = ?;`, Must(json.Marshal(p)), id.String()); err != nil {
+		t.Fatalf("saving portfolio: %v", err)
+	}
+
+	second, err := socialCardURL(id)
+	if err != nil {
+		t.Fatalf("regenerating card: %v", err)
+	}
+	if second == first {
+		t.Error("renamed portfolio kept its old card")
+	}
+	var retired int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM retired_social_cards WHERE url = ?;`, first).Scan(&retired); err != nil {
+		t.Fatal(err)
+	}
+	if retired != 1 {
+		t.Errorf("old card was not retired")
+	}
+	if got := cachedSocialCardURL("ada"); got != second {
+		t.Errorf("cachedSocialCardURL = %q, want %q", got, second)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+// Links to projects on public pages go through /p/{id}/go, which counts the
+// click before redirecting to the project's link. Clicks are counted by
+// project ID, so they stay together when a project or its owner is renamed.
+// Only links in the stored portfolio are redirected to, so the endpoint
+// cannot be used to send people to arbitrary sites.
+
+// projectClickURL returns the URL counting clicks on the project with ID id.
+func projectClickURL(id string) string {
+	return backend + "/p/" + url.PathEscape(id) + "/go"
+}
+
+// trackProjectLinks points the links of the projects in view at
+// projectClickURL.
+func trackProjectLinks(view *portfolioView) {
+	for i := range view.Sections {
+		for j := range view.Sections[i].Projects {
+			if pv := &view.Sections[i].Projects[j]; pv.Link != "" && pv.ID != "" {
+				pv.Href = projectClickURL(pv.ID)
+			}
+		}
+	}
+}
+
+// recordClick counts a click by r on the project with ID project in the
+// portfolio of the user with UUID id, unless it is from a bot or the
+// portfolio's owner.
+func recordClick(r *http.Request, id uuid.UUID, project, name string) error {
+	if deviceClass(r.UserAgent()) == deviceBot {
+		return nil
+	}
+	if login, err := getLogin(r, scopeRead); err == nil && login == id {
+		return nil
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO project_clicks (user_uuid, day, project, name, clicks)
+		VALUES (?, ?, ?, ?, 1)
+		ON CONFLICT (user_uuid, day, project) DO UPDATE SET
+			name = excluded.name,
+			clicks = clicks + 1;
+	`, id.String(), time.Now().UTC().Format(analyticsDay), project, name)
+	return err
+}
+
+// projectClickHandler counts a click on a project's link and redirects to it.
+func projectClickHandler(r *http.Request) (any, error) {
+	found, err := findProject(r.PathValue("id"))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && !folio.IsWebURL(found.Project.Link) {
+		return nil, apis.StatusNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := requireUnlocked(r, found.Username); err != nil {
+		return nil, err
+	}
+
+	if err := recordClick(r, found.UserID, found.Project.ID, found.Project.Name); err != nil {
+		log.Printf("error recording click on %s: %v\n", found.Project.ID, err)
+	}
+	return apis.Redirect(found.Project.Link, http.StatusFound), nil
+}
+
+// legacyProjectClickHandler sends a click on a link made before projects had
+// IDs, at /p/{portfolio}/{project}/go, on to the project now having that slug
+// to be counted. Such links are still in exported sites and PDFs, and in
+// pages saved or cached since.
+func legacyProjectClickHandler(r *http.Request) (any, error) {
+	username := r.PathValue("portfolio")
+	p, err := loadPortfolioByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		if renamed, _, ok := renamedUsername(username); ok {
+			p, err = loadPortfolioByUsername(renamed)
+		}
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apis.StatusNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	slugs := folio.ProjectSlugs(p)
+	for i, section := range p.Sections {
+		for j, project := range section.Projects {
+			if slugs[i][j] == r.PathValue("project") && project.ID != "" {
+				return apis.Redirect(projectClickURL(project.ID), http.StatusFound), nil
+			}
+		}
+	}
+	return nil, apis.StatusNotFound
+}
+
+// moveClicksToProjectIDs moves clicks counted under project slugs, as they
+// were before projects had IDs, to the IDs of the projects now having those
+// slugs. Clicks on projects since deleted are left under their slug. A slug
+// that is also one of the user's project IDs is left alone, since clicks
+// under it may already be counted by that ID.
+func moveClicksToProjectIDs(tx *sql.Tx) error {
+	rows, err := tx.Query(`
+		SELECT uuid, portfolio FROM users
+		WHERE uuid IN (SELECT user_uuid FROM project_clicks);
+	`)
+	if err != nil {
+		return err
+	}
+	portfolios := make(map[string]folio.Portfolio)
+	for rows.Next() {
+		var idstr string
+		var j []byte
+		if err := rows.Scan(&idstr, &j); err != nil {
+			rows.Close()
+			return err
+		}
+		var p folio.Portfolio
+		if err := json.Unmarshal(j, &p); err != nil {
+			rows.Close()
+			return err
+		}
+		portfolios[idstr] = p
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	moved := 0
+	for idstr, p := range portfolios {
+		ids := make(map[string]bool)
+		for _, section := range p.Sections {
+			for _, project := range section.Projects {
+				ids[project.ID] = true
+			}
+		}
+
+		slugs := folio.ProjectSlugs(p)
+		for i, section := range p.Sections {
+			for j, project := range section.Projects {
+				slug := slugs[i][j]
+				if project.ID == "" || ids[slug] {
+					continue
+				}
+				if _, err := tx.Exec(`
+					INSERT INTO project_clicks (user_uuid, day, project, name, clicks)
+					SELECT user_uuid, day, ?, name, clicks FROM project_clicks
+					WHERE user_uuid = ? AND project = ?
+					ON CONFLICT (user_uuid, day, project) DO UPDATE SET
+						clicks = clicks + excluded.clicks;
+				`, project.ID, idstr, slug); err != nil {
+					return err
+				}
+				result, err := tx.Exec(`DELETE FROM project_clicks WHERE user_uuid = ? AND project = ?;`, idstr, slug)
+				if err != nil {
+					return err
+				}
+				if n, err := result.RowsAffected(); err != nil {
+					return err
+				} else if n > 0 {
+					moved++
+				}
+			}
+		}
+	}
+
+	if moved > 0 {
+		log.Printf("moved clicks on %d projects to their IDs\n", moved)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/folio"
+)
+
+// clickTestProject follows the tracked link of the project with ID id,
+// returning the response.
+func clickTestProject(t *testing.T, id string) *http.Response {
+	t.Helper()
+	r := sessionRequest(t, "GET", "/p/"+id+"/go", "")
+	r.Header.Set("User-Agent", testBrowser)
+	w := httptest.NewRecorder()
+	routes().ServeHTTP(w, r)
+	return w.Result()
+}
+
+// saveTestProjects publishes projects as the portfolio of the user with UUID
+// id, returning them with their IDs.
+func saveTestProjects(t *testing.T, id string, projects ...folio.Project) []folio.Project {
+	t.Helper()
+	p := defaultPortfolio
+	p.Sections = []folio.Section{{Title: "Work", Projects: projects}}
+	if err := savePortfolio(uuid.MustParse(id), p); err != nil {
+		t.Fatalf("saving portfolio: %v", err)
+	}
+	p, err := loadPortfolio(uuid.MustParse(id))
+	if err != nil {
+		t.Fatalf("loading portfolio: %v", err)
+	}
+	return p.Sections[0].Projects
+}
+
+func projectClicks(t *testing.T, userID, project string) int {
+	t.Helper()
+	var clicks int
+	if err := db.QueryRow(`
+		SELECT COALESCE(SUM(clicks), 0) FROM project_clicks WHERE user_uuid = ? AND project = ?;
+	`, userID, project).Scan(&clicks); err != nil {
+		t.Fatalf("counting clicks: %v", err)
+	}
+	return clicks
+}
+
+func TestProjectClick(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	projects := saveTestProjects(t, ada,
+		folio.Project{Name: "Engine", Link: "https://engine.example/"},
+		folio.Project{Name: "Script", Link: "javascript:alert(1)"},
+	)
+	engine, script := projects[0].ID, projects[1].ID
+
+	for range 2 {
+		res := clickTestProject(t, engine)
+		if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "https://engine.example/" {
+			t.Fatalf("clicking engine got %d to %q, want a redirect to its link", res.StatusCode, res.Header.Get("Location"))
+		}
+	}
+	if clicks := projectClicks(t, ada, engine); clicks != 2 {
+		t.Errorf("engine has %d clicks, want 2", clicks)
+	}
+
+	// Clicks stay with the project when it is renamed.
+	projects[0].Name = "Motor"
+	saveTestProjects(t, ada, projects...)
+	clickTestProject(t, engine)
+	if clicks := projectClicks(t, ada, engine); clicks != 3 {
+		t.Errorf("renamed engine has %d clicks, want 3", clicks)
+	}
+
+	for _, id := range []string{script, "missing"} {
+		if res := clickTestProject(t, id); res.StatusCode != http.StatusNotFound {
+			t.Errorf("clicking %s got %d, want 404", id, res.StatusCode)
+		}
+	}
+}
+
+func TestLegacyProjectClick(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	engine := saveTestProjects(t, ada, folio.Project{Name: "Engine", Link: "https://engine.example/"})[0].ID
+
+	follow := func(path string) *http.Response {
+		r := sessionRequest(t, "GET", path, "")
+		r.Header.Set("User-Agent", testBrowser)
+		w := httptest.NewRecorder()
+		routes().ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	if err := rename(t, ada, "lovelace"); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+	for _, path := range []string{"/p/ada/engine/go", "/p/lovelace/engine/go"} {
+		res := follow(path)
+		if want := projectClickURL(engine); res.StatusCode != http.StatusFound || res.Header.Get("Location") != want {
+			t.Fatalf("%s got %d to %q, want a redirect to %q", path, res.StatusCode, res.Header.Get("Location"), want)
+		}
+		clickTestProject(t, engine)
+	}
+	if clicks := projectClicks(t, ada, engine); clicks != 2 {
+		t.Errorf("engine has %d clicks, want 2", clicks)
+	}
+
+	for _, path := range []string{"/p/lovelace/motor/go", "/p/nobody/engine/go"} {
+		if res := follow(path); res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s got %d, want 404", path, res.StatusCode)
+		}
+	}
+}
+
+func TestMoveClicksToProjectIDs(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	projects := saveTestProjects(t, ada,
+		folio.Project{Name: "Engine", Link: "https://engine.example/"},
+		folio.Project{ID: "compiler", Name: "Compiler"},
+		folio.Project{Name: "Router"},
+		folio.Project{ID: "router", Name: "Loom"},
+	)
+	engine := projects[0].ID
+	router := projects[2].ID
+
+	// Clicks on the engine counted before projects had IDs, and clicks since
+	// counted by IDs that are also slugs: the compiler's own, and the
+	// router's, which is the loom's ID.
+	if _, err := db.Exec(`
+		INSERT INTO project_clicks (user_uuid, day, project, name, clicks)
+		VALUES (?, '2024-01-01', 'engine', 'Engine', 4), (?, '2024-01-01', 'deleted', 'Deleted', 1),
+			(?, '2024-01-01', 'compiler', 'Compiler', 3), (?, '2024-01-01', 'router', 'Loom', 2);
+	`, ada, ada, ada, ada); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`DELETE FROM migrations WHERE name = 'project_clicks_by_id';`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restarting runs the migration once; restarting again leaves it be.
+	for restart := 1; restart <= 2; restart++ {
+		createTables()
+
+		for _, want := range []struct {
+			project string
+			clicks  int
+		}{
+			{engine, 4},
+			{"engine", 0},
+			{"deleted", 1},
+			{"compiler", 3},
+			{"router", 2},
+			{router, 0},
+		} {
+			if clicks := projectClicks(t, ada, want.project); clicks != want.clicks {
+				t.Errorf("after restart %d, %s has %d clicks, want %d", restart, want.project, clicks, want.clicks)
+			}
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// In production the server sits behind a reverse proxy, so the peer of every
+// request is the proxy. Peers listed in TRUSTED_PROXIES, as addresses or CIDR
+// ranges separated by commas, are believed about the client they forward for
+// in CLIENT_IP_HEADER, X-Forwarded-For by default. Nobody else is, since
+// anyone can send the header.
+
+var trustedProxies []netip.Prefix
+
+var clientIPHeader = "X-Forwarded-For"
+
+// loadTrustedProxies reads the proxies to trust from the environment.
+func loadTrustedProxies() error {
+	proxies, err := parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return err
+	}
+	trustedProxies = proxies
+	if header := os.Getenv("CLIENT_IP_HEADER"); header != "" {
+		clientIPHeader = header
+	}
+	return nil
+}
+
+// parseTrustedProxies parses a comma-separated list of addresses and CIDR
+// ranges.
+func parseTrustedProxies(s string) ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if strings.Contains(field, "/") {
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				return nil, fmt.Errorf("trusted proxy %q: %w", field, err)
+			}
+			proxies = append(proxies, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(field)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxy %q: %w", field, err)
+		}
+		addr = addr.Unmap()
+		proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return proxies, nil
+}
+
+func isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedAddr parses an address from a forwarding header, which may
+// carry a port.
+func parseForwardedAddr(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return addr.Unmap(), true
+	}
+	if addrPort, err := netip.ParseAddrPort(s); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// remoteIP returns the address of the client that sent r. Behind trusted
+// proxies, it is the last address in the client IP header that none of them
+// is at: every proxy appends the address it got the request from, so
+// addresses before that one came from the client and could be anything.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, ok := parseForwardedAddr(host)
+	if !ok || !isTrustedProxy(peer) {
+		return host
+	}
+
+	client := peer
+	hops := strings.Split(strings.Join(r.Header.Values(clientIPHeader), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, ok := parseForwardedAddr(hops[i])
+		if !ok {
+			break
+		}
+		client = addr
+		if !isTrustedProxy(addr) {
+			break
+		}
+	}
+	return client.String()
+}
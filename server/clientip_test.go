@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.7, ::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustedProxies = proxies
+	t.Cleanup(func() { trustedProxies = nil })
+
+	for _, test := range []struct {
+		name, peer string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxy", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer's header is ignored", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.1.2.3:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"trusted proxy without a header", "192.0.2.7:1234", nil, "192.0.2.7"},
+		{"spoofed addresses before the proxy's", "10.1.2.3:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.1.2.3:1234", []string{"198.51.100.1, 10.9.9.9", "192.0.2.7"}, "198.51.100.1"},
+		{"garbage before the client", "10.1.2.3:1234", []string{"nonsense, 198.51.100.1"}, "198.51.100.1"},
+		{"garbage from the proxy", "10.1.2.3:1234", []string{"198.51.100.1, nonsense"}, "10.1.2.3"},
+		{"address with a port", "10.1.2.3:1234", []string{"198.51.100.1:5678"}, "198.51.100.1"},
+		{"IPv6 proxy", "[::1]:1234", []string{"2001:db8::1"}, "2001:db8::1"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.peer
+			for _, f := range test.forwarded {
+				r.Header.Add("X-Forwarded-For", f)
+			}
+			if got := remoteIP(r); got != test.want {
+				t.Errorf("remoteIP() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if proxies, err := parseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("parseTrustedProxies(\"\") = %v, %v, want none", proxies, err)
+	}
+	for _, bad := range []string{"10.0.0.0/33", "proxy.example.com", "10.0.0.1, nope"} {
+		if _, err := parseTrustedProxies(bad); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded", bad)
+		}
+	}
+}
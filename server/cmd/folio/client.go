@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+// client calls the foliospot API as the owner of a personal access token.
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// apiError is the error body sent by the apis package.
+type apiError struct {
+	ErrorMessage string          `json:"errorMessage"`
+	ErrorCode    int             `json:"errorCode"`
+	ErrorData    json.RawMessage `json:"errorData,omitempty"`
+}
+
+func (e *apiError) Error() string {
+	var fieldErrs []folio.FieldError
+	if json.Unmarshal(e.ErrorData, &fieldErrs) == nil && len(fieldErrs) > 0 {
+		lines := make([]string, len(fieldErrs))
+		for i, fe := range fieldErrs {
+			lines[i] = "  " + fe.Error()
+		}
+		return fmt.Sprintf("server error %d: invalid portfolio:\n%s", e.ErrorCode, strings.Join(lines, "\n"))
+	}
+	return fmt.Sprintf("server error %d: %s", e.ErrorCode, e.ErrorMessage)
+}
+
+// do sends a request to path on the server and decodes a JSON response into
+// out, if it is not nil.
+func (c *client) do(method, path, contentType string, body io.Reader, out any) error {
+	req, err := http.NewRequest(method, c.server+path, body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 400 {
+		var e apiError
+		if json.Unmarshal(data, &e) == nil && e.ErrorCode != 0 {
+			return &e
+		}
+		return &apiError{ErrorMessage: strings.TrimSpace(string(data)), ErrorCode: res.StatusCode}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// CheckToken returns an error if the server does not accept the client's
+// token, which it does not if the token expired or was revoked, or if the
+// server is too old to support access tokens. Tokens without the read scope
+// get_login asks for are refused with 403 rather than 401, and are accepted,
+// since each command checks its own scope.
+func (c *client) CheckToken() error {
+	err := c.do("GET", "/api/get_login", "", nil, nil)
+	var e *apiError
+	if errors.As(err, &e) {
+		switch e.ErrorCode {
+		case http.StatusForbidden:
+			return nil
+		case http.StatusUnauthorized:
+			return fmt.Errorf("the server did not accept the access token: %s", e.ErrorMessage)
+		}
+	}
+	return err
+}
+
+// Draft returns the user's draft, or their published portfolio if they have
+// no draft.
+func (c *client) Draft() (folio.Portfolio, error) {
+	var p folio.Portfolio
+	err := c.do("GET", "/api/get_draft", "", nil, &p)
+	return p, err
+}
+
+// PutDraft replaces the user's draft with p.
+func (c *client) PutDraft(p folio.Portfolio) error {
+	j, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.do("POST", "/api/put_draft", "application/json", bytes.NewReader(j), nil)
+}
+
+// Publish makes the user's draft their public portfolio. It returns false if
+// there was no draft to publish.
+func (c *client) Publish() (bool, error) {
+	var resp struct {
+		Published bool `json:"published"`
+	}
+	err := c.do("POST", "/api/publish", "", nil, &resp)
+	return resp.Published, err
+}
+
+// DiscardDraft deletes the user's draft. It returns false if there was no
+// draft to discard.
+func (c *client) DiscardDraft() (bool, error) {
+	var resp struct {
+		Discarded bool `json:"discarded"`
+	}
+	err := c.do("POST", "/api/discard_draft", "", nil, &resp)
+	return resp.Discarded, err
+}
+
+// UploadImage uploads an image, returning the URL to use in a project's
+// imageURL.
+func (c *client) UploadImage(data []byte, contentType string) (string, error) {
+	var resp struct {
+		URL string `json:"url"`
+	}
+	err := c.do("POST", "/api/upload_image", contentType, bytes.NewReader(data), &resp)
+	return resp.URL, err
+}
+
+// CreateShareLink creates a link to the user's draft, returning its URL.
+// Zero days or views means the link does not expire that way.
+func (c *client) CreateShareLink(days int, views int64) (string, error) {
+	j, err := json.Marshal(struct {
+		ExpiresInDays int   `json:"expiresInDays"`
+		MaxViews      int64 `json:"maxViews"`
+	}{days, views})
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		URL string `json:"url"`
+	}
+	err = c.do("POST", "/api/create_share_link", "application/json", bytes.NewReader(j), &resp)
+	return resp.URL, err
+}
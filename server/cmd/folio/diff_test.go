@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteDiff(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{"same", "a\nb\n", "a\nb\n", ""},
+		{"changed line", "a\nb\nc\n", "a\nB\nc\n", `--- old
++++ new
+@@ -1,4 +1,4 @@
+ a
+-b
++B
+ c
+ 
+`},
+		{"added line", "a\n", "a\nb\n", `--- old
++++ new
+@@ -1,2 +1,3 @@
+ a
++b
+ 
+`},
+		{"separate hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12", "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ntwelve", `--- old
++++ new
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -9,4 +9,4 @@
+ 9
+ 10
+ 11
+-12
++twelve
+`},
+		{"joined hunks", "1\n2\n3\n4\n5\n6\n7", "one\n2\n3\n4\n5\n6\nseven", `--- old
++++ new
+@@ -1,7 +1,7 @@
+-1
++one
+ 2
+ 3
+ 4
+ 5
+ 6
+-7
++seven
+`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var b strings.Builder
+			changed := writeDiff(&b, "old", "new", test.old, test.new)
+			if changed != (test.want != "") {
+				t.Errorf("writeDiff reported changed = %v", changed)
+			}
+			if got := b.String(); got != test.want {
+				t.Errorf("writeDiff wrote\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
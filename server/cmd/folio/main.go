@@ -0,0 +1,309 @@
+// Command folio manages a foliospot portfolio from local files.
+//
+// Usage:
+//
+//	folio [flags] pull [file]
+//	folio [flags] push [-y] [-publish] [file]
+//	folio [flags] upload image...
+//	folio [flags] publish
+//	folio [flags] discard
+//	folio [flags] share [-days n] [-views n]
+//
+// pull saves the current draft (or the published portfolio, if there is no
+// draft) to file, and push replaces the draft with the contents of file after
+// showing what changed. file defaults to portfolio.yaml; files ending in
+// .toml are read and written as TOML. upload prints the URL of each uploaded
+// image, to be used as a project's imageURL. publish makes the draft public
+// and discard deletes it; until then the draft is kept, even as the published
+// portfolio is changed in the editor. share prints a link that shows the
+// draft to anyone who has it, optionally expiring after some days or views.
+//
+// Requests are authenticated with a personal access token, taken from the
+// -token flag or the FOLIO_TOKEN environment variable.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+const defaultFile = "portfolio.yaml"
+
+var imageTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: folio [flags] <command> [args]
+
+commands:
+  pull [file]                     save the portfolio draft to file (default %s)
+  push [-y] [-publish] [file]     replace the draft with file, showing a diff first
+  upload image...                 upload images and print their URLs
+  publish                         publish the draft
+  discard                         delete the draft
+  share [-days n] [-views n]      print a link to the draft for others to see
+
+flags:
+`, defaultFile)
+	flag.PrintDefaults()
+}
+
+func main() {
+	server := flag.String("server", envOr("FOLIO_SERVER", "https://api.foliospot.io"), "API server `URL` (or $FOLIO_SERVER)")
+	token := flag.String("token", os.Getenv("FOLIO_TOKEN"), "personal access `token` (or $FOLIO_TOKEN)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	if *token == "" {
+		fatalf("no access token given; create one in the Tokens tab of the editor and pass it with -token or $FOLIO_TOKEN")
+	}
+
+	c := &client{
+		server: strings.TrimSuffix(*server, "/"),
+		token:  *token,
+		http:   &http.Client{Timeout: time.Minute},
+	}
+	if err := c.CheckToken(); err != nil {
+		fatalf("%v", err)
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	var err error
+	switch cmd {
+	case "pull":
+		err = pull(c, args)
+	case "push":
+		err = push(c, args)
+	case "upload":
+		err = upload(c, args)
+	case "publish":
+		err = publish(c)
+	case "discard":
+		err = discard(c)
+	case "share":
+		err = share(c, args)
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fatalf("%s: %v", cmd, err)
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "folio: "+format+"\n", args...)
+	os.Exit(1)
+}
+
+// fileArg returns the single optional file argument of a command.
+func fileArg(fs *flag.FlagSet) (string, *folio.Format, error) {
+	if fs.NArg() > 1 {
+		return "", nil, errors.New("too many arguments")
+	}
+
+	file := defaultFile
+	if fs.NArg() == 1 {
+		file = fs.Arg(0)
+	}
+
+	f, err := folio.FormatForFile(file)
+	return file, f, err
+}
+
+func pull(c *client, args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	fs.Parse(args)
+
+	file, f, err := fileArg(fs)
+	if err != nil {
+		return err
+	}
+
+	p, err := c.Draft()
+	if err != nil {
+		return err
+	}
+
+	data, err := f.Encode(p)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		return err
+	}
+
+	fmt.Printf("saved portfolio to %s\n", file)
+	return nil
+}
+
+func push(c *client, args []string) error {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	yes := fs.Bool("y", false, "push without asking for confirmation")
+	publishAfter := fs.Bool("publish", false, "publish the draft after pushing it")
+	fs.Parse(args)
+
+	file, f, err := fileArg(fs)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	p, errs := f.Decode(data)
+	if errs != nil {
+		for _, e := range errs {
+			loc := file
+			if e.Line > 0 {
+				loc = fmt.Sprintf("%s:%d", file, e.Line)
+			}
+			e.Line = 0
+			fmt.Fprintf(os.Stderr, "%s: %s\n", loc, e)
+		}
+		return fmt.Errorf("%s is not a valid portfolio", file)
+	}
+
+	current, err := c.Draft()
+	if err != nil {
+		return err
+	}
+
+	// compare in the file's format, so the diff lines up with what was edited
+	currentData, err := f.Encode(current)
+	if err != nil {
+		return err
+	}
+	newData, err := f.Encode(p)
+	if err != nil {
+		return err
+	}
+
+	if !writeDiff(os.Stdout, "draft", file, string(currentData), string(newData)) {
+		fmt.Println("draft is already up to date")
+	} else {
+		if !*yes && !confirm("push these changes?") {
+			return errors.New("cancelled")
+		}
+
+		if err := c.PutDraft(p); err != nil {
+			return err
+		}
+		fmt.Println("pushed draft")
+	}
+
+	if *publishAfter {
+		return publish(c)
+	}
+
+	return nil
+}
+
+func publish(c *client) error {
+	published, err := c.Publish()
+	if err != nil {
+		return err
+	}
+
+	if published {
+		fmt.Println("published")
+	} else {
+		fmt.Println("no draft to publish")
+	}
+	return nil
+}
+
+func discard(c *client) error {
+	discarded, err := c.DiscardDraft()
+	if err != nil {
+		return err
+	}
+
+	if discarded {
+		fmt.Println("discarded")
+	} else {
+		fmt.Println("no draft to discard")
+	}
+	return nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Printf("%s [y/N] ", prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func upload(c *client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("no images given")
+	}
+
+	for _, path := range args {
+		ctype, ok := imageTypes[strings.ToLower(filepath.Ext(path))]
+		if !ok {
+			return fmt.Errorf("%s: only PNG and JPEG images are supported", path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		url, err := c.UploadImage(data, ctype)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		fmt.Printf("%s\t%s\n", path, url)
+	}
+
+	return nil
+}
+
+func share(c *client, args []string) error {
+	fs := flag.NewFlagSet("share", flag.ExitOnError)
+	days := fs.Int("days", 7, "expire the link after this many `days` (0 for never)")
+	views := fs.Int64("views", 0, "expire the link after this many `views` (0 for unlimited)")
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		return errors.New("too many arguments")
+	}
+
+	url, err := c.CreateShareLink(*days, *views)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(url)
+	return nil
+}
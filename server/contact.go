@@ -0,0 +1,240 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+)
+
+// Visitors can message a portfolio's owner through its contact form, without
+// the owner putting their email address on the page. Messages go to the
+// owner's inbox.
+
+const (
+	maxContactNameLength    = 100
+	maxContactMessageLength = 5000
+	maxContactLinks         = 3
+
+	contactLimit  = 5
+	contactWindow = time.Hour
+)
+
+// contactLimiter limits how many messages one address can try to send, to any
+// portfolio.
+var contactLimiter = newRateLimiter(contactLimit, contactWindow)
+
+var contactLink = regexp.MustCompile(`(?i)https?://|www\.|\[url`)
+
+type contactMessage struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Message  string `json:"message"`
+	Received string `json:"received"`
+	Read     bool   `json:"read"`
+	Archived bool   `json:"archived"`
+}
+
+// contactHandler takes a message from a visitor to the owner of a portfolio.
+func contactHandler(r *http.Request) (any, error) {
+	var req struct {
+		Username string `json:"username"`
+		Name     string `json:"name"`
+		Email    string `json:"email"`
+		Message  string `json:"message"`
+
+		// Website is not shown to people, so only bots filling in every
+		// field set it.
+		Website string `json:"website"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	// Bots are told their message was sent so they do not try again.
+	if req.Website != "" {
+		return nil, nil
+	}
+
+	ip := remoteIP(r)
+	if !contactLimiter.Allow(ip) {
+		return nil, apis.NewError("too many messages, try again later", http.StatusTooManyRequests)
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	req.Message = strings.TrimSpace(req.Message)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxContactNameLength {
+		return nil, apis.NewError(fmt.Sprintf("name must be between 1 and %d characters", maxContactNameLength), http.StatusBadRequest)
+	}
+	if req.Message == "" || utf8.RuneCountInString(req.Message) > maxContactMessageLength {
+		return nil, apis.NewError(fmt.Sprintf("message must be between 1 and %d characters", maxContactMessageLength), http.StatusBadRequest)
+	}
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Name != "" {
+		return nil, apis.NewError("email must be a valid email address", http.StatusBadRequest)
+	}
+	if len(contactLink.FindAllString(req.Name+" "+req.Message, -1)) > maxContactLinks {
+		return nil, apis.NewError(fmt.Sprintf("messages can have at most %d links", maxContactLinks), http.StatusBadRequest)
+	}
+
+	if err := requireUnlocked(r, req.Username); err != nil {
+		return nil, err
+	}
+
+	var idstr string
+	err = db.QueryRow(`SELECT uuid FROM users WHERE username = ?;`, req.Username).Scan(&idstr)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apis.StatusNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO contact_messages (id, user_uuid, name, email, message, received, read, archived)
+		VALUES (?, ?, ?, ?, ?, ?, 0, 0);
+	`, uuid.New().String(), idstr, req.Name, addr.Address, req.Message, time.Now().Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+
+	if ownerEmail, err := accountEmail(idstr); err != nil {
+		log.Printf("error looking up email of %s for contact email: %v\n", idstr, err)
+	} else {
+		replyTo := (&mail.Address{Name: req.Name, Address: addr.Address}).String()
+		sendReplyableEmail(ownerEmail, replyTo, "contact", struct {
+			Name     string
+			Email    string
+			Message  string
+			InboxURL string
+		}{req.Name, addr.Address, req.Message, frontend + "/editor"})
+	}
+
+	return nil, nil
+}
+
+// listMessagesHandler returns the logged in user's messages, newest first.
+// Archived messages are returned instead if the archived query parameter is
+// true.
+func listMessagesHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	archived := r.URL.Query().Get("archived") == "true"
+	rows, err := db.Query(`
+		SELECT id, name, email, message, received, read, archived
+		FROM contact_messages
+		WHERE user_uuid = ? AND archived = ?
+		ORDER BY received DESC;
+	`, id.String(), archived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := make([]contactMessage, 0)
+	for rows.Next() {
+		var m contactMessage
+		if err := rows.Scan(&m.ID, &m.Name, &m.Email, &m.Message, &m.Received, &m.Read, &m.Archived); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var unread int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM contact_messages WHERE user_uuid = ? AND read = 0 AND archived = 0;
+	`, id.String()).Scan(&unread); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Messages []contactMessage `json:"messages"`
+		Unread   int              `json:"unread"`
+	}{messages, unread}, nil
+}
+
+// updateMessage sets column of one of the logged in user's messages to the
+// value of the same name in the request body.
+func updateMessage(r *http.Request, column string) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	messageID, ok := req["id"].(string)
+	if !ok {
+		return nil, apis.NewError("id must be a string", http.StatusBadRequest)
+	}
+	value, ok := req[column].(bool)
+	if !ok {
+		return nil, apis.NewError(column+" must be a boolean", http.StatusBadRequest)
+	}
+
+	result, err := db.Exec(fmt.Sprintf(`UPDATE contact_messages SET %s = ? WHERE id = ? AND user_uuid = ?;`, column), value, messageID, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+
+	return nil, nil
+}
+
+func markMessageReadHandler(r *http.Request) (any, error) {
+	return updateMessage(r, "read")
+}
+
+func archiveMessageHandler(r *http.Request) (any, error) {
+	return updateMessage(r, "archived")
+}
+
+func deleteMessageHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	result, err := db.Exec(`DELETE FROM contact_messages WHERE id = ? AND user_uuid = ?;`, req.ID, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func sendTestMessage(t *testing.T, body string) error {
+	t.Helper()
+	r := sessionRequest(t, "POST", "/api/contact", body)
+	r.RemoteAddr = "192.0.2.1:1234"
+	_, err := contactHandler(r)
+	return err
+}
+
+func testMessages(t *testing.T, id string, archived bool) ([]contactMessage, int) {
+	t.Helper()
+	res, err := listMessagesHandler(loggedInRequest(t, "GET", fmt.Sprintf("/api/list_messages?archived=%v", archived), "", id))
+	if err != nil {
+		t.Fatalf("listing messages: %v", err)
+	}
+	inbox := res.(struct {
+		Messages []contactMessage `json:"messages"`
+		Unread   int              `json:"unread"`
+	})
+	return inbox.Messages, inbox.Unread
+}
+
+func TestContact(t *testing.T) {
+	newTestDB(t)
+	contactLimiter = newRateLimiter(contactLimit, contactWindow)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+
+	if err := sendTestMessage(t, `{"username": "ada", "name": "Grace", "email": "grace@example.com", "message": "Hello!"}`); err != nil {
+		t.Fatalf("sending: %v", err)
+	}
+	// The honeypot field is only filled in by bots, whose messages are
+	// dropped.
+	if err := sendTestMessage(t, `{"username": "ada", "name": "Bot", "email": "bot@example.com", "message": "Hi", "website": "spam"}`); err != nil {
+		t.Fatalf("sending as a bot: %v", err)
+	}
+
+	for _, body := range []string{
+		`{"username": "ada", "name": "", "email": "grace@example.com", "message": "Hello!"}`,
+		`{"username": "ada", "name": "Grace", "email": "not an address", "message": "Hello!"}`,
+		`{"username": "ada", "name": "Grace", "email": "grace@example.com", "message": "https://a.example https://b.example https://c.example https://d.example"}`,
+	} {
+		if err := sendTestMessage(t, body); errorStatus(err) != http.StatusBadRequest {
+			t.Errorf("sending %s = %v, want 400", body, err)
+		}
+	}
+
+	var replyTo string
+	err := db.QueryRow(`SELECT reply_to FROM mail_queue WHERE recipient = 'ada@example.com';`).Scan(&replyTo)
+	if err != nil || replyTo != `"Grace" <grace@example.com>` {
+		t.Errorf("owner email has Reply-To %q (%v), want Grace", replyTo, err)
+	}
+
+	messages, unread := testMessages(t, ada, false)
+	if len(messages) != 1 || messages[0].Name != "Grace" || unread != 1 {
+		t.Fatalf("inbox has %+v with %d unread, want Grace's message unread", messages, unread)
+	}
+
+	body := `{"id": "` + messages[0].ID + `", "read": true}`
+	if _, err := markMessageReadHandler(loggedInRequest(t, "POST", "/api/mark_message_read", body, ada)); err != nil {
+		t.Fatalf("marking read: %v", err)
+	}
+	body = `{"id": "` + messages[0].ID + `", "archived": true}`
+	if _, err := archiveMessageHandler(loggedInRequest(t, "POST", "/api/archive_message", body, ada)); err != nil {
+		t.Fatalf("archiving: %v", err)
+	}
+	if messages, unread := testMessages(t, ada, false); len(messages) != 0 || unread != 0 {
+		t.Errorf("inbox has %d messages, %d unread after archiving, want none", len(messages), unread)
+	}
+	if messages, _ := testMessages(t, ada, true); len(messages) != 1 || !messages[0].Read {
+		t.Errorf("archive has %+v, want the read message", messages)
+	}
+}
+
+func TestContactRateLimit(t *testing.T) {
+	newTestDB(t)
+	contactLimiter = newRateLimiter(contactLimit, contactWindow)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	// Invalid messages count too, so the limit cannot be probed for free.
+	for range contactLimit {
+		sendTestMessage(t, `{"username": "ada", "name": "", "email": "grace@example.com", "message": "Hello!"}`)
+	}
+	err := sendTestMessage(t, `{"username": "ada", "name": "Grace", "email": "grace@example.com", "message": "Hello!"}`)
+	if errorStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("sending past the limit = %v, want 429", err)
+	}
+}
+
+func TestContactLockedPortfolio(t *testing.T) {
+	newTestDB(t)
+	contactLimiter = newRateLimiter(contactLimit, contactWindow)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	lockTestPortfolio(t, ada)
+
+	err := sendTestMessage(t, `{"username": "ada", "name": "Grace", "email": "grace@example.com", "message": "Hello!"}`)
+	if err != errPortfolioLocked {
+		t.Errorf("messaging a locked portfolio = %v, want errPortfolioLocked", err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+)
+
+// The directory lets people find portfolios by searching them. Published
+// portfolios are kept in an FTS5 table, which needs the server to be built
+// with -tags sqlite_fts5; other builds have no directory to search. Portfolios
+// with a password, and those of users who opted out, are never listed.
+
+// listedCondition is true in queries on users for users whose portfolio can
+// be listed in the directory.
+const listedCondition = `
+	users.uuid NOT IN (SELECT uuid FROM portfolio_passwords)
+	AND users.uuid NOT IN (SELECT uuid FROM directory_optouts)
+`
+
+// refreshDirectory is like updateDirectory, but loads the portfolio itself
+// and logs errors. It is for changes to whether a user is listed.
+func refreshDirectory(id uuid.UUID) {
+	p, err := loadPortfolio(id)
+	if err == nil {
+		err = updateDirectory(id, p)
+	}
+	if err != nil {
+		log.Printf("error updating directory entry of %s: %v\n", id, err)
+	}
+}
+
+// excerpt returns about the first words words of s.
+func excerpt(s string, words int) string {
+	fields := strings.Fields(s)
+	if len(fields) <= words {
+		return strings.Join(fields, " ")
+	}
+	return strings.Join(fields[:words], " ") + "…"
+}
+
+func getDirectoryListingHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var optedOut bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM directory_optouts WHERE uuid = ?);`, id.String()).Scan(&optedOut); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Listed bool `json:"listed"`
+	}{!optedOut}, nil
+}
+
+// setDirectoryListingHandler opts the logged in user in or out of the
+// directory.
+func setDirectoryListingHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		Listed bool `json:"listed"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	if req.Listed {
+		_, err = db.Exec(`DELETE FROM directory_optouts WHERE uuid = ?;`, id.String())
+	} else {
+		_, err = db.Exec(`
+			INSERT INTO directory_optouts (uuid, opted_out) VALUES (?, ?)
+			ON CONFLICT (uuid) DO NOTHING;
+		`, id.String(), time.Now().Format(time.RFC3339))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := loadPortfolio(id)
+	if err != nil {
+		return nil, err
+	}
+	return nil, updateDirectory(id, p)
+}
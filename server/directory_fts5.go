@@ -0,0 +1,313 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+const (
+	directoryPageSize = 20
+	snippetWords      = 24
+)
+
+// directoryRanking weighs matches in each column of the directory table for
+// bm25, in order: uuid, username, name, location, bio, sections, projects.
+const directoryRanking = `bm25(directory, 0, 10.0, 10.0, 3.0, 2.0, 3.0, 1.0)`
+
+// Matches are marked in highlights and snippets with these, which are removed
+// from indexed text so they cannot appear otherwise.
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+var stripMatchMarks = strings.NewReplacer(matchStart, "", matchEnd, "")
+
+// directorySchema creates the directory table.
+const directorySchema = `
+	CREATE VIRTUAL TABLE IF NOT EXISTS directory USING fts5(
+		uuid UNINDEXED,
+		username,
+		name,
+		location,
+		bio,
+		sections,
+		projects,
+		tokenize = 'porter unicode61 remove_diacritics 2'
+	);
+`
+
+// directoryEntry returns the columns of p in the directory table.
+func directoryEntry(username string, p folio.Portfolio) []any {
+	var sections, projects []string
+	for _, section := range p.Sections {
+		sections = append(sections, section.Title)
+		for _, project := range section.Projects {
+			projects = append(projects, project.Name, plainText(project.Description))
+		}
+	}
+
+	columns := []string{
+		username,
+		strings.TrimSpace(p.FirstName + " " + p.LastName),
+		p.Location,
+		plainText(p.Bio),
+		strings.Join(sections, "\n"),
+		strings.Join(projects, "\n"),
+	}
+
+	entry := make([]any, len(columns))
+	for i, c := range columns {
+		entry[i] = stripMatchMarks.Replace(c)
+	}
+	return entry
+}
+
+// createDirectory creates the directory table and fills it.
+func createDirectory() error {
+	if _, err := db.Exec(directorySchema); err != nil {
+		return fmt.Errorf("creating the directory table: %w", err)
+	}
+	return rebuildDirectory()
+}
+
+// updateDirectory lists p, the portfolio of the user with UUID id, in the
+// directory, or removes them from it if they should not be listed.
+func updateDirectory(id uuid.UUID, p folio.Portfolio) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM directory WHERE uuid = ?;`, id.String()); err != nil {
+		return err
+	}
+
+	var username string
+	err = tx.QueryRow(`SELECT username FROM users WHERE uuid = ? AND `+listedCondition+`;`, id.String()).Scan(&username)
+	if err == nil {
+		if _, err := tx.Exec(`
+			INSERT INTO directory (uuid, username, name, location, bio, sections, projects)
+			VALUES (?, ?, ?, ?, ?, ?, ?);
+		`, append([]any{id.String()}, directoryEntry(username, p)...)...); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// rebuildDirectory indexes every listed portfolio from scratch.
+func rebuildDirectory() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM directory;`); err != nil {
+		return err
+	}
+
+	rows, err := tx.Query(`SELECT uuid, username, portfolio FROM users WHERE ` + listedCondition + `;`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	type user struct {
+		id, username string
+		p            folio.Portfolio
+	}
+	var users []user
+	for rows.Next() {
+		var u user
+		var j []byte
+		if err := rows.Scan(&u.id, &u.username, &j); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(j, &u.p); err != nil {
+			log.Printf("skipping %s in directory, could not parse portfolio: %v\n", u.username, err)
+			continue
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if _, err := tx.Exec(`
+			INSERT INTO directory (uuid, username, name, location, bio, sections, projects)
+			VALUES (?, ?, ?, ?, ?, ?, ?);
+		`, append([]any{u.id}, directoryEntry(u.username, u.p)...)...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// matchQuery turns what someone typed into an FTS5 query matching documents
+// with words starting with every word in it, so that search syntax in it is
+// not interpreted.
+func matchQuery(s string) string {
+	var terms []string
+	for _, word := range strings.Fields(stripMatchMarks.Replace(s)) {
+		terms = append(terms, `"`+strings.ReplaceAll(word, `"`, `""`)+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+type highlightedPart struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+// highlighted splits s, as returned by the FTS5 highlight and snippet
+// functions, into the parts that did and did not match.
+func highlighted(s string) []highlightedPart {
+	parts := make([]highlightedPart, 0)
+	for s != "" {
+		start := strings.Index(s, matchStart)
+		if start < 0 {
+			parts = append(parts, highlightedPart{Text: s})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, highlightedPart{Text: s[:start]})
+		}
+		s = s[start+len(matchStart):]
+
+		end := strings.Index(s, matchEnd)
+		if end < 0 {
+			end = len(s)
+		}
+		parts = append(parts, highlightedPart{Text: s[:end], Match: true})
+		s = strings.TrimPrefix(s[end:], matchEnd)
+	}
+	return parts
+}
+
+type directoryResult struct {
+	Username string            `json:"username"`
+	URL      string            `json:"url"`
+	Name     []highlightedPart `json:"name"`
+	Location []highlightedPart `json:"location"`
+	Snippet  []highlightedPart `json:"snippet"`
+}
+
+// searchDirectoryHandler searches the directory for the q query parameter,
+// best matches first, and can filter by the location query parameter. Without
+// either, it lists every portfolio by username. Results come in pages of
+// directoryPageSize, chosen by the page query parameter starting at 1.
+func searchDirectoryHandler(r *http.Request) (any, error) {
+	query := r.URL.Query()
+
+	page := 1
+	if s := query.Get("page"); s != "" {
+		var err error
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			return nil, apis.NewError("page must be a positive integer", http.StatusBadRequest)
+		}
+	}
+
+	match := matchQuery(query.Get("q"))
+	if location := matchQuery(query.Get("location")); location != "" {
+		if match != "" {
+			match += " AND "
+		}
+		match += "location : (" + location + ")"
+	}
+
+	var total int
+	var rows *sql.Rows
+	var err error
+	if match == "" {
+		if err := db.QueryRow(`SELECT COUNT(*) FROM directory;`).Scan(&total); err != nil {
+			return nil, err
+		}
+		rows, err = db.Query(`
+			SELECT username, name, location, '', '', '', bio, canonical.domain
+			FROM directory LEFT JOIN `+canonicalDomains+` AS canonical ON canonical.user_uuid = directory.uuid
+			ORDER BY username
+			LIMIT ? OFFSET ?;
+		`, directoryPageSize, (page-1)*directoryPageSize)
+	} else {
+		if err := db.QueryRow(`SELECT COUNT(*) FROM directory WHERE directory MATCH ?;`, match).Scan(&total); err != nil {
+			return nil, err
+		}
+		rows, err = db.Query(`
+			SELECT
+				username,
+				highlight(directory, 2, ?1, ?2),
+				highlight(directory, 3, ?1, ?2),
+				snippet(directory, 4, ?1, ?2, '…', ?3),
+				snippet(directory, 5, ?1, ?2, '…', ?3),
+				snippet(directory, 6, ?1, ?2, '…', ?3),
+				bio,
+				canonical.domain
+			FROM directory LEFT JOIN `+canonicalDomains+` AS canonical ON canonical.user_uuid = directory.uuid
+			WHERE directory MATCH ?4
+			ORDER BY `+directoryRanking+`
+			LIMIT ?5 OFFSET ?6;
+		`, matchStart, matchEnd, snippetWords, match, directoryPageSize, (page-1)*directoryPageSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]directoryResult, 0)
+	for rows.Next() {
+		var username, name, location, bio string
+		var snippets [3]string
+		var domain sql.NullString
+		if err := rows.Scan(&username, &name, &location, &snippets[0], &snippets[1], &snippets[2], &bio, &domain); err != nil {
+			return nil, err
+		}
+
+		// The snippet shows where the bio or a project matched, since the
+		// name and location are shown anyway.
+		snippet := excerpt(bio, snippetWords)
+		for _, s := range snippets {
+			if strings.Contains(s, matchStart) {
+				snippet = s
+				break
+			}
+		}
+
+		results = append(results, directoryResult{
+			Username: username,
+			URL:      canonicalURL(username, domain),
+			Name:     highlighted(name),
+			Location: highlighted(location),
+			Snippet:  highlighted(snippet),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Results  []directoryResult `json:"results"`
+		Total    int               `json:"total"`
+		Page     int               `json:"page"`
+		PageSize int               `json:"pageSize"`
+	}{results, total, page, directoryPageSize}, nil
+}
@@ -0,0 +1,28 @@
+//go:build !sqlite_fts5
+
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+// Without FTS5 there is no directory table: nothing is indexed, and searches
+// are refused.
+
+func createDirectory() error {
+	log.Println("built without -tags sqlite_fts5, the directory is disabled")
+	return nil
+}
+
+func updateDirectory(id uuid.UUID, p folio.Portfolio) error {
+	return nil
+}
+
+func searchDirectoryHandler(r *http.Request) (any, error) {
+	return nil, apis.NewError("the directory is not available on this server", http.StatusNotImplemented)
+}
@@ -0,0 +1,17 @@
+//go:build !sqlite_fts5
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDirectoryDisabled(t *testing.T) {
+	newTestDB(t)
+	_, err := searchDirectoryHandler(httptest.NewRequest("GET", "/api/search_directory?q=ada", nil))
+	if status := errorStatus(err); status != http.StatusNotImplemented {
+		t.Errorf("searching without FTS5: %v, want status %d", err, http.StatusNotImplemented)
+	}
+}
@@ -0,0 +1,146 @@
+//go:build sqlite_fts5
+
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/folio"
+)
+
+func TestMatchQuery(t *testing.T) {
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"ada", `"ada"*`},
+		{"  ada   lovelace ", `"ada"* "lovelace"*`},
+		{`say "hi"`, `"say"* """hi"""*`},
+		{"a OR b", `"a"* "OR"* "b"*`},
+		{"name:ada", `"name:ada"*`},
+		{"ad\x02a\x03", `"ada"*`},
+	} {
+		if got := matchQuery(test.in); got != test.want {
+			t.Errorf("matchQuery(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestHighlighted(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		want []highlightedPart
+	}{
+		{"", []highlightedPart{}},
+		{"plain", []highlightedPart{{Text: "plain"}}},
+		{"\x02all\x03", []highlightedPart{{Text: "all", Match: true}}},
+		{"an \x02ada\x03 match", []highlightedPart{
+			{Text: "an "},
+			{Text: "ada", Match: true},
+			{Text: " match"},
+		}},
+		{"\x02a\x03\x02b\x03 c", []highlightedPart{
+			{Text: "a", Match: true},
+			{Text: "b", Match: true},
+			{Text: " c"},
+		}},
+		{"cut \x02off", []highlightedPart{
+			{Text: "cut "},
+			{Text: "off", Match: true},
+		}},
+	} {
+		if got := highlighted(test.in); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("highlighted(%q) = %+v, want %+v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	for _, test := range []struct {
+		in    string
+		words int
+		want  string
+	}{
+		{"", 3, ""},
+		{"one  two\nthree", 3, "one two three"},
+		{"one two three four", 3, "one two three…"},
+	} {
+		if got := excerpt(test.in, test.words); got != test.want {
+			t.Errorf("excerpt(%q, %d) = %q, want %q", test.in, test.words, got, test.want)
+		}
+	}
+}
+
+// listTestPortfolio creates a user listed in the directory with p.
+func listTestPortfolio(t *testing.T, username string, p folio.Portfolio) string {
+	t.Helper()
+	id := createTestUser(t, username, testIdentity(username))
+	if err := updateDirectory(uuid.MustParse(id), p); err != nil {
+		t.Fatalf("listing %s: %v", username, err)
+	}
+	return id
+}
+
+func searchDirectory(t *testing.T, query string) []directoryResult {
+	t.Helper()
+	res, err := searchDirectoryHandler(httptest.NewRequest("GET", "/api/search_directory?"+query, nil))
+	if err != nil {
+		t.Fatalf("searching %q: %v", query, err)
+	}
+	return reflect.ValueOf(res).FieldByName("Results").Interface().([]directoryResult)
+}
+
+func TestSearchDirectory(t *testing.T) {
+	newTestDB(t)
+	listTestPortfolio(t, "ada", folio.Portfolio{FirstName: "Ada", LastName: "Lovelace", Location: "London", Bio: "Wrote the first **program**."})
+	grace := listTestPortfolio(t, "grace", folio.Portfolio{FirstName: "Grace", LastName: "Hopper", Location: "New York", Bio: "Wrote the first compiler."})
+	linus := listTestPortfolio(t, "linus", folio.Portfolio{FirstName: "Linus", Location: "Portland", Bio: "Wrote a kernel."})
+	verifyTestDomain(t, grace, "grace.example", time.Now())
+	lockTestPortfolio(t, linus)
+	refreshDirectory(uuid.MustParse(linus))
+
+	for _, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"ada", "grace"}},
+		{"q=wrote", []string{"ada", "grace"}},
+		{"q=compil", []string{"grace"}},
+		{"q=kernel", nil},
+		{"q=wrote&location=london", []string{"ada"}},
+		{"location=new+york", []string{"grace"}},
+	} {
+		var got []string
+		for _, result := range searchDirectory(t, test.query) {
+			got = append(got, result.Username)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("searching %q found %q, want %q", test.query, got, test.want)
+		}
+	}
+
+	for _, query := range []string{"", "q=first"} {
+		urls := make(map[string]string)
+		for _, result := range searchDirectory(t, query) {
+			urls[result.Username] = result.URL
+		}
+		want := map[string]string{"ada": frontend + "/ada", "grace": "https://grace.example/"}
+		if !reflect.DeepEqual(urls, want) {
+			t.Errorf("searching %q gave URLs %v, want %v", query, urls, want)
+		}
+	}
+
+	results := searchDirectory(t, "q=program")
+	if len(results) != 1 {
+		t.Fatalf("searching program found %d results, want 1", len(results))
+	}
+	want := []highlightedPart{{Text: "Wrote the first "}, {Text: "program", Match: true}, {Text: "."}}
+	if !reflect.DeepEqual(results[0].Snippet, want) {
+		t.Errorf("snippet is %+v, want %+v", results[0].Snippet, want)
+	}
+}
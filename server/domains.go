@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+)
+
+// Custom domains let a user serve their portfolio from a domain they own,
+// like portfolio.janedoe.com. A domain is only served once the user proves
+// they own it with a TXT record holding the token they were given. Verified
+// domains are checked again every domainCheckEvery, and stop being served
+// once the record is gone, so a domain that changes hands does not keep
+// showing its old owner's portfolio.
+
+// txtResolver looks up DNS TXT records. It is satisfied by [net.Resolver].
+type txtResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// domainResolver is used to verify domains. It can be replaced with a fake to
+// verify domains without DNS.
+var domainResolver txtResolver = net.DefaultResolver
+
+const (
+	domainTokenPrefix    = "foliospot-verification="
+	domainRecordPrefix   = "_foliospot."
+	maxDomainsPerUser    = 5
+	domainLookupTimeout  = 10 * time.Second
+	domainCheckEvery     = 6 * time.Hour
+	maxDomainLength      = 253
+	maxDomainLabelLength = 63
+)
+
+var domainLabel = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
+
+// normalizeDomain returns domain in the form it is stored in, or false if it
+// is not a domain name that can be registered.
+func normalizeDomain(domain string) (string, bool) {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if len(domain) > maxDomainLength {
+		return "", false
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return "", false
+	}
+	for _, label := range labels {
+		if len(label) > maxDomainLabelLength || !domainLabel.MatchString(label) {
+			return "", false
+		}
+	}
+
+	// The site's own hosts and user subdomains cannot be claimed.
+	for _, own := range []string{frontend, backend, "https://" + baseDomain} {
+		if u, err := url.Parse(own); err == nil && u.Hostname() != "" {
+			if domain == u.Hostname() || strings.HasSuffix(domain, "."+u.Hostname()) {
+				return "", false
+			}
+		}
+	}
+
+	return domain, true
+}
+
+// isOwnHost reports whether host is the front end's or the server's own.
+func isOwnHost(host string) bool {
+	for _, own := range []string{frontend, backend} {
+		if u, err := url.Parse(own); err == nil && strings.EqualFold(u.Hostname(), host) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestHost returns the host r was sent to, without a port.
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// domainUsername returns the user whose portfolio is served on domain, if it
+// is a verified custom domain.
+func domainUsername(domain string) (string, bool) {
+	var username string
+	err := db.QueryRow(`
+		SELECT users.username
+		FROM custom_domains JOIN users ON users.uuid = custom_domains.user_uuid
+		WHERE custom_domains.domain = ? AND custom_domains.verified IS NOT NULL;
+	`, domain).Scan(&username)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("error looking up domain %s: %v\n", domain, err)
+	}
+	return username, err == nil
+}
+
+// hostUsername returns the user whose portfolio is served on host, which is
+// either one of their custom domains or their subdomain.
+func hostUsername(host string) (string, bool) {
+	if username, ok := subdomainUsername(host); ok {
+		return username, true
+	}
+	return domainUsername(host)
+}
+
+// isPortfolioOrigin reports whether origin serves a portfolio, from a custom
+// domain or a subdomain, so its pages may call the API.
+func isPortfolioOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != "https" && u.Scheme != "http" {
+		return false
+	}
+	_, ok := hostUsername(strings.ToLower(u.Hostname()))
+	return ok
+}
+
+// canonicalDomains is a table of the domain each user's portfolio is
+// canonically served on, for those with a verified custom domain: the first
+// they verified. It is for joining on user_uuid, so listings of portfolios
+// need not look up each one's URL.
+const canonicalDomains = `(
+	SELECT user_uuid, domain, MIN(verified) FROM custom_domains
+	WHERE verified IS NOT NULL
+	GROUP BY user_uuid
+)`
+
+// portfolioURL returns the canonical URL of the portfolio published under
+// username, which is on its custom domain if it has one.
+func portfolioURL(username string) string {
+	var domain sql.NullString
+	db.QueryRow(`
+		SELECT canonical.domain
+		FROM users LEFT JOIN `+canonicalDomains+` AS canonical ON canonical.user_uuid = users.uuid
+		WHERE users.username = ?;
+	`, username).Scan(&domain)
+	return canonicalURL(username, domain)
+}
+
+// canonicalURL returns the canonical URL of the portfolio published under
+// username, given the domain it has from canonicalDomains.
+func canonicalURL(username string, domain sql.NullString) string {
+	if domain.Valid {
+		return "https://" + domain.String + "/"
+	}
+	return frontend + "/" + username
+}
+
+// portfolioHostHandler serves portfolios on their custom domains and
+// subdomains, passing requests to any other host on to next. On those hosts,
+// / is the portfolio, /sitemap.xml and /robots.txt list it, and the client's
+// files are served as usual so the page can load.
+func portfolioHostHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := requestHost(r)
+		if isOwnHost(host) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		username, ok := hostUsername(host)
+		if !ok {
+			if !redirectRenamedSubdomain(w, r, host) {
+				next.ServeHTTP(w, r)
+			}
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/"):
+			next.ServeHTTP(w, r)
+		case r.URL.Path == "/sitemap.xml" || r.URL.Path == "/robots.txt":
+			serveDomainSitemap(w, r, host, username)
+		case isClientFile(r.URL.Path):
+			clientHandler().ServeHTTP(w, r)
+		case r.URL.Path == "/":
+			servePortfolioPage(w, r, username, true)
+		default:
+			http.Redirect(w, r, "/", http.StatusFound)
+		}
+	})
+}
+
+type domainInfo struct {
+	Domain   string `json:"domain"`
+	Record   string `json:"record"`
+	Token    string `json:"token"`
+	Created  string `json:"created"`
+	Verified string `json:"verified,omitempty"`
+}
+
+func newDomainInfo(domain, token, created string, verified sql.NullString) domainInfo {
+	return domainInfo{
+		Domain:   domain,
+		Record:   domainRecordPrefix + domain,
+		Token:    domainTokenPrefix + token,
+		Created:  created,
+		Verified: verified.String,
+	}
+}
+
+func loadDomain(id uuid.UUID, domain string) (domainInfo, error) {
+	var token, created string
+	var verified sql.NullString
+	err := db.QueryRow(`
+		SELECT token, created, verified FROM custom_domains WHERE domain = ? AND user_uuid = ?;
+	`, domain, id.String()).Scan(&token, &created, &verified)
+	if errors.Is(err, sql.ErrNoRows) {
+		return domainInfo{}, apis.StatusNotFound
+	} else if err != nil {
+		return domainInfo{}, err
+	}
+	return newDomainInfo(domain, token, created, verified), nil
+}
+
+func decodeDomainRequest(r *http.Request) (string, error) {
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return "", apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	domain, ok := normalizeDomain(req.Domain)
+	if !ok {
+		return "", apis.NewError(fmt.Sprintf("%q is not a valid domain", req.Domain), http.StatusBadRequest)
+	}
+	return domain, nil
+}
+
+func listDomainsHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT domain, token, created, verified FROM custom_domains
+		WHERE user_uuid = ?
+		ORDER BY created;
+	`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	domains := make([]domainInfo, 0)
+	for rows.Next() {
+		var domain, token, created string
+		var verified sql.NullString
+		if err := rows.Scan(&domain, &token, &created, &verified); err != nil {
+			return nil, err
+		}
+		domains = append(domains, newDomainInfo(domain, token, created, verified))
+	}
+
+	return domains, rows.Err()
+}
+
+// addDomainHandler registers a domain for the logged in user. The response
+// has the TXT record to create to verify it.
+func addDomainHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	domain, err := decodeDomainRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM custom_domains WHERE user_uuid = ?;`, id.String()).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= maxDomainsPerUser {
+		return nil, apis.NewError(fmt.Sprintf("you can have at most %d domains", maxDomainsPerUser), http.StatusConflict)
+	}
+
+	// A domain registered but never verified by someone else can be taken
+	// over, since they have not shown that it is theirs.
+	token := newRandomToken("")
+	created := time.Now().Format(time.RFC3339)
+	result, err := db.Exec(`
+		INSERT INTO custom_domains (domain, user_uuid, token, created)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (domain) DO UPDATE SET
+			user_uuid = excluded.user_uuid,
+			token = excluded.token,
+			created = excluded.created
+		WHERE custom_domains.verified IS NULL AND custom_domains.user_uuid != excluded.user_uuid;
+	`, domain, id.String(), token, created)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.NewError(fmt.Sprintf("%s is already registered", domain), http.StatusConflict)
+	}
+
+	return newDomainInfo(domain, token, created, sql.NullString{}), nil
+}
+
+// hasDomainToken looks up the TXT record of info's domain and reports whether
+// it has info's token. A missing record does not have it.
+func hasDomainToken(ctx context.Context, info domainInfo) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, domainLookupTimeout)
+	defer cancel()
+
+	records, err := domainResolver.LookupTXT(ctx, info.Record)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return slices.Contains(records, info.Token), nil
+}
+
+// verifyDomainHandler checks the TXT record of one of the logged in user's
+// domains, and starts serving their portfolio on it if the token is there.
+func verifyDomainHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	domain, err := decodeDomainRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := loadDomain(id, domain)
+	if err != nil {
+		return nil, err
+	}
+	if info.Verified != "" {
+		return info, nil
+	}
+
+	if ok, err := hasDomainToken(r.Context(), info); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, apis.NewErrorWithData(
+			fmt.Sprintf("TXT record %s does not contain %s", info.Record, info.Token),
+			http.StatusUnprocessableEntity, info)
+	}
+
+	info.Verified = time.Now().Format(time.RFC3339)
+	if _, err := db.Exec(`
+		UPDATE custom_domains SET verified = ? WHERE domain = ? AND user_uuid = ?;
+	`, info.Verified, domain, id.String()); err != nil {
+		return nil, err
+	}
+
+	return info, nil
+}
+
+// checkDomains checks the TXT record of every verified domain again, and
+// stops serving those whose token is gone. Domains whose record cannot be
+// looked up are left as they are until the next check, so an outage at their
+// DNS host does not unverify them.
+func checkDomains() error {
+	rows, err := db.Query(`SELECT domain, user_uuid, token, created, verified FROM custom_domains WHERE verified IS NOT NULL;`)
+	if err != nil {
+		return err
+	}
+	type domain struct {
+		info   domainInfo
+		userID string
+	}
+	var domains []domain
+	for rows.Next() {
+		var d domain
+		var name, token, created string
+		var verified sql.NullString
+		if err := rows.Scan(&name, &d.userID, &token, &created, &verified); err != nil {
+			rows.Close()
+			return err
+		}
+		d.info = newDomainInfo(name, token, created, verified)
+		domains = append(domains, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range domains {
+		ok, err := hasDomainToken(context.Background(), d.info)
+		if err != nil {
+			log.Printf("error checking domain %s: %v\n", d.info.Domain, err)
+			continue
+		} else if ok {
+			continue
+		}
+
+		// The domain is only unverified if it was not verified again, by
+		// the same or another user, while it was being looked up.
+		if _, err := db.Exec(`
+			UPDATE custom_domains SET verified = NULL WHERE domain = ? AND user_uuid = ? AND verified = ?;
+		`, d.info.Domain, d.userID, d.info.Verified); err != nil {
+			return err
+		}
+		log.Printf("unverified domain %s, its TXT record no longer has its token\n", d.info.Domain)
+	}
+	return nil
+}
+
+// runDomainChecks checks domains every domainCheckEvery, forever.
+func runDomainChecks() {
+	for {
+		time.Sleep(domainCheckEvery)
+		if err := checkDomains(); err != nil {
+			log.Printf("error checking domains: %v\n", err)
+		}
+	}
+}
+
+func removeDomainHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	domain, err := decodeDomainRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := db.Exec(`DELETE FROM custom_domains WHERE domain = ? AND user_uuid = ?;`, domain, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+
+	return nil, nil
+}
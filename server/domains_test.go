@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeResolver answers TXT lookups from records, failing lookups of names in
+// errs with their error.
+type fakeResolver struct {
+	records map[string][]string
+	errs    map[string]error
+}
+
+func (r fakeResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	if err, ok := r.errs[name]; ok {
+		return nil, err
+	}
+	if records, ok := r.records[name]; ok {
+		return records, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+// useResolver makes domains be verified against r for the rest of the test.
+func useResolver(t *testing.T, r fakeResolver) {
+	t.Helper()
+	old := domainResolver
+	domainResolver = r
+	t.Cleanup(func() { domainResolver = old })
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	oldFrontend, oldBackend, oldBaseDomain := frontend, backend, baseDomain
+	t.Cleanup(func() { frontend, backend, baseDomain = oldFrontend, oldBackend, oldBaseDomain })
+	frontend = "https://foliospot.io"
+	backend = "https://api.foliospot.io"
+	baseDomain = "foliospot.dev"
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"portfolio.example.com", "portfolio.example.com"},
+		{"  Portfolio.Example.COM. ", "portfolio.example.com"},
+		{"example.com", "example.com"},
+		{"my-site.example.co.uk", "my-site.example.co.uk"},
+		{"xn--bcher-kva.example", "xn--bcher-kva.example"},
+		{"", ""},
+		{"localhost", ""},
+		{"example..com", ""},
+		{"-example.com", ""},
+		{"example-.com", ""},
+		{"exa_mple.com", ""},
+		{"bücher.example", ""},
+		{"example.com/path", ""},
+		{"https://example.com", ""},
+		{strings.Repeat("a", 64) + ".com", ""},
+		{"foliospot.io", ""},
+		{"www.foliospot.io", ""},
+		{"api.foliospot.io", ""},
+		{"ada.foliospot.dev", ""},
+		{"notfoliospot.io", "notfoliospot.io"},
+	} {
+		got, ok := normalizeDomain(test.in)
+		if ok != (test.want != "") || got != test.want {
+			t.Errorf("normalizeDomain(%q) = %q, %v, want %q", test.in, got, ok, test.want)
+		}
+	}
+}
+
+// addTestDomain registers domain for the user with UUID id, returning the
+// TXT record value verifying it.
+func addTestDomain(t *testing.T, id, domain string) string {
+	t.Helper()
+	res, err := addDomainHandler(loggedInRequest(t, "POST", "/api/add_domain", `{"domain": "`+domain+`"}`, id))
+	if err != nil {
+		t.Fatalf("adding %s: %v", domain, err)
+	}
+	return res.(domainInfo).Token
+}
+
+func verifyDomain(t *testing.T, id, domain string) error {
+	t.Helper()
+	_, err := verifyDomainHandler(loggedInRequest(t, "POST", "/api/verify_domain", `{"domain": "`+domain+`"}`, id))
+	return err
+}
+
+func TestVerifyDomain(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	token := addTestDomain(t, ada, "ada.example")
+
+	useResolver(t, fakeResolver{})
+	if err := verifyDomain(t, ada, "ada.example"); errorStatus(err) != http.StatusUnprocessableEntity {
+		t.Errorf("verifying without a record = %v, want 422", err)
+	}
+	useResolver(t, fakeResolver{records: map[string][]string{"_foliospot.ada.example": {"something else"}}})
+	if err := verifyDomain(t, ada, "ada.example"); errorStatus(err) != http.StatusUnprocessableEntity {
+		t.Errorf("verifying with the wrong record = %v, want 422", err)
+	}
+	useResolver(t, fakeResolver{errs: map[string]error{"_foliospot.ada.example": errors.New("timeout")}})
+	if err := verifyDomain(t, ada, "ada.example"); err == nil {
+		t.Error("verifying succeeded when the lookup failed")
+	}
+	if username, ok := domainUsername("ada.example"); ok {
+		t.Fatalf("ada.example serves %s before being verified", username)
+	}
+
+	useResolver(t, fakeResolver{records: map[string][]string{"_foliospot.ada.example": {"v=spf1 -all", token}}})
+	if err := verifyDomain(t, ada, "ada.example"); err != nil {
+		t.Fatalf("verifying: %v", err)
+	}
+	if username, ok := domainUsername("ada.example"); !ok || username != "ada" {
+		t.Errorf("ada.example serves %q, %v, want ada", username, ok)
+	}
+}
+
+func TestAddDomainTakeover(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+
+	// Unverified domains can be claimed by someone else, verified ones not.
+	addTestDomain(t, ada, "shared.example")
+	token := addTestDomain(t, grace, "shared.example")
+	useResolver(t, fakeResolver{records: map[string][]string{"_foliospot.shared.example": {token}}})
+	if err := verifyDomain(t, grace, "shared.example"); err != nil {
+		t.Fatalf("verifying: %v", err)
+	}
+
+	_, err := addDomainHandler(loggedInRequest(t, "POST", "/api/add_domain", `{"domain": "shared.example"}`, ada))
+	if errorStatus(err) != http.StatusConflict {
+		t.Errorf("adding a domain verified by someone else = %v, want 409", err)
+	}
+}
+
+func TestCheckDomains(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	kept := addTestDomain(t, ada, "kept.example")
+	moved := addTestDomain(t, ada, "moved.example")
+	gone := addTestDomain(t, ada, "gone.example")
+	failing := addTestDomain(t, ada, "failing.example")
+
+	useResolver(t, fakeResolver{records: map[string][]string{
+		"_foliospot.kept.example":    {kept},
+		"_foliospot.moved.example":   {moved},
+		"_foliospot.gone.example":    {gone},
+		"_foliospot.failing.example": {failing},
+	}})
+	for _, domain := range []string{"kept.example", "moved.example", "gone.example", "failing.example"} {
+		if err := verifyDomain(t, ada, domain); err != nil {
+			t.Fatalf("verifying %s: %v", domain, err)
+		}
+	}
+
+	useResolver(t, fakeResolver{
+		records: map[string][]string{
+			"_foliospot.kept.example":  {kept},
+			"_foliospot.moved.example": {"foliospot-verification=someone-else"},
+		},
+		errs: map[string]error{"_foliospot.failing.example": errors.New("timeout")},
+	})
+	if err := checkDomains(); err != nil {
+		t.Fatalf("checking domains: %v", err)
+	}
+
+	for domain, want := range map[string]bool{
+		"kept.example":    true,
+		"moved.example":   false,
+		"gone.example":    false,
+		"failing.example": true,
+	} {
+		if _, ok := domainUsername(domain); ok != want {
+			t.Errorf("after checking, %s is served: %v, want %v", domain, ok, want)
+		}
+	}
+}
+
+func TestCustomDomainCORS(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	if _, err := db.Exec(`
+		INSERT INTO custom_domains (domain, user_uuid, token, created, verified) VALUES ('ada.example', ?, 'token', ?, ?);
+	`, ada, "2024-01-01T00:00:00Z", "2024-01-01T00:00:00Z"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		origin, path string
+		allowed      string
+		credentials  bool
+	}{
+		{frontend, "/api/get_portfolio?username=ada", frontend, true},
+		{"https://ada.example", "/api/get_portfolio?username=ada", "https://ada.example", false},
+		{"https://other.example", "/api/get_portfolio?username=ada", frontend, true},
+		{"https://ada.example", "/api/get_login", frontend, true},
+	} {
+		r := httptest.NewRequest("OPTIONS", test.path, nil)
+		r.Header.Set("Origin", test.origin)
+		w := httptest.NewRecorder()
+		routes().ServeHTTP(w, r)
+
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.allowed {
+			t.Errorf("%s from %s allows origin %q, want %q", test.path, test.origin, got, test.allowed)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials") == "true"; got != test.credentials {
+			t.Errorf("%s from %s allows credentials: %v, want %v", test.path, test.origin, got, test.credentials)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+// Drafts let a portfolio be changed without the changes being public until
+// they are published. Drafts are made by the folio command, and can be shown
+// to others through share links. The editor saves straight to the published
+// portfolio and leaves any draft alone: a draft is kept until it is published
+// or discarded, which the editor offers while there is one.
+
+// loadSavedDraft returns the draft saved by the user with UUID id. Returns
+// [sql.ErrNoRows] if they have none.
+func loadSavedDraft(q queryer, id uuid.UUID) (folio.Portfolio, error) {
+	return scanPortfolio(q.QueryRow(`SELECT portfolio FROM drafts WHERE uuid = ?;`, id.String()))
+}
+
+// loadDraft returns the draft saved by the user with UUID id, or their
+// published portfolio if they have no draft.
+func loadDraft(id uuid.UUID) (folio.Portfolio, error) {
+	p, err := loadSavedDraft(db, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return loadPortfolio(id)
+	}
+	return p, err
+}
+
+// saveDraft stores p as the draft of the user with UUID id. Its projects get
+// the same IDs they will have once published.
+func saveDraft(id uuid.UUID, p folio.Portfolio) error {
+	folio.AssignProjectIDs(&p, id.String())
+	j, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	t := time.Now().Format(time.RFC3339)
+	_, err = db.Exec(`
+		INSERT INTO drafts (uuid, portfolio, last_saved)
+		VALUES (?, ?, ?)
+		ON CONFLICT (uuid) DO UPDATE SET
+			portfolio = excluded.portfolio,
+			last_saved = excluded.last_saved;
+	`, id.String(), j, t)
+	return err
+}
+
+// publishDraft replaces the published portfolio of the user with UUID id with
+// their draft, which is used up, returning false if there was no draft to
+// publish.
+func publishDraft(id uuid.UUID) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	p, err := loadSavedDraft(tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := writePortfolio(tx, id, p); err != nil {
+		return false, err
+	}
+	if _, err := tx.Exec(`DELETE FROM drafts WHERE uuid = ?;`, id.String()); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+
+	portfolioSaved(id, p)
+	return true, nil
+}
+
+// discardDraft deletes the draft of the user with UUID id, returning false if
+// there was none.
+func discardDraft(id uuid.UUID) (bool, error) {
+	result, err := db.Exec(`DELETE FROM drafts WHERE uuid = ?;`, id.String())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func getDraftHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeRead)
+	if err != nil {
+		return nil, err
+	}
+
+	return loadDraft(id)
+}
+
+// putDraftHandler saves the posted portfolio as the logged in user's draft.
+// Unlike put_portfolio, the portfolio is validated first since it usually
+// comes from a hand-edited file.
+func putDraftHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	var p folio.Portfolio
+	if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	folio.Normalize(&p)
+	if errs := folio.Validate(p); errs != nil {
+		return nil, apis.NewErrorWithData(fmt.Sprintf("invalid portfolio: %s", errs[0]), http.StatusUnprocessableEntity, errs)
+	}
+
+	return nil, saveDraft(id, p)
+}
+
+// publishHandler publishes the logged in user's draft. The response says
+// whether there was a draft to publish.
+func publishHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	published, err := publishDraft(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Published bool `json:"published"`
+	}{published}, nil
+}
+
+// draftStatusHandler says whether the logged in user has a draft, and when it
+// was last saved.
+func draftStatusHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeRead)
+	if err != nil {
+		return nil, err
+	}
+
+	type status struct {
+		HasDraft  bool   `json:"hasDraft"`
+		LastSaved string `json:"lastSaved,omitempty"`
+	}
+	var lastSaved string
+	err = db.QueryRow(`SELECT last_saved FROM drafts WHERE uuid = ?;`, id.String()).Scan(&lastSaved)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return status{true, lastSaved}, nil
+}
+
+// discardDraftHandler deletes the logged in user's draft. The response says
+// whether there was a draft to discard.
+func discardDraftHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	discarded, err := discardDraft(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Discarded bool `json:"discarded"`
+	}{discarded}, nil
+}
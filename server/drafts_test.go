@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/folio"
+)
+
+func putTestDraft(t *testing.T, id string, p folio.Portfolio) error {
+	t.Helper()
+	_, err := putDraftHandler(loggedInRequest(t, "POST", "/api/put_draft", string(Must(json.Marshal(p))), id))
+	return err
+}
+
+func publishTestDraft(t *testing.T, id string) bool {
+	t.Helper()
+	res, err := publishHandler(loggedInRequest(t, "POST", "/api/publish", "", id))
+	if err != nil {
+		t.Fatalf("publishing: %v", err)
+	}
+	return res.(struct {
+		Published bool `json:"published"`
+	}).Published
+}
+
+func TestDrafts(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	id := uuid.MustParse(ada)
+
+	if publishTestDraft(t, ada) {
+		t.Error("published without a draft")
+	}
+
+	draft := defaultPortfolio
+	draft.FirstName = "Ada"
+	if err := putTestDraft(t, ada, draft); err != nil {
+		t.Fatalf("putting draft: %v", err)
+	}
+	if p, _ := loadPortfolio(id); p.FirstName == "Ada" {
+		t.Error("draft was public before being published")
+	}
+	if p, err := loadDraft(id); err != nil || p.FirstName != "Ada" {
+		t.Errorf("loadDraft = %q, %v, want the draft", p.FirstName, err)
+	}
+
+	if !publishTestDraft(t, ada) {
+		t.Fatal("draft was not published")
+	}
+	if p, _ := loadPortfolio(id); p.FirstName != "Ada" {
+		t.Errorf("published portfolio has first name %q, want Ada", p.FirstName)
+	}
+	if publishTestDraft(t, ada) {
+		t.Error("draft was published twice")
+	}
+}
+
+func TestPutDraftValidates(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+
+	draft := defaultPortfolio
+	draft.Font = "comic sans"
+	if err := putTestDraft(t, ada, draft); errorStatus(err) != http.StatusUnprocessableEntity {
+		t.Errorf("putting an invalid draft = %v, want 422", err)
+	}
+}
+
+func TestSavePortfolioKeepsDraft(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	id := uuid.MustParse(ada)
+
+	draft := defaultPortfolio
+	draft.FirstName = "Draft"
+	if err := putTestDraft(t, ada, draft); err != nil {
+		t.Fatalf("putting draft: %v", err)
+	}
+
+	saved := defaultPortfolio
+	saved.FirstName = "Saved"
+	if err := savePortfolio(id, saved); err != nil {
+		t.Fatalf("saving: %v", err)
+	}
+	if p, err := loadDraft(id); err != nil || p.FirstName != "Draft" {
+		t.Errorf("draft after saving has first name %q, %v, want Draft", p.FirstName, err)
+	}
+
+	res, err := draftStatusHandler(loggedInRequest(t, "GET", "/api/draft_status", "", ada))
+	if err != nil {
+		t.Fatalf("getting draft status: %v", err)
+	}
+	if !reflect.ValueOf(res).FieldByName("HasDraft").Bool() {
+		t.Error("draft status says there is no draft")
+	}
+}
+
+func TestDiscardDraft(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	id := uuid.MustParse(ada)
+
+	discard := func() bool {
+		t.Helper()
+		res, err := discardDraftHandler(loggedInRequest(t, "POST", "/api/discard_draft", "", ada))
+		if err != nil {
+			t.Fatalf("discarding: %v", err)
+		}
+		return res.(struct {
+			Discarded bool `json:"discarded"`
+		}).Discarded
+	}
+
+	draft := defaultPortfolio
+	draft.FirstName = "Draft"
+	if err := putTestDraft(t, ada, draft); err != nil {
+		t.Fatalf("putting draft: %v", err)
+	}
+	if !discard() {
+		t.Fatal("draft was not discarded")
+	}
+	if discard() {
+		t.Error("draft was discarded twice")
+	}
+
+	if publishTestDraft(t, ada) {
+		t.Error("discarded draft was published")
+	}
+	if p, _ := loadPortfolio(id); p.FirstName == "Draft" {
+		t.Error("discarded draft is public")
+	}
+}
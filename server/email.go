@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/mail"
+)
+
+const defaultMailFrom = "foliospot <noreply@foliospot.io>"
+
+var mailer *mail.Queue
+
+// newMailTransport returns an SMTP transport if SMTP_ADDR is set. Otherwise,
+// as in development, mail is written to MAIL_LOG_DIR, or to the log if that is
+// not set either.
+func newMailTransport() mail.Transport {
+	if addr := os.Getenv("SMTP_ADDR"); addr != "" {
+		return mail.SMTPTransport{
+			Addr:     addr,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+	return mail.LogTransport{Dir: os.Getenv("MAIL_LOG_DIR")}
+}
+
+// sendEmail queues the email called name, made with data, to to. Errors are
+// logged rather than returned, since nothing should fail because an email
+// could not be sent.
+func sendEmail(to, name string, data any) {
+	sendReplyableEmail(to, "", name, data)
+}
+
+// sendReplyableEmail is like sendEmail, with replies going to replyTo rather
+// than the address mail is sent from.
+func sendReplyableEmail(to, replyTo, name string, data any) {
+	m, err := mail.Render(to, name, data)
+	if err != nil {
+		log.Printf("error rendering %s email: %v\n", name, err)
+		return
+	}
+	m.ReplyTo = replyTo
+	if err := mailer.Enqueue(m); err != nil {
+		log.Printf("error queueing %s email to %s: %v\n", name, to, err)
+	}
+}
+
+// sendAccountEmail is like sendEmail, to the user with UUID id.
+func sendAccountEmail(id uuid.UUID, name string, data any) {
+	email, err := accountEmail(id.String())
+	if err != nil {
+		log.Printf("error looking up email of %s for %s email: %v\n", id, name, err)
+		return
+	}
+	sendEmail(email, name, data)
+}
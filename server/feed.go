@@ -0,0 +1,159 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Every blog has an Atom feed of its latest posts at
+// /blog/{username}/feed.xml. Protected portfolios have no feed, since feed
+// readers cannot unlock them.
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomPerson struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomCategory struct {
+	Term string `xml:"term,attr"`
+}
+
+type atomEntry struct {
+	ID         string         `xml:"id"`
+	Title      string         `xml:"title"`
+	Links      []atomLink     `xml:"link"`
+	Published  string         `xml:"published"`
+	Updated    string         `xml:"updated"`
+	Summary    atomText       `xml:"summary"`
+	Content    atomText       `xml:"content"`
+	Categories []atomCategory `xml:"category"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomPerson  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+// blogFeedURL returns the URL of the Atom feed of username's blog.
+func blogFeedURL(username string) string {
+	return backend + "/blog/" + url.PathEscape(username) + "/feed.xml"
+}
+
+func blogFeedHandler(w http.ResponseWriter, r *http.Request) {
+	username := r.PathValue("username")
+
+	var idstr string
+	err := db.QueryRow(`
+		SELECT uuid FROM users
+		WHERE username = ? AND uuid NOT IN (SELECT uuid FROM portfolio_passwords);
+	`, username).Scan(&idstr)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !redirectRenamed(w, r, username, blogFeedURL) {
+			http.NotFound(w, r)
+		}
+		return
+	} else if err != nil {
+		log.Printf("error loading feed of %s: %v\n", username, err)
+		http.Error(w, "could not load feed", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.Parse(idstr)
+	if err != nil {
+		http.Error(w, "could not load feed", http.StatusInternalServerError)
+		return
+	}
+
+	p, err := loadPortfolio(id)
+	if err != nil {
+		log.Printf("error loading feed of %s: %v\n", username, err)
+		http.Error(w, "could not load feed", http.StatusInternalServerError)
+		return
+	}
+
+	posts, err := loadBlogPosts(true, `
+		SELECT `+blogPostColumns+` FROM blog_posts
+		WHERE user_uuid = ? AND `+isPublic+`
+		ORDER BY publish_date DESC
+		LIMIT ?;
+	`, id.String(), time.Now().UTC().Format(time.RFC3339), blogFeedSize)
+	if err != nil {
+		log.Printf("error loading feed of %s: %v\n", username, err)
+		http.Error(w, "could not load feed", http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(p.FirstName + " " + p.LastName)
+	if name == "" {
+		name = username
+	}
+	blogURL := frontend + "/blog/" + url.PathEscape(username)
+
+	feed := atomFeed{
+		ID:    "urn:uuid:" + id.String(),
+		Title: name + "'s blog",
+		Links: []atomLink{
+			{Href: blogFeedURL(username), Rel: "self", Type: "application/atom+xml"},
+			{Href: blogURL, Rel: "alternate", Type: "text/html"},
+		},
+		Author: atomPerson{Name: name, URI: portfolioURL(username)},
+	}
+
+	for _, post := range posts {
+		entry := atomEntry{
+			ID:        "urn:uuid:" + post.ID,
+			Title:     post.Title,
+			Links:     []atomLink{{Href: blogPostURL(username, post.Slug), Rel: "alternate", Type: "text/html"}},
+			Published: post.PublishDate,
+			Updated:   post.Updated,
+			Summary:   atomText{Type: "text", Body: post.Excerpt},
+			Content:   atomText{Type: "html", Body: string(renderMarkdown(post.Body))},
+		}
+		if post.CoverImageURL != "" {
+			entry.Links = append(entry.Links, atomLink{Href: post.CoverImageURL, Rel: "enclosure", Type: mime.TypeByExtension(path.Ext(post.CoverImageURL))})
+		}
+		for _, tag := range post.Tags {
+			entry.Categories = append(entry.Categories, atomCategory{Term: tag})
+		}
+		feed.Entries = append(feed.Entries, entry)
+
+		if post.Updated > feed.Updated {
+			feed.Updated = post.Updated
+		}
+	}
+	if feed.Updated == "" {
+		feed.Updated = time.Now().UTC().Format(time.RFC3339)
+	}
+
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(feed); err != nil {
+		log.Printf("error writing feed of %s: %v\n", username, err)
+	}
+}
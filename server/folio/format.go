@@ -0,0 +1,284 @@
+package folio
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pelletier/go-toml/v2/unstable"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is a text representation of a Portfolio that can be kept in version
+// control and edited by hand.
+type Format struct {
+	Name        string
+	Extension   string
+	ContentType string
+
+	encode func(p Portfolio) ([]byte, error)
+	decode func(data []byte) (Portfolio, []FieldError)
+	// lines maps field paths as used by FieldError to the line they are on.
+	lines func(data []byte) map[string]int
+}
+
+// Formats holds every Format by name.
+var Formats = map[string]*Format{
+	"yaml": {
+		Name:        "yaml",
+		Extension:   "yaml",
+		ContentType: "application/yaml",
+		encode:      encodeYAML,
+		decode:      decodeYAML,
+		lines:       yamlLines,
+	},
+	"toml": {
+		Name:        "toml",
+		Extension:   "toml",
+		ContentType: "application/toml",
+		encode:      encodeTOML,
+		decode:      decodeTOML,
+		lines:       tomlLines,
+	},
+}
+
+// FormatFor returns the format called name. "yml" is accepted for YAML.
+func FormatFor(name string) (*Format, error) {
+	if name == "yml" {
+		name = "yaml"
+	}
+
+	f, ok := Formats[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown portfolio format %q (expected yaml or toml)", name)
+	}
+	return f, nil
+}
+
+// FormatForFile returns the format of a file from its extension.
+func FormatForFile(path string) (*Format, error) {
+	return FormatFor(strings.TrimPrefix(filepath.Ext(path), "."))
+}
+
+// Encode returns the text representation of p.
+func (f *Format) Encode(p Portfolio) ([]byte, error) {
+	return f.encode(p)
+}
+
+// Decode parses and validates a portfolio file. Every problem found is
+// returned, each pointing at the line it was found on where possible.
+func (f *Format) Decode(data []byte) (Portfolio, []FieldError) {
+	p, errs := f.decode(data)
+	if errs != nil {
+		return Portfolio{}, errs
+	}
+
+	Normalize(&p)
+
+	errs = Validate(p)
+	if errs == nil {
+		return p, nil
+	}
+
+	lines := f.lines(data)
+	for i := range errs {
+		errs[i].Line = lookupLine(lines, errs[i].Field)
+	}
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	return Portfolio{}, errs
+}
+
+// lookupLine returns the line of field, or of its closest parent that has
+// one, since missing fields have no line of their own.
+func lookupLine(lines map[string]int, field string) int {
+	for field != "" {
+		if line, ok := lines[field]; ok {
+			return line
+		}
+
+		i := strings.LastIndexAny(field, ".[")
+		if i < 0 {
+			break
+		}
+		field = field[:i]
+	}
+	return 0
+}
+
+func encodeYAML(p Portfolio) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(p); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var yamlErrorLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+func decodeYAML(data []byte) (Portfolio, []FieldError) {
+	var p Portfolio
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err := dec.Decode(&p)
+	if err == nil || errors.Is(err, io.EOF) {
+		return p, nil
+	}
+
+	var messages []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{err.Error()}
+	}
+
+	errs := make([]FieldError, len(messages))
+	for i, msg := range messages {
+		errs[i].Message = msg
+		if m := yamlErrorLine.FindStringSubmatch(msg); m != nil {
+			errs[i].Line, _ = strconv.Atoi(m[1])
+			errs[i].Message = m[2]
+		}
+	}
+	return Portfolio{}, errs
+}
+
+func yamlLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return lines
+	}
+
+	var walk func(n *yaml.Node, path string)
+	walk = func(n *yaml.Node, path string) {
+		switch n.Kind {
+		case yaml.DocumentNode:
+			for _, c := range n.Content {
+				walk(c, path)
+			}
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				key, value := n.Content[i], n.Content[i+1]
+				p := key.Value
+				if path != "" {
+					p = path + "." + key.Value
+				}
+				lines[p] = key.Line
+				walk(value, p)
+			}
+		case yaml.SequenceNode:
+			for i, c := range n.Content {
+				p := fmt.Sprintf("%s[%d]", path, i)
+				lines[p] = c.Line
+				walk(c, p)
+			}
+		}
+	}
+	walk(&root, "")
+
+	return lines
+}
+
+func encodeTOML(p Portfolio) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := toml.NewEncoder(&buf)
+	enc.SetIndentTables(true)
+	if err := enc.Encode(p); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeTOML(data []byte) (Portfolio, []FieldError) {
+	var p Portfolio
+	dec := toml.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&p)
+	if err == nil {
+		return p, nil
+	}
+
+	var decodeErrs []toml.DecodeError
+	var strictErr *toml.StrictMissingError
+	var decodeErr *toml.DecodeError
+	switch {
+	case errors.As(err, &strictErr):
+		decodeErrs = strictErr.Errors
+	case errors.As(err, &decodeErr):
+		decodeErrs = []toml.DecodeError{*decodeErr}
+	default:
+		return Portfolio{}, []FieldError{{Message: err.Error()}}
+	}
+
+	errs := make([]FieldError, len(decodeErrs))
+	for i, e := range decodeErrs {
+		line, _ := e.Position()
+		errs[i] = FieldError{
+			Field:   strings.Join(e.Key(), "."),
+			Line:    line,
+			Message: e.Error(),
+		}
+	}
+	return Portfolio{}, errs
+}
+
+func tomlLines(data []byte) map[string]int {
+	lines := make(map[string]int)
+	arrays := make(map[string]int) // array of tables path -> number of elements
+
+	var p unstable.Parser
+	p.Reset(data)
+
+	// resolve turns a dotted key into a path, indexing into arrays of tables
+	// through their latest element the way TOML does.
+	resolve := func(base string, key unstable.Iterator, appendArray bool) (string, int) {
+		path, line := base, 0
+		for key.Next() {
+			n := key.Node()
+			if path == "" {
+				path = string(n.Data)
+			} else {
+				path += "." + string(n.Data)
+			}
+			line = p.Shape(n.Raw).Start.Line
+
+			if appendArray && key.IsLast() {
+				i := arrays[path]
+				arrays[path] = i + 1
+				path = fmt.Sprintf("%s[%d]", path, i)
+			} else if count, ok := arrays[path]; ok {
+				path = fmt.Sprintf("%s[%d]", path, count-1)
+			}
+		}
+		return path, line
+	}
+
+	table := ""
+	for p.NextExpression() {
+		e := p.Expression()
+		switch e.Kind {
+		case unstable.Table, unstable.ArrayTable:
+			var line int
+			table, line = resolve("", e.Key(), e.Kind == unstable.ArrayTable)
+			lines[table] = line
+		case unstable.KeyValue:
+			path, line := resolve(table, e.Key(), false)
+			lines[path] = line
+		}
+	}
+
+	return lines
+}
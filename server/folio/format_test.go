@@ -0,0 +1,167 @@
+package folio
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testPortfolio = Portfolio{
+	FirstName: "Ada",
+	LastName:  "Lovelace",
+	Location:  "London",
+	Bio:       "Wrote the first **program**.\n\nAlso: \"notes\" # not a comment",
+	Sections: []Section{
+		{Title: "Projects", Projects: []Project{
+			{Name: "Analytical Engine", Description: "Notes on the engine.\nWith a second line.", Link: "https://example.com/engine"},
+			{Name: "Bernoulli numbers", ImageURL: ImageBucketURL + "bernoulli.png"},
+		}},
+		{Title: "Empty", Projects: []Project{}},
+	},
+	SidebarColor:    "amber-400",
+	BackgroundColor: "slate-50",
+	ProjectColor:    "slate-100",
+	AccentColor:     "slate-200",
+	Font:            "serif",
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for name, f := range Formats {
+		t.Run(name, func(t *testing.T) {
+			data, err := f.Encode(testPortfolio)
+			if err != nil {
+				t.Fatalf("encoding: %v", err)
+			}
+			p, errs := f.Decode(data)
+			if errs != nil {
+				t.Fatalf("decoding %s: %v", data, errs)
+			}
+			if !reflect.DeepEqual(p, testPortfolio) {
+				t.Errorf("decoded %+v, want %+v", p, testPortfolio)
+			}
+		})
+	}
+}
+
+func TestFormatDecodeNormalizes(t *testing.T) {
+	for name, data := range map[string]string{
+		"yaml": "sidebarColor: amber-400\nbackgroundColor: slate-50\nprojectColor: slate-100\naccentColor: slate-200\nfont: sans\nsections:\n  - title: Work\n",
+		"toml": "sidebarColor = 'amber-400'\nbackgroundColor = 'slate-50'\nprojectColor = 'slate-100'\naccentColor = 'slate-200'\nfont = 'sans'\n[[sections]]\ntitle = 'Work'\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, errs := Formats[name].Decode([]byte(data))
+			if errs != nil {
+				t.Fatalf("decoding: %v", errs)
+			}
+			if p.Sections == nil || p.Sections[0].Projects == nil {
+				t.Errorf("decoded nil slices: %+v", p)
+			}
+		})
+	}
+}
+
+func TestFormatDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		format string
+		data   string
+		want   []FieldError
+	}{
+		{"yaml invalid fields", "yaml", `sidebarColor: amber-400
+backgroundColor: slate-50
+projectColor: not-a-color
+accentColor: slate-200
+font: comic
+sections:
+  - title: Work
+    projects:
+      - name: Engine
+        link: ftp://example.com
+`, []FieldError{
+			{Field: "projectColor", Line: 3},
+			{Field: "font", Line: 5},
+			{Field: "sections[0].projects[0].link", Line: 10},
+		}},
+		{"yaml missing field", "yaml", `sidebarColor: amber-400
+backgroundColor: slate-50
+projectColor: slate-100
+font: sans
+`, []FieldError{
+			{Field: "accentColor"},
+		}},
+		{"yaml unknown field", "yaml", `sidebarColour: amber-400
+`, []FieldError{
+			{Line: 1},
+		}},
+		{"toml invalid fields", "toml", `sidebarColor = 'amber-400'
+backgroundColor = 'slate-50'
+projectColor = 'not-a-color'
+accentColor = 'slate-200'
+font = 'comic'
+
+[[sections]]
+  title = 'Work'
+
+  [[sections.projects]]
+    name = 'Engine'
+
+  [[sections.projects]]
+    name = 'Notes'
+    link = 'ftp://example.com'
+`, []FieldError{
+			{Field: "projectColor", Line: 3},
+			{Field: "font", Line: 5},
+			{Field: "sections[0].projects[1].link", Line: 15},
+		}},
+		{"toml unknown field", "toml", `sidebarColour = 'amber-400'
+`, []FieldError{
+			{Field: "sidebarColour", Line: 1},
+		}},
+		{"toml syntax error", "toml", `font = 
+`, []FieldError{
+			{Line: 1},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, errs := Formats[test.format].Decode([]byte(test.data))
+			if len(errs) != len(test.want) {
+				t.Fatalf("got errors %v, want %d", errs, len(test.want))
+			}
+			for i, err := range errs {
+				if err.Field != test.want[i].Field || err.Line != test.want[i].Line {
+					t.Errorf("error %d is %q at line %d, want %q at line %d", i, err.Field, err.Line, test.want[i].Field, test.want[i].Line)
+				}
+				if err.Message == "" {
+					t.Errorf("error %d has no message", i)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatFor(t *testing.T) {
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"portfolio.yaml", "yaml"},
+		{"portfolio.yml", "yaml"},
+		{"dir/portfolio.toml", "toml"},
+		{"portfolio.json", ""},
+		{"portfolio", ""},
+	} {
+		f, err := FormatForFile(test.path)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("FormatForFile(%q) = %s, want an error", test.path, f.Name)
+			}
+		} else if err != nil || f.Name != test.want {
+			t.Errorf("FormatForFile(%q) = %v, %v, want %s", test.path, f, err, test.want)
+		}
+	}
+}
+
+func TestFieldErrorString(t *testing.T) {
+	err := FieldError{Field: "sections[0].title", Line: 4, Message: "must be at most 200 characters"}
+	if got, want := err.Error(), "line 4: sections[0].title: must be at most 200 characters"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
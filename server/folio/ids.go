@@ -0,0 +1,91 @@
+package folio
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"regexp"
+	"strconv"
+)
+
+// Every project has an ID that stays the same when it is renamed or moved to
+// another section, so that links to it keep working. The client makes IDs for
+// the projects it creates with NewProjectID's alphabet and length.
+
+const projectIDLength = 12
+
+var projectIDPattern = regexp.MustCompile(`^[a-z0-9]{6,32}$`)
+
+var projectIDEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// IsProjectID reports whether s can be a project's ID.
+func IsProjectID(s string) bool {
+	return projectIDPattern.MatchString(s)
+}
+
+// NewProjectID returns a random project ID.
+func NewProjectID() string {
+	b := make([]byte, projectIDLength)
+	rand.Read(b)
+	return projectIDEncoding.EncodeToString(b)[:projectIDLength]
+}
+
+// derivedProjectID returns the ID made for a project with slug in a portfolio
+// seeded with seed. Attempt is increased to get another ID if it is taken.
+func derivedProjectID(seed, slug string, attempt int) string {
+	sum := sha256.Sum256([]byte(seed + "\x00" + slug + "\x00" + strconv.Itoa(attempt)))
+	return projectIDEncoding.EncodeToString(sum[:])[:projectIDLength]
+}
+
+// AssignProjectIDs gives an ID to every project in p without a valid one, or
+// whose ID is already used by an earlier project. IDs are derived from seed
+// and each project's slug, so that giving IDs to the same projects twice, such
+// as in a draft and its published copy, gives them the same IDs. It reports
+// whether any ID was changed.
+func AssignProjectIDs(p *Portfolio, seed string) bool {
+	used := make(map[string]bool)
+	for _, section := range p.Sections {
+		for _, project := range section.Projects {
+			if IsProjectID(project.ID) {
+				used[project.ID] = true
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	slugs := ProjectSlugs(*p)
+	changed := false
+	for i := range p.Sections {
+		for j := range p.Sections[i].Projects {
+			project := &p.Sections[i].Projects[j]
+			if IsProjectID(project.ID) && !seen[project.ID] {
+				seen[project.ID] = true
+				continue
+			}
+
+			id := ""
+			for attempt := 0; id == "" || used[id]; attempt++ {
+				id = derivedProjectID(seed, slugs[i][j], attempt)
+			}
+			project.ID = id
+			used[id] = true
+			seen[id] = true
+			changed = true
+		}
+	}
+	return changed
+}
+
+// ReplaceProjectID changes the ID of the project with ID old in p to a new,
+// random one, and returns it.
+func ReplaceProjectID(p *Portfolio, old string) string {
+	id := NewProjectID()
+	for i := range p.Sections {
+		for j := range p.Sections[i].Projects {
+			if project := &p.Sections[i].Projects[j]; project.ID == old {
+				project.ID = id
+			}
+		}
+	}
+	return id
+}
@@ -0,0 +1,69 @@
+package folio
+
+import "testing"
+
+func projectIDs(p Portfolio) []string {
+	var ids []string
+	for _, section := range p.Sections {
+		for _, project := range section.Projects {
+			ids = append(ids, project.ID)
+		}
+	}
+	return ids
+}
+
+func TestAssignProjectIDs(t *testing.T) {
+	p := Portfolio{Sections: []Section{
+		{Projects: []Project{{Name: "Compiler"}, {ID: "keepthisid", Name: "Kept"}}},
+		{Projects: []Project{{ID: "keepthisid", Name: "Copy"}, {ID: "NOT VALID", Name: "Invalid"}}},
+	}}
+	if !AssignProjectIDs(&p, "seed") {
+		t.Fatal("AssignProjectIDs reported no change")
+	}
+
+	ids := projectIDs(p)
+	if ids[1] != "keepthisid" {
+		t.Errorf("valid ID changed to %q", ids[1])
+	}
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if !IsProjectID(id) {
+			t.Errorf("assigned invalid ID %q", id)
+		}
+		if seen[id] {
+			t.Errorf("ID %q assigned twice", id)
+		}
+		seen[id] = true
+	}
+
+	if AssignProjectIDs(&p, "seed") {
+		t.Error("AssignProjectIDs changed IDs that were all valid")
+	}
+}
+
+func TestAssignProjectIDsDeterministic(t *testing.T) {
+	draft := Portfolio{Sections: []Section{{Projects: []Project{{Name: "Compiler"}, {Name: "Website"}}}}}
+	published := Portfolio{Sections: []Section{{Projects: []Project{{Name: "Compiler"}, {Name: "Website"}}}}}
+	AssignProjectIDs(&draft, "seed")
+	AssignProjectIDs(&published, "seed")
+	if a, b := projectIDs(draft), projectIDs(published); a[0] != b[0] || a[1] != b[1] {
+		t.Errorf("same projects got IDs %q and %q", a, b)
+	}
+
+	other := Portfolio{Sections: []Section{{Projects: []Project{{Name: "Compiler"}}}}}
+	AssignProjectIDs(&other, "other seed")
+	if projectIDs(other)[0] == projectIDs(draft)[0] {
+		t.Error("different seeds gave the same ID")
+	}
+}
+
+func TestReplaceProjectID(t *testing.T) {
+	p := Portfolio{Sections: []Section{{Projects: []Project{{ID: "oldprojectid"}, {ID: "otherproject"}}}}}
+	id := ReplaceProjectID(&p, "oldprojectid")
+	if !IsProjectID(id) || id == "oldprojectid" {
+		t.Fatalf("ReplaceProjectID returned %q", id)
+	}
+	if ids := projectIDs(p); ids[0] != id || ids[1] != "otherproject" {
+		t.Errorf("IDs after replacing = %q", ids)
+	}
+}
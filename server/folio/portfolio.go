@@ -0,0 +1,59 @@
+// Package folio defines the portfolio types shared by the server and the
+// folio command, along with their validation and text file formats.
+package folio
+
+import "strings"
+
+type Portfolio struct {
+	FirstName string    `json:"firstName" yaml:"firstName" toml:"firstName"`
+	LastName  string    `json:"lastName" yaml:"lastName" toml:"lastName"`
+	Location  string    `json:"location" yaml:"location" toml:"location"`
+	Bio       string    `json:"bio" yaml:"bio" toml:"bio,multiline"`
+	Sections  []Section `json:"sections" yaml:"sections" toml:"sections"`
+
+	SidebarColor    string `json:"sidebarColor" yaml:"sidebarColor" toml:"sidebarColor"`
+	BackgroundColor string `json:"backgroundColor" yaml:"backgroundColor" toml:"backgroundColor"`
+	ProjectColor    string `json:"projectColor" yaml:"projectColor" toml:"projectColor"`
+	AccentColor     string `json:"accentColor" yaml:"accentColor" toml:"accentColor"`
+	Font            string `json:"font" yaml:"font" toml:"font"`
+}
+
+type Section struct {
+	Title    string    `json:"title" yaml:"title" toml:"title"`
+	Projects []Project `json:"projects" yaml:"projects" toml:"projects"`
+}
+
+type Project struct {
+	ID          string `json:"id,omitempty" yaml:"id,omitempty" toml:"id,omitempty"`
+	Name        string `json:"name" yaml:"name" toml:"name"`
+	Description string `json:"description" yaml:"description" toml:"description,multiline"`
+	ImageURL    string `json:"imageURL,omitempty" yaml:"imageURL,omitempty" toml:"imageURL,omitempty"`
+	Link        string `json:"link,omitempty" yaml:"link,omitempty" toml:"link,omitempty"`
+}
+
+// Fonts lists the values allowed in Portfolio.Font.
+var Fonts = []string{"sans", "serif", "mono"}
+
+// ImageBucketURL prefixes the URL of every image uploaded through
+// /api/upload_image.
+const ImageBucketURL = "https://foliopage-images.s3.amazonaws.com/"
+
+// ImageKey returns the storage key of an uploaded image's URL, or false if
+// the URL does not point at an uploaded image.
+func ImageKey(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, ImageBucketURL)
+	return key, ok && key != "" && !strings.Contains(key, "/")
+}
+
+// Normalize replaces nil slices in p with empty ones. Text formats have no
+// way to tell the two apart, but the client expects arrays, not null.
+func Normalize(p *Portfolio) {
+	if p.Sections == nil {
+		p.Sections = make([]Section, 0)
+	}
+	for i := range p.Sections {
+		if p.Sections[i].Projects == nil {
+			p.Sections[i].Projects = make([]Project, 0)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package folio
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a name into the form used in URLs: lowercase letters and
+// digits separated by single dashes. It returns "" if name has neither.
+func Slugify(name string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// ProjectSlugs returns the slug of every project in p, indexed like
+// p.Sections[i].Projects[j]. Slugs are made from project names, with a
+// number added to repeated ones in the order the projects appear.
+func ProjectSlugs(p Portfolio) [][]string {
+	seen := make(map[string]int)
+	slugs := make([][]string, len(p.Sections))
+	for i, section := range p.Sections {
+		slugs[i] = make([]string, len(section.Projects))
+		for j, project := range section.Projects {
+			slug := Slugify(project.Name)
+			if slug == "" {
+				slug = "project"
+			}
+			seen[slug]++
+			if n := seen[slug]; n > 1 {
+				slug = fmt.Sprintf("%s-%d", slug, n)
+			}
+			slugs[i][j] = slug
+		}
+	}
+	return slugs
+}
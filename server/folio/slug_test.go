@@ -0,0 +1,40 @@
+package folio
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	for _, test := range []struct {
+		name, want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Go  --  Rust  ", "go-rust"},
+		{"C++ & C#", "c-c"},
+		{"2024 Recap", "2024-recap"},
+		{"Café", "caf"},
+		{"日本語", ""},
+		{"", ""},
+	} {
+		if got := Slugify(test.name); got != test.want {
+			t.Errorf("Slugify(%q) = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProjectSlugs(t *testing.T) {
+	p := Portfolio{Sections: []Section{
+		{Projects: []Project{{Name: "Compiler"}, {Name: "compiler!"}, {Name: "???"}}},
+		{Projects: nil},
+		{Projects: []Project{{Name: "Compiler"}, {Name: ""}}},
+	}}
+	want := [][]string{
+		{"compiler", "compiler-2", "project"},
+		{},
+		{"compiler-3", "project-2"},
+	}
+	if got := ProjectSlugs(p); !reflect.DeepEqual(got, want) {
+		t.Errorf("ProjectSlugs = %q, want %q", got, want)
+	}
+}
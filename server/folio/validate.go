@@ -0,0 +1,97 @@
+package folio
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"unicode/utf8"
+)
+
+const (
+	maxNameLength        = 100
+	maxTitleLength       = 200
+	maxDescriptionLength = 10000
+)
+
+// FieldError is a problem with one field of a portfolio. Field is the path to
+// the field using the JSON names, like "sections[0].projects[2].link".
+type FieldError struct {
+	Field   string `json:"field"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) Error() string {
+	msg := e.Message
+	if e.Field != "" {
+		msg = e.Field + ": " + msg
+	}
+	if e.Line > 0 {
+		msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+	}
+	return msg
+}
+
+// Validate checks that p only contains values the client is able to render,
+// returning one error per invalid field.
+func Validate(p Portfolio) []FieldError {
+	var errs []FieldError
+	check := func(field string, ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+	checkLength := func(field, value string, max int) {
+		check(field, utf8.RuneCountInString(value) <= max, "must be at most %d characters", max)
+	}
+	checkColor := func(field, value string) {
+		if value == "" {
+			check(field, false, "is required")
+			return
+		}
+		_, ok := TailwindColor(value)
+		check(field, ok, "%q is not a color like \"slate-100\"", value)
+	}
+
+	checkLength("firstName", p.FirstName, maxNameLength)
+	checkLength("lastName", p.LastName, maxNameLength)
+	checkLength("location", p.Location, maxNameLength)
+	checkLength("bio", p.Bio, maxDescriptionLength)
+
+	checkColor("sidebarColor", p.SidebarColor)
+	checkColor("backgroundColor", p.BackgroundColor)
+	checkColor("projectColor", p.ProjectColor)
+	checkColor("accentColor", p.AccentColor)
+
+	check("font", slices.Contains(Fonts, p.Font), "%q is not one of \"sans\", \"serif\" or \"mono\"", p.Font)
+
+	for i, section := range p.Sections {
+		sp := fmt.Sprintf("sections[%d]", i)
+		checkLength(sp+".title", section.Title, maxTitleLength)
+
+		for j, project := range section.Projects {
+			pp := fmt.Sprintf("%s.projects[%d]", sp, j)
+			if project.ID != "" {
+				check(pp+".id", IsProjectID(project.ID), "must be 6 to 32 lowercase letters and digits")
+			}
+			checkLength(pp+".name", project.Name, maxTitleLength)
+			checkLength(pp+".description", project.Description, maxDescriptionLength)
+
+			if project.Link != "" {
+				check(pp+".link", IsWebURL(project.Link), "must be an http or https URL")
+			}
+			if project.ImageURL != "" {
+				_, ok := ImageKey(project.ImageURL)
+				check(pp+".imageURL", ok, "must be an image uploaded through /api/upload_image")
+			}
+		}
+	}
+
+	return errs
+}
+
+// IsWebURL reports whether s is an absolute http or https URL.
+func IsWebURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
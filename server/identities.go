@@ -0,0 +1,354 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/sso"
+)
+
+// Users log in through identities: accounts with a provider, known by the
+// provider's name and the subject it gives the account, which unlike the
+// account's email never changes. A user can link any number of identities
+// from their settings and log in through any of them.
+//
+// Accounts made before identities were recorded all signed up with Google.
+// They are found by their email the first time they log in with Google, and
+// linked to the identity they logged in with. Emails are only trusted when
+// Google has verified them, and are never used to link an identity to a user
+// who already has one, or one from another provider; that must be done from
+// the user's settings. Logging in by email is the exception: it finds the
+// user whose identities last had the address as a verified email, since a
+// link sent there proves it is still theirs. The email a user signed up with
+// is never used for this, since it is not kept up to date and may since have
+// been given to someone else.
+
+// legacyProvider is the provider every account made before identities were
+// recorded logged in with.
+const legacyProvider = "google"
+
+// execer runs statements, in a transaction or not.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// linkIdentity records that the user with UUID id logs in through identity.
+func linkIdentity(e execer, id string, identity sso.Identity) error {
+	now := time.Now().Format(time.RFC3339)
+	_, err := e.Exec(`
+		INSERT INTO identities (provider, subject, user_uuid, email, email_verified, linked, last_used)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`, identity.Provider, identity.Subject, id, identity.Email, identity.EmailVerified, now, now)
+	return err
+}
+
+// identityUser returns the UUID and username of the user who logs in through
+// identity, and false if there is none.
+func identityUser(identity sso.Identity) (string, string, bool, error) {
+	var id, username string
+	err := db.QueryRow(`
+		SELECT users.uuid, users.username
+		FROM identities JOIN users ON users.uuid = identities.user_uuid
+		WHERE identities.provider = ? AND identities.subject = ?;
+	`, identity.Provider, identity.Subject).Scan(&id, &username)
+	if err == nil {
+		if _, err := db.Exec(`
+			UPDATE identities SET email = ?, email_verified = ?, last_used = ? WHERE provider = ? AND subject = ?;
+		`, identity.Email, identity.EmailVerified, time.Now().Format(time.RFC3339), identity.Provider, identity.Subject); err != nil {
+			log.Printf("error updating identity %s/%s: %v\n", identity.Provider, identity.Subject, err)
+		}
+		return id, username, true, nil
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return "", "", false, err
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return "", "", false, nil
+	}
+
+	var query string
+	switch identity.Provider {
+	case legacyProvider:
+		query = `SELECT uuid, username FROM users WHERE email = ? AND uuid NOT IN (SELECT user_uuid FROM identities);`
+	case emailProvider:
+		query = `
+			SELECT users.uuid, users.username
+			FROM identities JOIN users ON users.uuid = identities.user_uuid
+			WHERE identities.email = ? COLLATE NOCASE AND identities.email_verified
+			ORDER BY identities.last_used DESC
+			LIMIT 1;
+		`
+	default:
+		return "", "", false, nil
+	}
+	err = db.QueryRow(query, identity.Email).Scan(&id, &username)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", false, nil
+	} else if err != nil {
+		return "", "", false, err
+	}
+
+	if err := linkIdentity(db, id, identity); err != nil {
+		return "", "", false, err
+	}
+	log.Printf("linked %s identity %s to existing user %s by email\n", identity.Provider, identity.Subject, username)
+	return id, username, true, nil
+}
+
+// emailHasAccount reports whether a user logs in through an identity whose
+// provider verified email, or signed up with it before identities were
+// recorded.
+func emailHasAccount(email string) (bool, error) {
+	var taken bool
+	err := db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM identities WHERE email = ? COLLATE NOCASE AND email_verified)
+			OR EXISTS(SELECT 1 FROM users WHERE email = ? COLLATE NOCASE AND uuid NOT IN (SELECT user_uuid FROM identities));
+	`, email, email).Scan(&taken)
+	return taken, err
+}
+
+// accountEmail returns the address to send the user with UUID id mail about
+// their account: the verified email of the identity they used most recently.
+// Users who have not logged in since identities were recorded only have the
+// email they signed up with.
+func accountEmail(id string) (string, error) {
+	var email string
+	err := db.QueryRow(`
+		SELECT email FROM identities
+		WHERE user_uuid = ? AND email_verified AND email != ''
+		ORDER BY last_used DESC
+		LIMIT 1;
+	`, id).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = db.QueryRow(`
+			SELECT email FROM users WHERE uuid = ? AND uuid NOT IN (SELECT user_uuid FROM identities);
+		`, id).Scan(&email)
+	}
+	return email, err
+}
+
+// allowSharedUserEmails drops the UNIQUE constraint users.email had before
+// identities. The email is only a record of what a user signed up with, so
+// someone may sign up with an address another user signed up with but has
+// since stopped using.
+func allowSharedUserEmails() error {
+	var schema string
+	if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'users';`).Scan(&schema); err != nil {
+		return err
+	}
+	const unique = "email TEXT NOT NULL UNIQUE"
+	if !strings.Contains(schema, unique) {
+		return nil
+	}
+
+	// SQLite cannot drop constraints, so the table is made again without it.
+	schema = strings.Replace(schema, unique, "email TEXT NOT NULL", 1)
+	schema = strings.Replace(schema, "CREATE TABLE users", "CREATE TABLE users_new", 1)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{
+		schema,
+		`INSERT INTO users_new SELECT * FROM users;`,
+		`DROP TABLE users;`,
+		`ALTER TABLE users_new RENAME TO users;`,
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// oauthLinkHandler links an identity with the provider in the path to the
+// logged in user, going back to the frontend path given by the return_to
+// query parameter afterwards, or the editor if there is none.
+func oauthLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if writeHeaders(w, r, "GET") {
+		return
+	}
+
+	provider, ok := requestedProvider(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := getLogin(r, scopeSession)
+	if err != nil {
+		http.Error(w, err.Error(), loginErrorStatus(err))
+		return
+	}
+
+	beginOAuth(w, r, provider, oauthAttempt{
+		LinkTo:   id.String(),
+		ReturnTo: safeReturnPath(r.URL.Query().Get("return_to"), "/editor"),
+	})
+}
+
+// finishLinking links identity to the user attempt was started by, if they
+// are still logged in.
+func finishLinking(w http.ResponseWriter, r *http.Request, attempt oauthAttempt, identity sso.Identity) {
+	id, err := getLogin(r, scopeSession)
+	if err != nil || id.String() != attempt.LinkTo {
+		http.Error(w, "you were logged out while linking your account", http.StatusUnauthorized)
+		return
+	}
+
+	var owner string
+	err = db.QueryRow(`
+		SELECT user_uuid FROM identities WHERE provider = ? AND subject = ?;
+	`, identity.Provider, identity.Subject).Scan(&owner)
+	if err == nil && owner != attempt.LinkTo {
+		http.Error(w, "this account is already linked to another user", http.StatusConflict)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		if err := linkIdentity(db, attempt.LinkTo, identity); err != nil {
+			log.Printf("error linking %s identity to %s: %v\n", identity.Provider, attempt.LinkTo, err)
+			http.Error(w, "could not link account", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("linked %s identity %s to user %s\n", identity.Provider, identity.Subject, attempt.LinkTo)
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, frontend+attempt.ReturnTo, http.StatusTemporaryRedirect)
+}
+
+type identityInfo struct {
+	Provider string `json:"provider"`
+	Title    string `json:"title"`
+	Subject  string `json:"subject"`
+	Email    string `json:"email"`
+	Linked   string `json:"linked"`
+	LastUsed string `json:"lastUsed,omitempty"`
+}
+
+// listIdentitiesHandler lists the identities the logged in user can log in
+// through.
+func listIdentitiesHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT provider, subject, email, linked, last_used
+		FROM identities
+		WHERE user_uuid = ?
+		ORDER BY linked;
+	`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := make([]identityInfo, 0)
+	for rows.Next() {
+		var i identityInfo
+		var email, lastUsed sql.NullString
+		if err := rows.Scan(&i.Provider, &i.Subject, &email, &i.Linked, &lastUsed); err != nil {
+			return nil, err
+		}
+		i.Email = email.String
+		i.LastUsed = lastUsed.String
+
+		// Providers that are no longer set up are shown by name.
+		i.Title = i.Provider
+		if p, ok := authProviders.Get(i.Provider); ok {
+			i.Title = p.Title
+		} else if i.Provider == emailProvider {
+			i.Title = emailTitle
+		}
+		identities = append(identities, i)
+	}
+
+	return identities, rows.Err()
+}
+
+// unlinkIdentityHandler stops the logged in user from logging in through an
+// identity. Their last identity cannot be unlinked, or they could not log in
+// again.
+func unlinkIdentityHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		Provider string `json:"provider"`
+		Subject  string `json:"subject"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM identities WHERE user_uuid = ?;`, id.String()).Scan(&count); err != nil {
+		return nil, err
+	}
+
+	result, err := tx.Exec(`
+		DELETE FROM identities WHERE provider = ? AND subject = ? AND user_uuid = ?;
+	`, strings.TrimSpace(req.Provider), strings.TrimSpace(req.Subject), id.String())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+	if count <= 1 {
+		return nil, apis.NewError("you cannot unlink the only account you log in with", http.StatusConflict)
+	}
+
+	return nil, tx.Commit()
+}
+
+// createUser creates a user named username who logs in through identity, and
+// returns their UUID.
+func createUser(r *http.Request, username string, identity sso.Identity) (uuid.UUID, error) {
+	portfolio, err := json.Marshal(defaultPortfolio)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	now := time.Now().Format(time.RFC3339)
+	id := uuid.New()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`
+		INSERT INTO users (uuid, email, username, signup_time, signup_ip, signup_agent, portfolio, last_saved)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+	`, id.String(), identity.Email, username, now, remoteIP(r), r.UserAgent(), portfolio, now); err != nil {
+		return uuid.Nil, err
+	}
+	if err := linkIdentity(tx, id.String(), identity); err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, tx.Commit()
+}
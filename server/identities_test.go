@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/sso"
+	"nmilo.ca/portfolio/sso/ssotest"
+)
+
+// identityOwner returns the UUID of the user who logs in through the test
+// issuer's account with subject, or "" if nobody does.
+func identityOwner(t *testing.T, subject string) string {
+	t.Helper()
+	var owner string
+	if err := db.QueryRow(`
+		SELECT user_uuid FROM identities WHERE provider = 'test' AND subject = ?;
+	`, subject).Scan(&owner); err != nil {
+		return ""
+	}
+	return owner
+}
+
+func TestLinkIdentity(t *testing.T) {
+	s := newTestServer(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	s.logIn(t, c, "ada")
+	s.issuer.LogInAs(testUser("ada-work"))
+	res := get(t, c, s.authorize(t, c, "/auth/test/link?return_to=/settings"))
+	wantRedirect(t, res, http.StatusTemporaryRedirect, frontend+"/settings")
+	if owner := identityOwner(t, "ada-work"); owner != ada {
+		t.Errorf("linked identity belongs to %q, want %q", owner, ada)
+	}
+
+	// The new identity logs in as the same user.
+	other := newBrowser(t)
+	s.logIn(t, other, "ada-work")
+	if !s.loggedIn(t, other) {
+		t.Error("could not log in through the linked identity")
+	}
+}
+
+func TestLinkIdentityConflict(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+
+	c := newBrowser(t)
+	s.logIn(t, c, "ada")
+	s.issuer.LogInAs(testUser("grace"))
+	res := get(t, c, s.authorize(t, c, "/auth/test/link"))
+	wantStatus(t, res, http.StatusConflict)
+	if owner := identityOwner(t, "grace"); owner != grace {
+		t.Errorf("identity belongs to %q after a conflicting link, want %q", owner, grace)
+	}
+}
+
+func TestLinkIdentityLoggedOut(t *testing.T) {
+	s := newTestServer(t)
+
+	res := get(t, newBrowser(t), s.URL+"/auth/test/link")
+	wantStatus(t, res, http.StatusUnauthorized)
+}
+
+func TestUnlinkIdentity(t *testing.T) {
+	s := newTestServer(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	if err := linkIdentity(db, ada, testIdentity("ada-work")); err != nil {
+		t.Fatalf("linking identity: %v", err)
+	}
+
+	c := newBrowser(t)
+	s.logIn(t, c, "ada")
+	res := post(t, c, s.URL+"/api/unlink_identity", "application/json", `{"provider": "test", "subject": "ada-work"}`)
+	wantStatus(t, res, http.StatusOK)
+	if owner := identityOwner(t, "ada-work"); owner != "" {
+		t.Errorf("unlinked identity still belongs to %q", owner)
+	}
+}
+
+func TestUnlinkLastIdentity(t *testing.T) {
+	s := newTestServer(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	s.logIn(t, c, "ada")
+	res := post(t, c, s.URL+"/api/unlink_identity", "application/json", `{"provider": "test", "subject": "ada"}`)
+	wantStatus(t, res, http.StatusConflict)
+	if owner := identityOwner(t, "ada"); owner != ada {
+		t.Errorf("last identity belongs to %q after unlinking it was refused, want %q", owner, ada)
+	}
+}
+
+func TestUnlinkOtherUsersIdentity(t *testing.T) {
+	s := newTestServer(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	if err := linkIdentity(db, ada, testIdentity("ada-work")); err != nil {
+		t.Fatalf("linking identity: %v", err)
+	}
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+
+	c := newBrowser(t)
+	s.logIn(t, c, "ada")
+	res := post(t, c, s.URL+"/api/unlink_identity", "application/json", `{"provider": "test", "subject": "grace"}`)
+	wantStatus(t, res, http.StatusNotFound)
+	if owner := identityOwner(t, "grace"); owner != grace {
+		t.Errorf("identity belongs to %q after another user unlinked it, want %q", owner, grace)
+	}
+}
+
+func TestIdentityUserIgnoresSignupEmail(t *testing.T) {
+	newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+	if _, err := db.Exec(`UPDATE users SET email = 'old@example.com';`); err != nil {
+		t.Fatalf("changing signup email: %v", err)
+	}
+
+	// Logging in by email finds users through the emails their identities
+	// last had, not the one they signed up with.
+	old := sso.Identity{Provider: emailProvider, Subject: "old@example.com", Email: "old@example.com", EmailVerified: true}
+	if _, _, ok, err := identityUser(old); err != nil {
+		t.Fatalf("identityUser: %v", err)
+	} else if ok {
+		t.Error("identityUser found a user by the email they signed up with")
+	}
+
+	current := sso.Identity{Provider: emailProvider, Subject: "ada@example.com", Email: "ada@example.com", EmailVerified: true}
+	if _, username, ok, err := identityUser(current); err != nil {
+		t.Fatalf("identityUser: %v", err)
+	} else if !ok || username != "ada" {
+		t.Errorf("identityUser = %q, %v, want the user whose identity has the email", username, ok)
+	}
+}
+
+func TestIdentityUserLegacyEmail(t *testing.T) {
+	newTestServer(t)
+	legacy := createTestUser(t, "ada", testIdentity("ada"))
+	createTestUser(t, "grace", testIdentity("grace"))
+	if _, err := db.Exec(`DELETE FROM identities WHERE user_uuid = ?;`, legacy); err != nil {
+		t.Fatalf("deleting identities: %v", err)
+	}
+
+	// Users from before identities were recorded are found by their email
+	// the first time they log in with Google, if Google verified it.
+	unverified := sso.Identity{Provider: legacyProvider, Subject: "1", Email: "ada@example.com"}
+	if _, _, ok, err := identityUser(unverified); err != nil {
+		t.Fatalf("identityUser: %v", err)
+	} else if ok {
+		t.Error("identityUser found a user by an unverified email")
+	}
+
+	google := sso.Identity{Provider: legacyProvider, Subject: "1", Email: "ada@example.com", EmailVerified: true}
+	if id, _, ok, err := identityUser(google); err != nil {
+		t.Fatalf("identityUser: %v", err)
+	} else if !ok || id != legacy {
+		t.Errorf("identityUser = %q, %v, want the legacy user %q", id, ok, legacy)
+	}
+
+	// Users who already have identities are not.
+	grace := sso.Identity{Provider: legacyProvider, Subject: "2", Email: "grace@example.com", EmailVerified: true}
+	if _, _, ok, err := identityUser(grace); err != nil {
+		t.Fatalf("identityUser: %v", err)
+	} else if ok {
+		t.Error("identityUser found a user with identities by email")
+	}
+}
+
+func TestSignupEmailHasAccount(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	// Someone who signed up through one provider signing up again through
+	// another is told to link it instead.
+	c := newBrowser(t)
+	s.issuer.LogInAs(ssotest.User{Subject: "other", Email: "ADA@example.com", EmailVerified: true})
+	res := get(t, c, s.authorize(t, c, "/auth/test/signup?username=ada2"))
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/signup?error=email_taken")
+	if avail, err := isUsernameAvailable(db, "ada2", uuid.Nil); err != nil || !avail {
+		t.Error("signed up with an email another user logs in with")
+	}
+}
+
+func TestSignupStaleSignupEmail(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+	if _, err := db.Exec(`UPDATE identities SET email = 'ada@work.example';`); err != nil {
+		t.Fatalf("changing identity email: %v", err)
+	}
+
+	// ada@example.com is only the email ada signed up with, which they no
+	// longer use, so someone else can sign up with it.
+	c := newBrowser(t)
+	s.issuer.LogInAs(ssotest.User{Subject: "other", Email: "ada@example.com", EmailVerified: true})
+	res := get(t, c, s.authorize(t, c, "/auth/test/signup?username=grace"))
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/editor")
+	if !s.loggedIn(t, c) {
+		t.Error("not logged in after signing up")
+	}
+}
+
+func TestAccountEmail(t *testing.T) {
+	newTestServer(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	work := testIdentity("ada-work")
+	if err := linkIdentity(db, ada, work); err != nil {
+		t.Fatalf("linking identity: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE identities SET last_used = '2000-01-01T00:00:00Z' WHERE subject = 'ada';`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE users SET email = 'old@example.com';`); err != nil {
+		t.Fatal(err)
+	}
+
+	if email, err := accountEmail(ada); err != nil {
+		t.Fatalf("accountEmail: %v", err)
+	} else if email != work.Email {
+		t.Errorf("accountEmail = %q, want the email of the identity used last, %q", email, work.Email)
+	}
+
+	// Users from before identities only have the email they signed up with.
+	if _, err := db.Exec(`DELETE FROM identities;`); err != nil {
+		t.Fatal(err)
+	}
+	if email, err := accountEmail(ada); err != nil {
+		t.Fatalf("accountEmail: %v", err)
+	} else if email != "old@example.com" {
+		t.Errorf("accountEmail = %q for a user without identities, want their signup email", email)
+	}
+}
+
+func TestAllowSharedUserEmails(t *testing.T) {
+	var err error
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+
+	// users as it was made before identities.
+	Must(db.Exec(`
+		CREATE TABLE users (
+			uuid TEXT PRIMARY KEY,
+			email TEXT NOT NULL UNIQUE,
+			username TEXT NOT NULL UNIQUE,
+			signup_time TEXT,
+			signup_ip TEXT,
+			signup_agent TEXT,
+			portfolio TEXT NOT NULL,
+			last_saved TEXT NOT NULL
+		);
+	`))
+	Must(db.Exec(`INSERT INTO users (uuid, email, username, portfolio, last_saved) VALUES ('1', 'ada@example.com', 'ada', '{}', '');`))
+
+	for i := 0; i < 2; i++ {
+		if err := allowSharedUserEmails(); err != nil {
+			t.Fatalf("allowSharedUserEmails: %v", err)
+		}
+	}
+
+	if _, err := db.Exec(`INSERT INTO users (uuid, email, username, portfolio, last_saved) VALUES ('2', 'ada@example.com', 'grace', '{}', '');`); err != nil {
+		t.Errorf("could not sign up with an email another user signed up with: %v", err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (uuid, email, username, portfolio, last_saved) VALUES ('3', 'x@example.com', 'ada', '{}', '');`); err == nil {
+		t.Error("usernames are no longer unique")
+	}
+	var username string
+	if err := db.QueryRow(`SELECT username FROM users WHERE uuid = '1';`).Scan(&username); err != nil || username != "ada" {
+		t.Errorf("existing user was not kept: %q, %v", username, err)
+	}
+}
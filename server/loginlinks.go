@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/sso"
+)
+
+// Users without an account at any provider log in with links emailed to
+// them. Each link holds a random token, stored only as its hash, that works
+// once and for loginLinkLifetime. The link opens a page on the frontend that
+// posts the token back, rather than logging in when the link is opened, so
+// mail scanners that follow links do not use it up. Links are bound to the
+// session that asked for them by a nonce, and need confirming when used from
+// any other.
+//
+// Logging in by email makes an identity with the "email" provider whose
+// subject is the address, so it is linked and unlinked like any other.
+
+const (
+	emailProvider     = "email"
+	emailTitle        = "Email"
+	loginLinkPrefix   = "fsl_"
+	loginLinkLifetime = 15 * time.Minute
+
+	loginLinkNonceKey   = "login_link_nonce"
+	loginLinkConfirmKey = "login_link_confirm"
+)
+
+// loginLinkIPLimiter limits how many links one address can ask for, and
+// loginLinkEmailLimiter how many one inbox can be sent.
+var (
+	loginLinkIPLimiter    = newRateLimiter(10, time.Hour)
+	loginLinkEmailLimiter = newRateLimiter(3, loginLinkLifetime)
+)
+
+// requestLoginLinkHandler emails a login link to the address in the request.
+// If a username is given, following the link signs up under it. It succeeds
+// whether or not the address has an account, so it cannot be used to find out
+// who does.
+func requestLoginLinkHandler(r *http.Request) (any, error) {
+	var req struct {
+		Email    string `json:"email"`
+		Username string `json:"username"`
+		ReturnTo string `json:"returnTo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	addr, err := mail.ParseAddress(req.Email)
+	if err != nil || addr.Name != "" {
+		return nil, apis.NewError("email must be a valid email address", http.StatusBadRequest)
+	}
+	email := strings.ToLower(addr.Address)
+
+	if req.Username != "" {
+		avail, err := isUsernameAvailable(db, req.Username, uuid.Nil)
+		if err != nil {
+			return nil, err
+		}
+		if !avail {
+			return nil, apis.NewError("username is not available", http.StatusConflict)
+		}
+	}
+
+	ip := remoteIP(r)
+	if !loginLinkIPLimiter.Allow(ip) || !loginLinkEmailLimiter.Allow(email) {
+		return nil, apis.NewError("too many login links, try again later", http.StatusTooManyRequests)
+	}
+
+	now := time.Now()
+	if _, err := db.Exec(`DELETE FROM login_links WHERE expires < ?;`, now.Format(time.RFC3339)); err != nil {
+		log.Printf("error deleting expired login links: %v\n", err)
+	}
+
+	// Every link asked for from one session shares its nonce, so that any of
+	// them can be used from it without confirming.
+	nonce := sessionManager.GetString(r.Context(), loginLinkNonceKey)
+	if nonce == "" {
+		nonce = newRandomToken("")
+		sessionManager.Put(r.Context(), loginLinkNonceKey, nonce)
+	}
+
+	token := newRandomToken(loginLinkPrefix)
+	if _, err := db.Exec(`
+		INSERT INTO login_links (token_hash, email, username, return_to, nonce_hash, created, expires)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`, hashToken(token), email, req.Username, safeReturnPath(req.ReturnTo, "/editor"), hashToken(nonce),
+		now.Format(time.RFC3339), now.Add(loginLinkLifetime).Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+
+	sendEmail(email, "login", struct {
+		URL      string
+		Minutes  int
+		Username string
+	}{frontend + "/login/email?token=" + url.QueryEscape(token), int(loginLinkLifetime.Minutes()), req.Username})
+
+	return nil, nil
+}
+
+var errInvalidLoginLink = errors.New("invalid login link")
+
+// loginLink is a login link that has not been used or expired.
+type loginLink struct {
+	Email    string
+	Username string
+	ReturnTo string
+	// NonceHash is the hash of the nonce of the session that asked for the
+	// link.
+	NonceHash string
+}
+
+// findLoginLink returns the link with token, if it can still be used.
+func findLoginLink(token string) (loginLink, error) {
+	var link loginLink
+	err := db.QueryRow(`
+		SELECT email, username, return_to, nonce_hash FROM login_links
+		WHERE token_hash = ? AND used IS NULL AND expires > ?;
+	`, hashToken(token), time.Now().Format(time.RFC3339)).Scan(&link.Email, &link.Username, &link.ReturnTo, &link.NonceHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return loginLink{}, errInvalidLoginLink
+	}
+	return link, err
+}
+
+// useLoginLink marks the link with token used, failing if it already was or
+// has expired.
+func useLoginLink(token string) error {
+	result, err := db.Exec(`
+		UPDATE login_links SET used = ? WHERE token_hash = ? AND used IS NULL AND expires > ?;
+	`, time.Now().Format(time.RFC3339), hashToken(token), time.Now().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errInvalidLoginLink
+	}
+	return nil
+}
+
+// sessionValueMatches reports whether the session value under key hashes to
+// hash.
+func sessionValueMatches(r *http.Request, key, hash string) bool {
+	value := sessionManager.GetString(r.Context(), key)
+	return value != "" && subtle.ConstantTimeCompare([]byte(hashToken(value)), []byte(hash)) == 1
+}
+
+// emailLoginHandler logs in with the token posted from a login link, the same
+// way as coming back from a provider. Links posted from a browser other than
+// the one that asked for them are only used once the user confirms on the
+// frontend, so nobody can log someone else into their own account by making
+// their browser post a link they asked for.
+func emailLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if writeHeaders(w, r, "POST") {
+		return
+	}
+
+	token := r.PostFormValue("token")
+	link, err := findLoginLink(token)
+	if errors.Is(err, errInvalidLoginLink) {
+		http.Redirect(w, r, frontend+"/login/email?expired=true", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	confirmed := false
+	if confirm := r.PostFormValue("confirm"); confirm != "" {
+		confirmed = sessionValueMatches(r, loginLinkConfirmKey, hashToken(confirm))
+		sessionManager.Remove(r.Context(), loginLinkConfirmKey)
+	}
+	if !confirmed && !sessionValueMatches(r, loginLinkNonceKey, link.NonceHash) {
+		confirm := newRandomToken("")
+		sessionManager.Put(r.Context(), loginLinkConfirmKey, confirm)
+		query := url.Values{"token": {token}, "confirm": {confirm}, "email": {link.Email}}
+		http.Redirect(w, r, frontend+"/login/email?"+query.Encode(), http.StatusSeeOther)
+		return
+	}
+
+	if err := useLoginLink(token); errors.Is(err, errInvalidLoginLink) {
+		http.Redirect(w, r, frontend+"/login/email?expired=true", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The address is the subject, so a user logging in by email is found
+	// again however its case was typed.
+	identity := sso.Identity{Provider: emailProvider, Subject: link.Email, Email: link.Email, EmailVerified: true}
+	logIn(w, r, emailTitle, identity, link.Username, link.ReturnTo)
+}
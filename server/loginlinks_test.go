@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var loginLinkPattern = regexp.MustCompile(`/login/email\?token=(\S+)`)
+
+// requestLoginLink asks for a login link to email from c, and returns the
+// token in the email sent.
+func (s *testServer) requestLoginLink(t *testing.T, c *http.Client, email string) string {
+	t.Helper()
+	res := post(t, c, s.URL+"/api/request_login_link", "application/json", `{"email": "`+email+`"}`)
+	wantStatus(t, res, http.StatusOK)
+
+	var text string
+	if err := db.QueryRow(`
+		SELECT text FROM mail_queue WHERE recipient = ? ORDER BY queued DESC, rowid DESC LIMIT 1;
+	`, email).Scan(&text); err != nil {
+		t.Fatalf("finding login email: %v", err)
+	}
+	match := loginLinkPattern.FindStringSubmatch(text)
+	if match == nil {
+		t.Fatalf("no login link in email:\n%s", text)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("unescaping token: %v", err)
+	}
+	return token
+}
+
+// useLoginLinkToken posts token from c like the page the link opens does.
+func (s *testServer) useLoginLinkToken(t *testing.T, c *http.Client, form url.Values) *http.Response {
+	t.Helper()
+	return post(t, c, s.URL+"/auth/email/login", "application/x-www-form-urlencoded", form.Encode())
+}
+
+func TestEmailLogin(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	token := s.requestLoginLink(t, c, "ada@example.com")
+	res := s.useLoginLinkToken(t, c, url.Values{"token": {token}})
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/editor")
+	if !s.loggedIn(t, c) {
+		t.Error("not logged in after following a login link")
+	}
+}
+
+func TestEmailLoginUsedTwice(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	token := s.requestLoginLink(t, c, "ada@example.com")
+	wantRedirect(t, s.useLoginLinkToken(t, c, url.Values{"token": {token}}), http.StatusSeeOther, frontend+"/editor")
+
+	other := newBrowser(t)
+	res := s.useLoginLinkToken(t, other, url.Values{"token": {token}})
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/login/email?expired=true")
+	if s.loggedIn(t, other) {
+		t.Error("logged in with a login link that was already used")
+	}
+}
+
+func TestEmailLoginExpired(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	token := s.requestLoginLink(t, c, "ada@example.com")
+	if _, err := db.Exec(`UPDATE login_links SET expires = '2000-01-01T00:00:00Z';`); err != nil {
+		t.Fatalf("expiring login link: %v", err)
+	}
+
+	res := s.useLoginLinkToken(t, c, url.Values{"token": {token}})
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/login/email?expired=true")
+	if s.loggedIn(t, c) {
+		t.Error("logged in with an expired login link")
+	}
+}
+
+func TestEmailLoginOtherBrowser(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	token := s.requestLoginLink(t, newBrowser(t), "ada@example.com")
+
+	// Posting the link from another browser asks to confirm first.
+	other := newBrowser(t)
+	res := s.useLoginLinkToken(t, other, url.Values{"token": {token}})
+	wantStatus(t, res, http.StatusSeeOther)
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), frontend+"/login/email?") {
+		t.Fatalf("redirected to %q, want the confirmation page", res.Header.Get("Location"))
+	}
+	if s.loggedIn(t, other) {
+		t.Fatal("logged in from another browser without confirming")
+	}
+
+	// A confirmation from any other session is not accepted.
+	confirm := location.Query().Get("confirm")
+	res = s.useLoginLinkToken(t, newBrowser(t), url.Values{"token": {token}, "confirm": {confirm}})
+	if !strings.HasPrefix(res.Header.Get("Location"), frontend+"/login/email?") {
+		t.Errorf("confirming from a third browser redirected to %q, want the confirmation page", res.Header.Get("Location"))
+	}
+
+	res = s.useLoginLinkToken(t, other, url.Values{"token": {token}, "confirm": {confirm}})
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/editor")
+	if !s.loggedIn(t, other) {
+		t.Error("not logged in after confirming")
+	}
+}
@@ -0,0 +1,106 @@
+// Package mail sends email. Messages are rendered from templates, queued in
+// SQLite so they survive restarts and are retried when sending fails, and
+// handed to a Transport, which delivers them over SMTP or writes them to a log
+// during development.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Message is an email to one recipient, with a plain text body and an HTML
+// alternative.
+type Message struct {
+	To string
+	// ReplyTo is where replies go instead of the sender, if it is set.
+	ReplyTo string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Transport delivers messages.
+type Transport interface {
+	Send(from string, m Message) error
+}
+
+// encode returns m as an RFC 5322 message from from.
+func (m Message) encode(from string) ([]byte, error) {
+	fromAddr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	toAddr, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address: %w", err)
+	}
+
+	var replyTo *mail.Address
+	if m.ReplyTo != "" {
+		if replyTo, err = mail.ParseAddress(m.ReplyTo); err != nil {
+			return nil, fmt.Errorf("invalid reply-to address: %w", err)
+		}
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		if part.content == "" {
+			continue
+		}
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&msg, "%s: %s\r\n", key, value)
+	}
+	header("From", fromAddr.String())
+	header("To", toAddr.String())
+	if replyTo != nil {
+		header("Reply-To", replyTo.String())
+	}
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", fmt.Sprintf("<%s@%s>", uuid.New(), domain(fromAddr.Address)))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	msg.WriteString("\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// domain returns the part of address after the @.
+func domain(address string) string {
+	return address[strings.LastIndex(address, "@")+1:]
+}
@@ -0,0 +1,242 @@
+package mail
+
+import (
+	"database/sql"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Schema creates the tables a Queue keeps its messages and suppression list
+// in.
+const Schema = `
+	CREATE TABLE IF NOT EXISTS mail_queue (
+		id TEXT PRIMARY KEY,
+		recipient TEXT NOT NULL,
+		reply_to TEXT NOT NULL DEFAULT '',
+		subject TEXT NOT NULL,
+		text TEXT NOT NULL,
+		html TEXT NOT NULL,
+		queued TEXT NOT NULL,
+		attempts INTEGER NOT NULL,
+		next_attempt TEXT NOT NULL,
+		last_error TEXT
+	);
+	CREATE INDEX IF NOT EXISTS mail_queue_next_attempt_idx ON mail_queue(next_attempt);
+	CREATE TABLE IF NOT EXISTS mail_suppressions (
+		address TEXT PRIMARY KEY,
+		reason TEXT NOT NULL,
+		added TEXT NOT NULL
+	);
+`
+
+const (
+	maxAttempts = 8
+	firstRetry  = time.Minute
+	pollEvery   = time.Minute
+	batchSize   = 20
+)
+
+// Queue stores messages in SQLite until its Transport accepts them. Failed
+// messages are retried with exponential backoff, up to maxAttempts times.
+//
+// Messages are never sent to suppressed addresses. An address is suppressed
+// when the SMTP server permanently refuses it as a recipient, or by Suppress.
+// Other permanent errors are retried like any other, since they usually mean
+// the server is misconfigured and every message would fail the same way.
+type Queue struct {
+	db        *sql.DB
+	transport Transport
+	from      string
+	wake      chan struct{}
+}
+
+// NewQueue creates a Queue sending messages from from through transport. The
+// tables in Schema must exist in db.
+func NewQueue(db *sql.DB, transport Transport, from string) *Queue {
+	return &Queue{
+		db:        db,
+		transport: transport,
+		from:      from,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// normalizeAddress returns the bare, lowercase address in address, so that
+// suppressions match however the address is written.
+func normalizeAddress(address string) (string, error) {
+	addr, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(addr.Address), nil
+}
+
+// Enqueue queues m to be sent. Messages to suppressed addresses are dropped.
+func (q *Queue) Enqueue(m Message) error {
+	suppressed, err := q.Suppressed(m.To)
+	if err != nil {
+		return err
+	} else if suppressed {
+		return nil
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := q.db.Exec(`
+		INSERT INTO mail_queue (id, recipient, reply_to, subject, text, html, queued, attempts, next_attempt)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 0, ?);
+	`, uuid.New().String(), m.To, m.ReplyTo, m.Subject, m.Text, m.HTML, now, now); err != nil {
+		return err
+	}
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run sends queued messages as they become due, forever.
+func (q *Queue) Run() {
+	for {
+		if err := q.Flush(); err != nil {
+			log.Printf("error sending mail: %v\n", err)
+		}
+		select {
+		case <-q.wake:
+		case <-time.After(pollEvery):
+		}
+	}
+}
+
+type queuedMessage struct {
+	id       string
+	attempts int
+	Message
+}
+
+// Flush tries to send every message that is due.
+func (q *Queue) Flush() error {
+	for {
+		due, err := q.due()
+		if err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+		for _, m := range due {
+			if err := q.send(m); err != nil {
+				return err
+			}
+		}
+		if len(due) < batchSize {
+			return nil
+		}
+	}
+}
+
+func (q *Queue) due() ([]queuedMessage, error) {
+	rows, err := q.db.Query(`
+		SELECT id, recipient, reply_to, subject, text, html, attempts
+		FROM mail_queue
+		WHERE next_attempt <= ?
+		ORDER BY next_attempt
+		LIMIT ?;
+	`, time.Now().UTC().Format(time.RFC3339), batchSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []queuedMessage
+	for rows.Next() {
+		var m queuedMessage
+		if err := rows.Scan(&m.id, &m.To, &m.ReplyTo, &m.Subject, &m.Text, &m.HTML, &m.attempts); err != nil {
+			return nil, err
+		}
+		due = append(due, m)
+	}
+	return due, rows.Err()
+}
+
+// send tries to send m once, and removes it from the queue or reschedules it
+// depending on how that went. The returned error is only about the queue
+// itself; errors from the Transport are logged.
+func (q *Queue) send(m queuedMessage) error {
+	// The address may have been suppressed since m was queued.
+	if suppressed, err := q.Suppressed(m.To); err != nil {
+		return err
+	} else if suppressed {
+		_, err := q.db.Exec(`DELETE FROM mail_queue WHERE id = ?;`, m.id)
+		return err
+	}
+
+	sendErr := q.transport.Send(q.from, m.Message)
+	if sendErr == nil {
+		_, err := q.db.Exec(`DELETE FROM mail_queue WHERE id = ?;`, m.id)
+		return err
+	}
+
+	attempts := m.attempts + 1
+	if IsRecipientRejected(sendErr) {
+		log.Printf("mail to %s rejected, suppressing address: %v\n", m.To, sendErr)
+		if err := q.Suppress(m.To, sendErr.Error()); err != nil {
+			return err
+		}
+		_, err := q.db.Exec(`DELETE FROM mail_queue WHERE id = ?;`, m.id)
+		return err
+	} else if attempts >= maxAttempts {
+		log.Printf("giving up on mail to %s after %d attempts: %v\n", m.To, attempts, sendErr)
+		_, err := q.db.Exec(`DELETE FROM mail_queue WHERE id = ?;`, m.id)
+		return err
+	}
+
+	log.Printf("error sending mail to %s, will retry: %v\n", m.To, sendErr)
+	next := time.Now().Add(firstRetry << (attempts - 1)).UTC().Format(time.RFC3339)
+	_, err := q.db.Exec(`
+		UPDATE mail_queue SET attempts = ?, next_attempt = ?, last_error = ? WHERE id = ?;
+	`, attempts, next, sendErr.Error(), m.id)
+	return err
+}
+
+// Suppress stops any more mail being sent to address, and drops mail already
+// queued for it.
+func (q *Queue) Suppress(address, reason string) error {
+	address, err := normalizeAddress(address)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.db.Exec(`
+		INSERT INTO mail_suppressions (address, reason, added) VALUES (?, ?, ?)
+		ON CONFLICT (address) DO UPDATE SET reason = excluded.reason, added = excluded.added;
+	`, address, reason, time.Now().UTC().Format(time.RFC3339))
+	return err
+}
+
+// Unsuppress lets mail be sent to address again.
+func (q *Queue) Unsuppress(address string) error {
+	address, err := normalizeAddress(address)
+	if err != nil {
+		return err
+	}
+
+	_, err = q.db.Exec(`DELETE FROM mail_suppressions WHERE address = ?;`, address)
+	return err
+}
+
+// Suppressed reports whether mail to address is suppressed.
+func (q *Queue) Suppressed(address string) (bool, error) {
+	address, err := normalizeAddress(address)
+	if err != nil {
+		return false, err
+	}
+
+	var suppressed bool
+	err = q.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM mail_suppressions WHERE address = ?);`, address).Scan(&suppressed)
+	return suppressed, err
+}
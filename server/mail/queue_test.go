@@ -0,0 +1,95 @@
+package mail
+
+import (
+	"database/sql"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// failingTransport fails to send every message with err.
+type failingTransport struct {
+	err error
+}
+
+func (t failingTransport) Send(from string, m Message) error {
+	return t.err
+}
+
+func newTestQueue(t *testing.T, transport Transport) (*Queue, *sql.DB) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "mail.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := db.Exec(Schema); err != nil {
+		t.Fatalf("creating tables: %v", err)
+	}
+	return NewQueue(db, transport, "noreply@example.com"), db
+}
+
+func TestQueueSuppressesRejectedRecipients(t *testing.T) {
+	q, db := newTestQueue(t, failingTransport{&RecipientError{
+		Address: "ada@example.com",
+		Err:     &textproto.Error{Code: 550, Msg: "no such user"},
+	}})
+	if err := q.Enqueue(testMessage); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := q.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if suppressed, err := q.Suppressed("ada@example.com"); err != nil {
+		t.Fatalf("Suppressed: %v", err)
+	} else if !suppressed {
+		t.Error("rejected recipient was not suppressed")
+	}
+	var queued int
+	db.QueryRow(`SELECT COUNT(*) FROM mail_queue;`).Scan(&queued)
+	if queued != 0 {
+		t.Errorf("%d messages still queued to a rejected recipient", queued)
+	}
+}
+
+func TestQueueRetriesOtherPermanentErrors(t *testing.T) {
+	q, db := newTestQueue(t, failingTransport{&textproto.Error{Code: 535, Msg: "authentication failed"}})
+	if err := q.Enqueue(testMessage); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+	if err := q.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if suppressed, err := q.Suppressed("ada@example.com"); err != nil {
+		t.Fatalf("Suppressed: %v", err)
+	} else if suppressed {
+		t.Error("recipient was suppressed after the server refused to log in")
+	}
+	var attempts int
+	if err := db.QueryRow(`SELECT attempts FROM mail_queue;`).Scan(&attempts); err != nil {
+		t.Fatalf("message was not kept to retry: %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("message has %d attempts, want 1", attempts)
+	}
+}
+
+func TestQueueDropsSuppressedRecipients(t *testing.T) {
+	q, db := newTestQueue(t, failingTransport{})
+	if err := q.Suppress("Ada <ADA@example.com>", "test"); err != nil {
+		t.Fatalf("Suppress: %v", err)
+	}
+	if err := q.Enqueue(testMessage); err != nil {
+		t.Fatalf("Enqueue: %v", err)
+	}
+
+	var queued int
+	db.QueryRow(`SELECT COUNT(*) FROM mail_queue;`).Scan(&queued)
+	if queued != 0 {
+		t.Error("message to a suppressed address was queued")
+	}
+}
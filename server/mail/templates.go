@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"strings"
+	texttemplate "text/template"
+)
+
+// Each email is a pair of templates in templates/: name.txt is the plain text
+// body and also defines the "subject" template, and name.html is the HTML
+// body, rendered inside layout.html.
+
+//go:embed templates
+var templateFS embed.FS
+
+var (
+	textTemplates = map[string]*texttemplate.Template{}
+	htmlTemplates = map[string]*htmltemplate.Template{}
+)
+
+func init() {
+	layout := htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/layout.html"))
+
+	names, err := fs.Glob(templateFS, "templates/*.txt")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range names {
+		name := strings.TrimSuffix(path.Base(file), ".txt")
+		textTemplates[name] = texttemplate.Must(texttemplate.ParseFS(templateFS, file))
+		htmlTemplates[name] = htmltemplate.Must(htmltemplate.Must(layout.Clone()).ParseFS(templateFS, "templates/"+name+".html"))
+	}
+}
+
+// Render returns the email called name, made from its templates with data,
+// addressed to to.
+func Render(to, name string, data any) (Message, error) {
+	text, ok := textTemplates[name]
+	if !ok {
+		return Message{}, fmt.Errorf("no email called %q", name)
+	}
+
+	var subject, body, html bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return Message{}, err
+	}
+	if err := text.Execute(&body, data); err != nil {
+		return Message{}, err
+	}
+	if err := htmlTemplates[name].ExecuteTemplate(&html, "layout.html", data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(body.String()) + "\n",
+		HTML:    html.String(),
+	}, nil
+}
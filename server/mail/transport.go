@@ -0,0 +1,130 @@
+package mail
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// SMTPTransport sends messages through an SMTP server, using STARTTLS when the
+// server supports it.
+type SMTPTransport struct {
+	// Addr is the server's host:port.
+	Addr     string
+	Username string
+	Password string
+}
+
+func (t SMTPTransport) Send(from string, m Message) error {
+	msg, err := m.encode(from)
+	if err != nil {
+		return err
+	}
+
+	fromAddr, _ := mail.ParseAddress(from)
+	toAddr, _ := mail.ParseAddress(m.To)
+
+	host, _, err := net.SplitHostPort(t.Addr)
+	if err != nil {
+		host = t.Addr
+	}
+
+	c, err := smtp.Dial(t.Addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if t.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", t.Username, t.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(fromAddr.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(toAddr.Address); err != nil {
+		return &RecipientError{Address: toAddr.Address, Err: err}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// RecipientError is returned by SMTPTransport when the server refuses a
+// message's recipient.
+type RecipientError struct {
+	Address string
+	Err     error
+}
+
+func (e *RecipientError) Error() string {
+	return fmt.Sprintf("recipient %s refused: %v", e.Address, e.Err)
+}
+
+func (e *RecipientError) Unwrap() error {
+	return e.Err
+}
+
+// IsRecipientRejected reports whether err says the server will never accept
+// mail for the recipient, so sending to them again is pointless. Permanent
+// errors about anything else, like a failed login or a refused sender, are
+// the server's or our own problem and not the recipient's, so they are not
+// counted.
+func IsRecipientRejected(err error) bool {
+	var rcptErr *RecipientError
+	var smtpErr *textproto.Error
+	return errors.As(err, &rcptErr) && errors.As(rcptErr.Err, &smtpErr) && smtpErr.Code >= 500
+}
+
+// LogTransport is a Transport for development and tests that does not send
+// anything. If Dir is set, each message is written to a .eml file in it,
+// which most mail clients can open. Otherwise the message's text is logged.
+type LogTransport struct {
+	Dir string
+}
+
+var unsafeFilename = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+func (t LogTransport) Send(from string, m Message) error {
+	if t.Dir == "" {
+		log.Printf("mail to %s: %s\n%s\n", m.To, m.Subject, m.Text)
+		return nil
+	}
+
+	msg, err := m.encode(from)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(t.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), unsafeFilename.ReplaceAllString(m.To, "_"))
+	return os.WriteFile(filepath.Join(t.Dir, name), msg, 0o644)
+}
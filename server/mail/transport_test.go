@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"bufio"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP runs an SMTP server that replies to each command with the reply
+// in replies for its verb, or 250 if there is none, returning its address.
+func fakeSMTP(t *testing.T, replies map[string]string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, replies)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveSMTP(conn net.Conn, replies map[string]string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.Fields(line + " x")[0])
+		if custom, ok := replies[verb]; ok {
+			reply(custom)
+			continue
+		}
+		switch verb {
+		case "EHLO", "HELO":
+			reply("250 fake")
+		case "DATA":
+			reply("354 go ahead")
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				} else if line == ".\r\n" {
+					break
+				}
+			}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+var testMessage = Message{To: "ada@example.com", Subject: "Hi", Text: "Hello", HTML: "<p>Hello</p>"}
+
+func TestSMTPTransport(t *testing.T) {
+	addr := fakeSMTP(t, nil)
+	if err := (SMTPTransport{Addr: addr}).Send("foliospot <noreply@example.com>", testMessage); err != nil {
+		t.Errorf("Send: %v", err)
+	}
+}
+
+func TestSMTPTransportRejections(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		replies  map[string]string
+		rejected bool
+	}{
+		{"recipient unknown", map[string]string{"RCPT": "550 5.1.1 no such user"}, true},
+		{"recipient busy", map[string]string{"RCPT": "452 4.2.2 mailbox full"}, false},
+		{"sender refused", map[string]string{"MAIL": "553 5.7.1 sender not allowed"}, false},
+		{"message refused", map[string]string{"DATA": "554 5.6.0 message refused"}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			addr := fakeSMTP(t, test.replies)
+			err := (SMTPTransport{Addr: addr}).Send("noreply@example.com", testMessage)
+			if err == nil {
+				t.Fatal("Send succeeded")
+			}
+			if got := IsRecipientRejected(err); got != test.rejected {
+				t.Errorf("IsRecipientRejected(%v) = %v, want %v", err, got, test.rejected)
+			}
+		})
+	}
+}
+
+func TestIsRecipientRejected(t *testing.T) {
+	// Permanent errors not about the recipient, like a failed login, are
+	// not the recipient's fault.
+	if IsRecipientRejected(&textproto.Error{Code: 535, Msg: "authentication failed"}) {
+		t.Error("IsRecipientRejected is true for a failed login")
+	}
+}
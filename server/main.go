@@ -12,7 +12,6 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"strings"
 	"time"
@@ -27,8 +26,10 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/nfnt/resize"
 	"github.com/rwcarlsen/goexif/exif"
-	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+	"nmilo.ca/portfolio/mail"
+	"nmilo.ca/portfolio/sso"
 )
 
 func Must[T any](t T, err error) T {
@@ -56,34 +57,8 @@ func CreateSet[T comparable](ts ...T) map[T]struct{} {
 	return val
 }
 
-type Portfolio struct {
-	FirstName string    `json:"firstName"`
-	LastName  string    `json:"lastName"`
-	Location  string    `json:"location"`
-	Bio       string    `json:"bio"`
-	Sections  []Section `json:"sections"`
-
-	SidebarColor    string `json:"sidebarColor"`
-	BackgroundColor string `json:"backgroundColor"`
-	ProjectColor    string `json:"projectColor"`
-	AccentColor     string `json:"accentColor"`
-	Font            string `json:"font"`
-}
-
-type Section struct {
-	Title    string    `json:"title"`
-	Projects []Project `json:"projects"`
-}
-
-type Project struct {
-	Name        string `json:"name"`
-	Description string `json:"description"`
-	ImageURL    string `json:"imageURL,omitempty"`
-	Link        string `json:"link,omitempty"`
-}
-
-var defaultPortfolio = Portfolio{
-	Sections:        make([]Section, 0),
+var defaultPortfolio = folio.Portfolio{
+	Sections:        make([]folio.Section, 0),
 	SidebarColor:    "amber-400",
 	BackgroundColor: "slate-50",
 	ProjectColor:    "slate-100",
@@ -96,16 +71,13 @@ var sessionManager *scs.SessionManager
 var s3svc *s3.S3
 
 var frontend string
-
-var (
-	googleOauthConfig *oauth2.Config
-	oauthStateString  = "random-state-string"
-)
+var backend string
 
 func writeHeaders(w http.ResponseWriter, r *http.Request, method string) bool {
 	w.Header().Set("Access-Control-Allow-Origin", frontend)
+	w.Header().Add("Vary", "Origin")
 	w.Header().Set("Access-Control-Allow-Methods", method+", OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	w.Header().Set("Access-Control-Allow-Credentials", "true")
 	if r.Method == "OPTIONS" {
 		w.WriteHeader(http.StatusOK)
@@ -118,16 +90,42 @@ func writeHeaders(w http.ResponseWriter, r *http.Request, method string) bool {
 	return false
 }
 
-// savePortfolio stores the portfolio p under the user with UUID id.
-// User must exist.
-func savePortfolio(id uuid.UUID, p Portfolio) error {
+// savePortfolio stores the portfolio p under the user with UUID id, giving
+// its projects IDs if they have none. User must exist. Their draft, if any,
+// is kept until they publish or discard it.
+func savePortfolio(id uuid.UUID, p folio.Portfolio) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := writePortfolio(tx, id, p); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	portfolioSaved(id, p)
+	return nil
+}
+
+// writePortfolio is savePortfolio within tx. portfolioSaved must be called
+// once tx is committed.
+func writePortfolio(tx *sql.Tx, id uuid.UUID, p folio.Portfolio) error {
+	if err := claimProjectIDs(tx, id, &p); err != nil {
+		return err
+	}
+
 	j, err := json.Marshal(p)
 	if err != nil {
 		return err
 	}
 
 	t := time.Now().Format(time.RFC3339)
-	result, err := db.Exec(`
+	result, err := tx.Exec(`
 		UPDATE users
 		SET portfolio = ?,
 			last_saved = ?
@@ -150,9 +148,83 @@ func savePortfolio(id uuid.UUID, p Portfolio) error {
 	return nil
 }
 
+// portfolioSaved updates what is derived from the portfolio of the user with
+// UUID id after p was saved as it.
+func portfolioSaved(id uuid.UUID, p folio.Portfolio) {
+	refreshSocialCard(id)
+	if err := updateDirectory(id, p); err != nil {
+		log.Printf("error updating directory entry of %s: %v\n", id, err)
+	}
+}
+
+// loadPortfolio returns the portfolio stored under the user with UUID id.
+// Returns [sql.ErrNoRows] if the user does not exist.
+func loadPortfolio(id uuid.UUID) (folio.Portfolio, error) {
+	return scanPortfolio(db.QueryRow(`SELECT portfolio FROM users WHERE uuid = ?;`, id.String()))
+}
+
+// loadPortfolioByUsername returns the portfolio published under username.
+// Returns [sql.ErrNoRows] if the user does not exist.
+func loadPortfolioByUsername(username string) (folio.Portfolio, error) {
+	return scanPortfolio(db.QueryRow(`SELECT portfolio FROM users WHERE username = ?;`, username))
+}
+
+func scanPortfolio(row *sql.Row) (folio.Portfolio, error) {
+	var j string
+	if err := row.Scan(&j); err != nil {
+		return folio.Portfolio{}, err
+	}
+
+	var p folio.Portfolio
+	if err := json.Unmarshal([]byte(j), &p); err != nil {
+		return folio.Portfolio{}, err
+	}
+
+	return p, nil
+}
+
+// requestedPortfolio returns the portfolio of the user named by the username
+// query parameter, or of the logged in user if there is none. Errors are
+// suitable for returning from an [apis.Handler] function.
+func requestedPortfolio(r *http.Request) (folio.Portfolio, error) {
+	var p folio.Portfolio
+	var err error
+	if username := r.URL.Query().Get("username"); username != "" {
+		if err := requireUnlocked(r, username); err != nil {
+			return folio.Portfolio{}, err
+		}
+		p, err = loadPortfolioByUsername(username)
+		if errors.Is(err, sql.ErrNoRows) {
+			if renamed, _, ok := renamedUsername(username); ok {
+				return folio.Portfolio{}, errUsernameMoved(renamed)
+			}
+		}
+	} else {
+		var id uuid.UUID
+		id, err = requireLogin(r, scopeRead)
+		if err != nil {
+			return folio.Portfolio{}, err
+		}
+		p, err = loadPortfolio(id)
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return folio.Portfolio{}, apis.StatusNotFound
+	}
+	return p, err
+}
+
 // getLogin returns the UUID behind an authorized request r, or an error if the
-// request is not authorized.
-func getLogin(r *http.Request) (uuid.UUID, error) {
+// request is not authorized. Requests are authorized either by the session or
+// by a personal access token granting scope, sent as a bearer token.
+func getLogin(r *http.Request, scope tokenScope) (uuid.UUID, error) {
+	if token, ok := bearerToken(r); ok {
+		if scope == scopeSession {
+			return uuid.Nil, scopeError{scope}
+		}
+		return tokenLogin(token, scope)
+	}
+
 	userid := sessionManager.GetString(r.Context(), "userid")
 	if userid == "" {
 		return uuid.Nil, fmt.Errorf("not logged in")
@@ -168,18 +240,28 @@ func getLogin(r *http.Request) (uuid.UUID, error) {
 	return id, nil
 }
 
+// requireLogin is like getLogin, but the error it returns is suitable for
+// returning from an [apis.Handler] function.
+func requireLogin(r *http.Request, scope tokenScope) (uuid.UUID, error) {
+	id, err := getLogin(r, scope)
+	if err != nil {
+		return uuid.Nil, apis.WrapError(err, loginErrorStatus(err))
+	}
+	return id, nil
+}
+
 func putPortfolioHandler(w http.ResponseWriter, r *http.Request) {
 	if writeHeaders(w, r, "POST") {
 		return
 	}
 
-	id, err := getLogin(r)
+	id, err := getLogin(r, scopeWritePortfolio)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		http.Error(w, err.Error(), loginErrorStatus(err))
 		return
 	}
 
-	var p Portfolio
+	var p folio.Portfolio
 
 	dec := json.NewDecoder(r.Body)
 	if err := dec.Decode(&p); err != nil {
@@ -195,43 +277,29 @@ func putPortfolioHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-func getPortfolioHandler(w http.ResponseWriter, r *http.Request) {
-	if writeHeaders(w, r, "GET") {
-		return
+// getPortfolioHandler returns the portfolio of the user given by the username
+// query parameter, or the logged in user's if there is none.
+func getPortfolioHandler(r *http.Request) (any, error) {
+	portfolio, err := requestedPortfolio(r)
+	if err != nil {
+		return nil, err
 	}
 
+	// Public portfolios come with their structured data, for the client to
+	// put in the page. The editor's copy is left as is, since it is saved
+	// back with put_portfolio.
 	username := r.URL.Query().Get("username")
-	var row *sql.Row
-	if username != "" {
-		row = db.QueryRow(`SELECT portfolio FROM users WHERE username = ?;`, username)
-	} else {
-		id, err := getLogin(r)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
-
-		row = db.QueryRow(`SELECT portfolio FROM users WHERE uuid = ?;`, id)
+	if username == "" {
+		return portfolio, nil
 	}
 
-	var j string
-	if err := row.Scan(&j); err != nil {
-		if err == sql.ErrNoRows {
-			http.Error(w, "not found", http.StatusNotFound)
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-		}
-		return
-	}
+	recordPublicView(r, username)
 
-	var portfolio Portfolio
-	if err := json.Unmarshal([]byte(j), &portfolio); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(portfolio)
+	ld := newJSONLD(username, portfolio)
+	return struct {
+		folio.Portfolio
+		JSONLD *jsonLD `json:"jsonLD,omitempty"`
+	}{portfolio, &ld}, nil
 }
 
 func getLoginHandler(w http.ResponseWriter, r *http.Request) {
@@ -239,9 +307,9 @@ func getLoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := getLogin(r)
+	id, err := getLogin(r, scopeRead)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		http.Error(w, err.Error(), loginErrorStatus(err))
 		return
 	}
 
@@ -260,19 +328,50 @@ func getLoginHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-var reservedNames = CreateSet[string]("", "api", "auth", "signup", "login", "editor", "p", "blog")
+// reservedNames cannot be usernames, since they are routes of the site or,
+// as subdomains, hosts of its infrastructure.
+var reservedNames = CreateSet[string](
+	"", "api", "auth", "signup", "login", "editor", "p", "blog", "share", "directory", "sitemap.xml", "sitemaps",
+	"www", "mail", "smtp", "imap", "pop", "mx", "ns1", "ns2", "cdn", "static", "assets",
+	"app", "admin", "status", "docs", "help", "support", "dev", "staging",
+)
 
-func isUsernameAvailable(name string) (bool, error) {
-	if len(name) < 2 || len(name) > 16 {
-		return false, nil
+// isValidUsername reports whether name can be a username, whether or not it
+// is taken.
+func isValidUsername(name string) bool {
+	if len(name) < 2 || len(name) > 16 || !isDNSLabel(name) {
+		return false
 	}
 
-	if _, ok := reservedNames[name]; ok {
+	if baseDomain != "" && isOwnHost(name+"."+baseDomain) {
+		return false
+	}
+
+	_, reserved := reservedNames[name]
+	return !reserved
+}
+
+// queryer runs queries, in a transaction or not.
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// isUsernameAvailable reports whether name is a valid username that nobody
+// has, or gave up less than usernameGracePeriod ago. A name the user with
+// UUID holder gave up is still available to them; uuid.Nil holds no names.
+func isUsernameAvailable(q queryer, name string, holder uuid.UUID) (bool, error) {
+	if !isValidUsername(name) {
 		return false, nil
 	}
 
 	var exists bool
-	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = ?);`, name).Scan(&exists); err != nil {
+	if err := q.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)
+			OR EXISTS(
+				SELECT 1 FROM username_history
+				WHERE old_username = ? AND user_uuid != ? AND expires > ?
+			);
+	`, name, name, holder.String(), time.Now().Format(time.RFC3339)).Scan(&exists); err != nil {
 		return false, err
 	}
 
@@ -284,7 +383,7 @@ func checkUsernameAvailableHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	avail, err := isUsernameAvailable(r.URL.Query().Get("username"))
+	avail, err := isUsernameAvailable(db, r.URL.Query().Get("username"), uuid.Nil)
 	if err != nil {
 		http.Error(w, "error checking username: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -308,9 +407,9 @@ func uploadImageHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, err := getLogin(r)
+	id, err := getLogin(r, scopeUploadImages)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusUnauthorized)
+		http.Error(w, err.Error(), loginErrorStatus(err))
 		return
 	}
 	_ = id // TODO: save image in portfolio
@@ -438,9 +537,11 @@ func encodeJPEG(w *bytes.Buffer, m image.Image, exifData *exif.Exif) error {
 	return nil
 }
 
+const imageBucket = "foliopage-images"
+
 func saveImageToS3(imgData []byte, filename, contentType string) (string, error) {
 	if _, err := s3svc.PutObject(&s3.PutObjectInput{
-		Bucket:               aws.String("foliopage-images"),
+		Bucket:               aws.String(imageBucket),
 		Key:                  aws.String(filename),
 		Body:                 bytes.NewReader(imgData),
 		ContentType:          aws.String(contentType),
@@ -450,16 +551,49 @@ func saveImageToS3(imgData []byte, filename, contentType string) (string, error)
 		return "", err
 	}
 
-	return fmt.Sprintf("https://foliopage-images.s3.amazonaws.com/%s", filename), nil
+	return folio.ImageBucketURL + filename, nil
 }
 
-func handleGoogleSignup(w http.ResponseWriter, r *http.Request) {
+// loadImageFromS3 downloads an image previously saved by saveImageToS3 given
+// its public URL. URLs outside of the image bucket are rejected so that
+// portfolio contents cannot make the server fetch arbitrary resources.
+func loadImageFromS3(url string) (data []byte, contentType string, err error) {
+	key, ok := folio.ImageKey(url)
+	if !ok {
+		return nil, "", fmt.Errorf("image %s is not stored in %s", url, imageBucket)
+	}
+
+	out, err := s3svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(imageBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer out.Body.Close()
+
+	data, err = io.ReadAll(io.LimitReader(out.Body, 5*1024*1024))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, aws.StringValue(out.ContentType), nil
+}
+
+// oauthSignupHandler signs up with the provider in the path, under the
+// username query parameter.
+func oauthSignupHandler(w http.ResponseWriter, r *http.Request) {
 	if writeHeaders(w, r, "GET") {
 		return
 	}
 
+	provider, ok := requestedProvider(w, r)
+	if !ok {
+		return
+	}
+
 	username := r.URL.Query().Get("username")
-	avail, err := isUsernameAvailable(username)
+	avail, err := isUsernameAvailable(db, username, uuid.Nil)
 	if err != nil {
 		http.Error(w, "error checking username: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -469,73 +603,75 @@ func handleGoogleSignup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	state := fmt.Sprintf("%s:%s", oauthStateString, username)
-	url := googleOauthConfig.AuthCodeURL(state)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	beginOAuth(w, r, provider, oauthAttempt{Username: username, ReturnTo: "/editor"})
 }
 
-func handleGoogleLogin(w http.ResponseWriter, r *http.Request) {
+// oauthLoginHandler logs in with the provider in the path, going to the
+// frontend path given by the return_to query parameter afterwards, or the
+// editor if there is none.
+func oauthLoginHandler(w http.ResponseWriter, r *http.Request) {
 	if writeHeaders(w, r, "GET") {
 		return
 	}
 
-	url := googleOauthConfig.AuthCodeURL(oauthStateString)
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	provider, ok := requestedProvider(w, r)
+	if !ok {
+		return
+	}
+
+	beginOAuth(w, r, provider, oauthAttempt{ReturnTo: safeReturnPath(r.URL.Query().Get("return_to"), "/editor")})
 }
 
-func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
+func oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
 	if writeHeaders(w, r, "GET") {
 		return
 	}
 
-	state := r.FormValue("state")
-	stateParts := strings.Split(state, ":")
-	if stateParts[0] != oauthStateString {
-		http.Error(w, "invalid OAuth state", http.StatusBadRequest)
+	provider, ok := requestedProvider(w, r)
+	if !ok {
 		return
 	}
 
-	code := r.FormValue("code")
-	token, err := googleOauthConfig.Exchange(r.Context(), code)
-	if err != nil {
+	attempt, userInfo, err := finishOAuth(r, provider)
+	if errors.Is(err, errInvalidOAuthState) {
+		http.Error(w, "invalid OAuth state", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		log.Printf("error logging in with %s: %v\n", provider.Name, err)
 		http.Redirect(w, r, frontend+"/", http.StatusTemporaryRedirect)
 		return
 	}
 
-	res, err := http.Get("https://www.googleapis.com/oauth2/v2/userinfo?access_token=" + url.QueryEscape(token.AccessToken))
-	if err != nil {
-		http.Error(w, "could not get OAuth response", http.StatusInternalServerError)
+	if attempt.LinkTo != "" {
+		finishLinking(w, r, attempt, userInfo)
 		return
 	}
 
-	defer res.Body.Close()
-
-	var userInfo struct {
-		Email string `json:"email"`
-	}
-	if err := json.NewDecoder(res.Body).Decode(&userInfo); err != nil {
-		http.Error(w, "could not parse OAuth response", http.StatusInternalServerError)
-		return
-	}
+	logIn(w, r, provider.Title, userInfo, attempt.Username, attempt.ReturnTo)
+}
 
+// logIn logs r in as the user who logs in through userInfo, which came from
+// the provider called title. If username is not "", a user with it is signed
+// up if there is none. Users who logged in go to returnTo afterwards.
+func logIn(w http.ResponseWriter, r *http.Request, title string, userInfo sso.Identity, username, returnTo string) {
 	/*
-		here either the email exists or not, and either we entered through the login
-		flow (len(stateParts) == 1, username not provided) or the signup flow
-		(len(stateParts) == 2, username provided)
+		here either the identity has a user or not, and either we entered
+		through the login flow (username == "") or the signup flow (username
+		provided)
 
 		this gives us 4 possible states:
 
-		1. email exists, login flow
+		1. user exists, login flow
 		-> log user in
 
-		2. email does not exist, signup flow
+		2. user does not exist, signup flow
 		-> create new user in database
 		-> log user in
 
-		3. email exists, signup flow
+		3. user exists, signup flow
 		-> log user into existing account and tell them
 
-		4. email does not exist, login flow
+		4. user does not exist, login flow
 		-> send user back to signup page to fill out a username
 		   TODO POST-MVP: this signup page requests a sign in with Google again
 			 maybe make a separate page solely for finishing signup (although this
@@ -543,71 +679,75 @@ func handleGoogleCallback(w http.ResponseWriter, r *http.Request) {
 
 	*/
 
-	var existingUsername string
-	var idstr string
-
-	emailExists := true
-	if err := db.QueryRow(`SELECT username, uuid FROM users WHERE email = ?;`, userInfo.Email).Scan(&existingUsername, &idstr); err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			emailExists = false
-		} else {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	idstr, existingUsername, userExists, err := identityUser(userInfo)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	loginFlow := len(stateParts) < 2
+	loginFlow := username == ""
 
-	if !emailExists && loginFlow {
+	if !userExists && loginFlow {
 		// case 4
-		http.Redirect(w, r, frontend+"/signup?finish=true", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, frontend+"/signup?finish=true", http.StatusSeeOther)
 		return
 	}
 
-	if !emailExists && !loginFlow {
+	if !userExists && !loginFlow {
 		// create new user in database (case 2)
-		username := stateParts[1]
-		log.Printf("creating new user %s (%s)\n", username, userInfo.Email)
-		portfolio := Must(json.Marshal(defaultPortfolio))
-		now := time.Now().Format(time.RFC3339)
-		id := uuid.New()
-		idstr = id.String()
+		if userInfo.Email == "" || !userInfo.EmailVerified {
+			http.Error(w, title+" has not verified your email address", http.StatusForbidden)
+			return
+		}
+		if taken, err := emailHasAccount(userInfo.Email); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		} else if taken {
+			// They most likely signed up before through another provider,
+			// and should link this one to that account instead.
+			http.Redirect(w, r, frontend+"/signup?error=email_taken", http.StatusSeeOther)
+			return
+		}
 
-		if _, err := db.Exec(`
-				INSERT INTO users (uuid, email, username, signup_time, signup_ip, signup_agent, portfolio, last_saved)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-			`,
-			idstr,
-			userInfo.Email,
-			username,
-			now,
-			r.RemoteAddr,
-			r.UserAgent(),
-			portfolio,
-			now,
-		); err != nil {
-			http.Redirect(w, r, frontend+"/signup?error=true", http.StatusTemporaryRedirect)
+		log.Printf("creating new user %s (%s)\n", username, userInfo.Email)
+		id, err := createUser(r, username, userInfo)
+		if err != nil {
+			http.Redirect(w, r, frontend+"/signup?error=true", http.StatusSeeOther)
 			return
 		}
+		idstr = id.String()
+
+		sendEmail(userInfo.Email, "welcome", struct {
+			Username     string
+			PortfolioURL string
+			EditorURL    string
+		}{username, portfolioURL(username), frontend + "/editor"})
 	}
 
 	// case 1, 2, or 3
 
-	if err := sessionManager.RenewToken(r.Context()); err != nil {
+	if err := startSession(r, idstr); err != nil {
 		http.Error(w, "failed to renew session token", http.StatusInternalServerError)
 		return
 	}
 
-	sessionManager.Put(r.Context(), "userid", idstr)
-
-	if emailExists && !loginFlow {
+	if userExists && !loginFlow {
 		// case 3
-		http.Redirect(w, r, frontend+"/editor?existing_login="+existingUsername, http.StatusTemporaryRedirect)
+		http.Redirect(w, r, frontend+"/editor?existing_login="+existingUsername, http.StatusSeeOther)
 	} else {
 		// case 1 or 2
-		http.Redirect(w, r, frontend+"/editor", http.StatusTemporaryRedirect)
+		http.Redirect(w, r, frontend+returnTo, http.StatusSeeOther)
 	}
+}
 
+// startSession logs r's session in as the user with UUID id, under a new
+// session token so that one known from before cannot be used to act as them.
+func startSession(r *http.Request, id string) error {
+	if err := sessionManager.RenewToken(r.Context()); err != nil {
+		return err
+	}
+	sessionManager.Put(r.Context(), "userid", id)
+	return nil
 }
 
 func logoutHandler(w http.ResponseWriter, r *http.Request) {
@@ -627,13 +767,47 @@ func main() {
 	Require(godotenv.Load())
 
 	frontend = os.Getenv("FRONTEND_HOST")
-	backend := os.Getenv("SERVER_HOST")
+	baseDomain = os.Getenv("BASE_DOMAIN")
+	backend = os.Getenv("SERVER_HOST")
+	clientBuildDir = os.Getenv("CLIENT_BUILD_DIR")
 
 	awsSession := session.Must(session.NewSession())
 	s3svc = s3.New(awsSession)
 
 	db = Must(sql.Open("sqlite3", os.Getenv("DATABASE_LOCATION")))
 
+	createTables()
+
+	loadAuthProviders()
+	Require(loadWebAuthn())
+	Require(loadTrustedProxies())
+
+	go runDomainChecks()
+	go runAnalyticsRollups()
+
+	Must(db.Exec(mail.Schema))
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = defaultMailFrom
+	}
+	mailer = mail.NewQueue(db, newMailTransport(), mailFrom)
+	go mailer.Run()
+	Require(renameReservedUsernames())
+
+	sessionManager = scs.New()
+	sessionManager.Lifetime = 24 * time.Hour
+	// Cookie.Domain is left unset, so session cookies are host-only and never
+	// sent to user subdomains, which serve content from users.
+	sessionManager.Store = sqlite3store.New(db)
+
+	log.Println("running on port 8000")
+	log.Fatalln(http.ListenAndServe(":8000", routes()))
+}
+
+// createTables creates any tables missing from db.
+func createTables() {
+	createMigrationsTable()
+
 	Must(db.Exec(`
 		CREATE TABLE IF NOT EXISTS sessions (
 			token TEXT PRIMARY KEY,
@@ -647,7 +821,7 @@ func main() {
 	Must(db.Exec(`
 		CREATE TABLE IF NOT EXISTS users (
 			uuid TEXT PRIMARY KEY,
-			email TEXT NOT NULL UNIQUE,
+			email TEXT NOT NULL,
 			username TEXT NOT NULL UNIQUE,
 			signup_time TEXT,
 			signup_ip TEXT,
@@ -657,32 +831,342 @@ func main() {
 		);
 	`))
 
-	googleOauthConfig = &oauth2.Config{
-		RedirectURL:  backend + "/auth/google/callback",
-		ClientID:     os.Getenv("GOOGLE_OAUTH_CLIENT_ID"),
-		ClientSecret: os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"),
-		Scopes: []string{
-			"https://www.googleapis.com/auth/userinfo.email",
-		},
-		Endpoint: google.Endpoint,
-	}
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS identities (
+			provider TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			user_uuid TEXT NOT NULL,
+			email TEXT,
+			email_verified INTEGER NOT NULL DEFAULT 0,
+			linked TEXT NOT NULL,
+			last_used TEXT,
+			PRIMARY KEY (provider, subject)
+		);
+	`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS identities_user_idx ON identities(user_uuid);`))
+	Require(allowSharedUserEmails())
 
-	sessionManager = scs.New()
-	sessionManager.Lifetime = 24 * time.Hour
-	sessionManager.Store = sqlite3store.New(db)
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS passkeys (
+			id TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL,
+			name TEXT NOT NULL,
+			public_key BLOB NOT NULL,
+			attestation_type TEXT NOT NULL,
+			aaguid BLOB,
+			sign_count INTEGER NOT NULL,
+			transports TEXT NOT NULL,
+			backup_eligible INTEGER NOT NULL,
+			backup_state INTEGER NOT NULL,
+			created TEXT NOT NULL,
+			last_used TEXT
+		);
+	`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS passkeys_user_idx ON passkeys(user_uuid);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_links (
+			token_hash TEXT PRIMARY KEY,
+			email TEXT NOT NULL,
+			username TEXT NOT NULL,
+			return_to TEXT NOT NULL,
+			nonce_hash TEXT NOT NULL,
+			created TEXT NOT NULL,
+			expires TEXT NOT NULL,
+			used TEXT
+		);
+	`))
 
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS username_history (
+			old_username TEXT NOT NULL,
+			new_username TEXT NOT NULL,
+			user_uuid TEXT NOT NULL,
+			changed TEXT NOT NULL,
+			expires TEXT NOT NULL
+		);
+	`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS username_history_old_idx ON username_history(old_username, expires);`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS username_history_user_idx ON username_history(user_uuid, changed);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS drafts (
+			uuid TEXT PRIMARY KEY,
+			portfolio TEXT NOT NULL,
+			last_saved TEXT NOT NULL
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL,
+			name TEXT NOT NULL,
+			hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL,
+			created TEXT NOT NULL,
+			expires TEXT,
+			last_used TEXT
+		);
+	`))
+
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS api_tokens_user_idx ON api_tokens(user_uuid);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS custom_domains (
+			domain TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL,
+			token TEXT NOT NULL,
+			created TEXT NOT NULL,
+			verified TEXT
+		);
+	`))
+
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS custom_domains_user_idx ON custom_domains(user_uuid);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS portfolio_passwords (
+			uuid TEXT PRIMARY KEY,
+			hash TEXT NOT NULL,
+			changed TEXT NOT NULL
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS portfolio_grants (
+			hash TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL,
+			expires TEXT NOT NULL
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS share_links (
+			id TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL,
+			hash TEXT NOT NULL UNIQUE,
+			created TEXT NOT NULL,
+			expires TEXT,
+			max_views INTEGER,
+			views INTEGER NOT NULL
+		);
+	`))
+
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS share_links_user_idx ON share_links(user_uuid);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS analytics_salts (
+			day TEXT PRIMARY KEY,
+			salt TEXT NOT NULL
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS page_views (
+			user_uuid TEXT NOT NULL,
+			day TEXT NOT NULL,
+			visitor TEXT NOT NULL,
+			referrer TEXT NOT NULL,
+			country TEXT NOT NULL,
+			device TEXT NOT NULL
+		);
+	`))
+
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS page_views_user_day_idx ON page_views(user_uuid, day);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS analytics_daily (
+			user_uuid TEXT NOT NULL,
+			day TEXT NOT NULL,
+			views INTEGER NOT NULL,
+			visitors INTEGER NOT NULL,
+			PRIMARY KEY (user_uuid, day)
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS analytics_breakdown (
+			user_uuid TEXT NOT NULL,
+			day TEXT NOT NULL,
+			dimension TEXT NOT NULL,
+			value TEXT NOT NULL,
+			views INTEGER NOT NULL,
+			PRIMARY KEY (user_uuid, day, dimension, value)
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS project_clicks (
+			user_uuid TEXT NOT NULL,
+			day TEXT NOT NULL,
+			project TEXT NOT NULL,
+			name TEXT NOT NULL,
+			clicks INTEGER NOT NULL,
+			PRIMARY KEY (user_uuid, day, project)
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS contact_messages (
+			id TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL,
+			name TEXT NOT NULL,
+			email TEXT NOT NULL,
+			message TEXT NOT NULL,
+			received TEXT NOT NULL,
+			read INTEGER NOT NULL,
+			archived INTEGER NOT NULL
+		);
+	`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS contact_messages_user_idx ON contact_messages(user_uuid, received);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS directory_optouts (
+			uuid TEXT PRIMARY KEY,
+			opted_out TEXT NOT NULL
+		);
+	`))
+
+	Require(createDirectory())
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS blog_posts (
+			id TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL,
+			slug TEXT NOT NULL,
+			title TEXT NOT NULL,
+			body TEXT NOT NULL,
+			cover_image_url TEXT NOT NULL,
+			published INTEGER NOT NULL,
+			publish_date TEXT,
+			created TEXT NOT NULL,
+			updated TEXT NOT NULL,
+			UNIQUE (user_uuid, slug)
+		);
+	`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS blog_posts_user_date_idx ON blog_posts(user_uuid, publish_date);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS blog_post_tags (
+			post_id TEXT NOT NULL,
+			tag TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			PRIMARY KEY (post_id, tag)
+		);
+	`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS blog_post_tags_tag_idx ON blog_post_tags(tag);`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS project_permalinks (
+			id TEXT PRIMARY KEY,
+			user_uuid TEXT NOT NULL
+		);
+	`))
+	Must(db.Exec(`CREATE INDEX IF NOT EXISTS project_permalinks_user_idx ON project_permalinks(user_uuid);`))
+	Require(runMigration("project_permalinks_released", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE project_permalinks ADD COLUMN released TEXT;`)
+		return err
+	}))
+	Require(assignMissingProjectIDs())
+	Require(runMigration("project_clicks_by_id", moveClicksToProjectIDs))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS social_cards (
+			uuid TEXT PRIMARY KEY,
+			fingerprint TEXT NOT NULL,
+			url TEXT NOT NULL,
+			generated TEXT NOT NULL
+		);
+	`))
+
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS retired_social_cards (
+			url TEXT PRIMARY KEY,
+			delete_after TEXT NOT NULL
+		);
+	`))
+}
+
+// routes returns the handler serving every request to the server.
+func routes() http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/api/put_portfolio", putPortfolioHandler)
-	mux.HandleFunc("/api/get_portfolio", getPortfolioHandler)
 	mux.HandleFunc("/api/get_login", getLoginHandler)
 	mux.HandleFunc("/api/logout", logoutHandler)
 	mux.HandleFunc("/api/upload_image", uploadImageHandler)
-	mux.HandleFunc("/auth/google/signup", handleGoogleSignup)
-	mux.HandleFunc("/auth/google/login", handleGoogleLogin)
-	mux.HandleFunc("/auth/google/callback", handleGoogleCallback)
+	mux.HandleFunc("/auth/{provider}/signup", oauthSignupHandler)
+	mux.HandleFunc("/auth/{provider}/login", oauthLoginHandler)
+	mux.HandleFunc("/auth/{provider}/callback", oauthCallbackHandler)
+	mux.HandleFunc("/auth/{provider}/link", oauthLinkHandler)
+	mux.HandleFunc("/auth/email/login", emailLoginHandler)
 	mux.HandleFunc("/api/check_username", checkUsernameAvailableHandler)
 
-	log.Println("running on port 8000")
-	log.Fatalln(http.ListenAndServe(":8000", sessionManager.LoadAndSave(mux)))
+	api := apis.NewHandler(frontend)
+	api.AllowOrigins(isPortfolioOrigin)
+	api.HandlePublicFunc("/api/get_portfolio", "GET", getPortfolioHandler)
+	api.HandlePublicFunc("/api/unlock_portfolio", "POST", unlockPortfolioHandler)
+	api.HandleFunc("/api/get_portfolio_password", "GET", getPortfolioPasswordHandler)
+	api.HandleFunc("/api/set_portfolio_password", "POST", setPortfolioPasswordHandler)
+	api.HandleFunc("/api/export_pdf", "GET", exportPDFHandler)
+	api.HandleFunc("/api/export_site", "GET", exportSiteHandler)
+	api.HandleFunc("/api/export_portfolio", "GET", exportPortfolioHandler)
+	api.HandleFunc("/api/import_portfolio", "POST", importPortfolioHandler)
+	api.HandleFunc("/api/get_draft", "GET", getDraftHandler)
+	api.HandleFunc("/api/put_draft", "POST", putDraftHandler)
+	api.HandleFunc("/api/publish", "POST", publishHandler)
+	api.HandleFunc("/api/draft_status", "GET", draftStatusHandler)
+	api.HandleFunc("/api/discard_draft", "POST", discardDraftHandler)
+	api.HandleFunc("/api/analytics", "GET", analyticsHandler)
+	api.HandleFunc("/api/list_share_links", "GET", listShareLinksHandler)
+	api.HandleFunc("/api/create_share_link", "POST", createShareLinkHandler)
+	api.HandleFunc("/api/revoke_share_link", "POST", revokeShareLinkHandler)
+	api.HandleFunc("/api/shared_portfolio", "GET", sharedPortfolioHandler)
+	api.HandleFunc("/api/list_tokens", "GET", listTokensHandler)
+	api.HandleFunc("/api/create_token", "POST", createTokenHandler)
+	api.HandleFunc("/api/revoke_token", "POST", revokeTokenHandler)
+	api.HandleFunc("/api/social_card", "GET", socialCardHandler)
+	api.HandleFunc("/api/list_domains", "GET", listDomainsHandler)
+	api.HandleFunc("/api/add_domain", "POST", addDomainHandler)
+	api.HandleFunc("/api/verify_domain", "POST", verifyDomainHandler)
+	api.HandleFunc("/api/remove_domain", "POST", removeDomainHandler)
+	api.HandlePublicFunc("/api/blog_posts", "GET", blogPostsHandler)
+	api.HandlePublicFunc("/api/blog_post", "GET", blogPostHandler)
+	api.HandleFunc("/api/list_posts", "GET", listPostsHandler)
+	api.HandleFunc("/api/get_post", "GET", getPostHandler)
+	api.HandleFunc("/api/save_post", "POST", savePostHandler)
+	api.HandleFunc("/api/delete_post", "POST", deletePostHandler)
+	api.HandleFunc("/api/rename_user", "POST", renameUserHandler)
+	api.HandleFunc("/api/search_directory", "GET", searchDirectoryHandler)
+	api.HandleFunc("/api/get_directory_listing", "GET", getDirectoryListingHandler)
+	api.HandleFunc("/api/set_directory_listing", "POST", setDirectoryListingHandler)
+	api.HandlePublicFunc("/api/contact", "POST", contactHandler)
+	api.HandleFunc("/api/list_messages", "GET", listMessagesHandler)
+	api.HandleFunc("/api/mark_message_read", "POST", markMessageReadHandler)
+	api.HandleFunc("/api/archive_message", "POST", archiveMessageHandler)
+	api.HandleFunc("/api/delete_message", "POST", deleteMessageHandler)
+	api.HandlePublicFunc("/api/project", "GET", projectHandler)
+	api.HandleFunc("/api/auth_providers", "GET", authProvidersHandler)
+	api.HandleFunc("/api/list_identities", "GET", listIdentitiesHandler)
+	api.HandleFunc("/api/unlink_identity", "POST", unlinkIdentityHandler)
+	api.HandleFunc("/api/request_login_link", "POST", requestLoginLinkHandler)
+	api.HandleFunc("/api/passkey/begin_registration", "POST", beginPasskeyRegistrationHandler)
+	api.HandleFunc("/api/passkey/finish_registration", "POST", finishPasskeyRegistrationHandler)
+	api.HandleFunc("/api/passkey/begin_login", "POST", beginPasskeyLoginHandler)
+	api.HandleFunc("/api/passkey/finish_login", "POST", finishPasskeyLoginHandler)
+	api.HandleFunc("/api/list_passkeys", "GET", listPasskeysHandler)
+	api.HandleFunc("/api/delete_passkey", "POST", deletePasskeyHandler)
+	api.HandleFunc("/p/{id}/go", "GET", projectClickHandler)
+	api.HandleFunc("/p/{portfolio}/{project}/go", "GET", legacyProjectClickHandler)
+	mux.Handle("/api/", api.Muxer())
+	mux.Handle("/p/", api.Muxer())
+
+	mux.HandleFunc("GET /sitemap.xml", sitemapHandler)
+	mux.HandleFunc("GET /sitemaps/{page}", sitemapPageHandler)
+	mux.HandleFunc("GET /blog/{username}/feed.xml", blogFeedHandler)
+	mux.HandleFunc("GET /p/{id}", projectPageHandler)
+	mux.HandleFunc("/{username}", userPageHandler)
+	mux.Handle("/", clientHandler())
+
+	return sessionManager.LoadAndSave(portfolioHostHandler(mux))
 }
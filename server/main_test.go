@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/mail"
+	"nmilo.ca/portfolio/sso"
+	"nmilo.ca/portfolio/sso/ssotest"
+)
+
+// testServer is the server running against a fresh database, logging in
+// through an ssotest issuer under the provider name "test".
+type testServer struct {
+	*httptest.Server
+	issuer *ssotest.Issuer
+}
+
+// newTestDB gives the server a fresh database for the rest of the test.
+func newTestDB(t *testing.T) {
+	t.Helper()
+
+	var err error
+	db, err = sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() {
+		socialCardRefreshes.Wait()
+		db.Close()
+	})
+	createTables()
+	Must(db.Exec(mail.Schema))
+
+	mailer = mail.NewQueue(db, nil, defaultMailFrom)
+	sessionManager = scs.New()
+	frontend = "http://frontend.test"
+	Require(loadWebAuthn())
+	s3svc = newTestS3(t)
+}
+
+// newTestS3 returns an S3 client storing objects in memory.
+func newTestS3(t *testing.T) *s3.S3 {
+	t.Helper()
+	var mu sync.Mutex
+	objects := make(map[string][]byte)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case "GET":
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+				return
+			}
+			w.Write(data)
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return s3.New(session.Must(session.NewSession(&aws.Config{
+		Endpoint:         aws.String(srv.URL),
+		Region:           aws.String("us-east-1"),
+		Credentials:      credentials.NewStaticCredentials("test", "test", ""),
+		S3ForcePathStyle: aws.Bool(true),
+	})))
+}
+
+// sessionRequest returns a request to target from a new session.
+func sessionRequest(t *testing.T, method, target, body string) *http.Request {
+	t.Helper()
+	ctx, err := sessionManager.Load(context.Background(), "")
+	if err != nil {
+		t.Fatalf("loading session: %v", err)
+	}
+	return httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(ctx)
+}
+
+// loggedInRequest is like sessionRequest, for a session logged in as the user
+// with UUID id.
+func loggedInRequest(t *testing.T, method, target, body, id string) *http.Request {
+	t.Helper()
+	r := sessionRequest(t, method, target, body)
+	sessionManager.Put(r.Context(), "userid", id)
+	return r
+}
+
+// newTestServer starts a testServer, replacing the server's globals for the
+// rest of the test.
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	newTestDB(t)
+	loginLinkIPLimiter = newRateLimiter(10, time.Hour)
+	loginLinkEmailLimiter = newRateLimiter(3, loginLinkLifetime)
+
+	issuer := ssotest.NewIssuer()
+	t.Cleanup(issuer.Close)
+
+	srv := httptest.NewUnstartedServer(nil)
+	backend = "http://" + srv.Listener.Addr().String()
+	authProviders = sso.Registry{}
+	authProviders.Add(sso.NewOIDC(issuer.Config("test", backend+"/auth/test/callback")))
+
+	srv.Config.Handler = routes()
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return &testServer{srv, issuer}
+}
+
+// newBrowser returns a client that keeps cookies like a browser, but stops at
+// redirects so that tests can see where they lead.
+func newBrowser(t *testing.T) *http.Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("making cookie jar: %v", err)
+	}
+	return &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func get(t *testing.T, c *http.Client, url string) *http.Response {
+	t.Helper()
+	res, err := c.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	res.Body.Close()
+	return res
+}
+
+func post(t *testing.T, c *http.Client, url, contentType, body string) *http.Response {
+	t.Helper()
+	res, err := c.Post(url, contentType, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %s: %v", url, err)
+	}
+	res.Body.Close()
+	return res
+}
+
+// authorize starts a login at path with c, logs in at the issuer as whoever
+// it was told to, and returns the callback URL it sends c back to.
+func (s *testServer) authorize(t *testing.T, c *http.Client, path string) string {
+	t.Helper()
+	res := get(t, c, s.URL+path)
+	wantStatus(t, res, http.StatusTemporaryRedirect)
+
+	res = get(t, c, res.Header.Get("Location"))
+	wantStatus(t, res, http.StatusFound)
+	callback := res.Header.Get("Location")
+	if !strings.HasPrefix(callback, s.URL+"/auth/test/callback?") {
+		t.Fatalf("issuer sent the browser to %q, not the callback", callback)
+	}
+	return callback
+}
+
+// logIn logs c in through the issuer as the user with subject.
+func (s *testServer) logIn(t *testing.T, c *http.Client, subject string) {
+	t.Helper()
+	s.issuer.LogInAs(testUser(subject))
+	res := get(t, c, s.authorize(t, c, "/auth/test/login"))
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/editor")
+}
+
+// loggedIn reports whether c is logged in.
+func (s *testServer) loggedIn(t *testing.T, c *http.Client) bool {
+	t.Helper()
+	return get(t, c, s.URL+"/api/get_login").StatusCode == http.StatusOK
+}
+
+// createTestUser creates a user called username who logs in through identity,
+// returning their UUID.
+func createTestUser(t *testing.T, username string, identity sso.Identity) string {
+	t.Helper()
+	id, err := createUser(httptest.NewRequest("POST", "/", nil), username, identity)
+	if err != nil {
+		t.Fatalf("creating user %s: %v", username, err)
+	}
+	return id.String()
+}
+
+// testUser and testIdentity are the issuer's account with subject, and the
+// identity it logs in as.
+func testUser(subject string) ssotest.User {
+	return ssotest.User{Subject: subject, Email: subject + "@example.com", EmailVerified: true}
+}
+
+func testIdentity(subject string) sso.Identity {
+	return sso.Identity{Provider: "test", Subject: subject, Email: subject + "@example.com", EmailVerified: true}
+}
+
+// errorStatus returns the status of err if it is an API error.
+func errorStatus(err error) int {
+	var httpErr apis.HttpError
+	if errors.As(err, &httpErr) {
+		return httpErr.ErrorCode()
+	}
+	return 0
+}
+
+func wantStatus(t *testing.T, res *http.Response, status int) {
+	t.Helper()
+	if res.StatusCode != status {
+		t.Fatalf("%s %s: got status %d, want %d", res.Request.Method, res.Request.URL.Path, res.StatusCode, status)
+	}
+}
+
+// wantRedirect fails t unless res redirects to location with status.
+func wantRedirect(t *testing.T, res *http.Response, status int, location string) {
+	t.Helper()
+	wantStatus(t, res, status)
+	if got := res.Header.Get("Location"); got != location {
+		t.Fatalf("%s %s: redirected to %q, want %q", res.Request.Method, res.Request.URL.Path, got, location)
+	}
+}
+
+// withQuery returns rawURL with key set to value in its query.
+func withQuery(t *testing.T, rawURL, key, value string) string {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatalf("parsing %q: %v", rawURL, err)
+	}
+	q := u.Query()
+	q.Set(key, value)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+var (
+	mdImage    = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink     = regexp.MustCompile(`\[([^\]]*)\]\(([^)]*)\)`)
+	mdEmphasis = regexp.MustCompile(`(\*\*|__|\*|_|~~|` + "`" + `)([^*_~` + "`" + `]+)(\*\*|__|\*|_|~~|` + "`" + `)`)
+	mdHeading  = regexp.MustCompile(`(?m)^[ \t]{0,3}#{1,6}[ \t]+`)
+	mdQuote    = regexp.MustCompile(`(?m)^[ \t]{0,3}>[ \t]?`)
+	mdBullet   = regexp.MustCompile(`(?m)^([ \t]*)[*+-][ \t]+`)
+)
+
+// plainText strips the Markdown syntax used in bios and project descriptions,
+// leaving text that reads naturally outside of the React renderer. Links keep
+// their target in parentheses unless it is the same as the link text.
+func plainText(md string) string {
+	s := mdImage.ReplaceAllString(md, "$1")
+	s = mdLink.ReplaceAllStringFunc(s, func(link string) string {
+		m := mdLink.FindStringSubmatch(link)
+		if m[1] == "" || m[1] == m[2] {
+			return m[2]
+		}
+		return m[1] + " (" + m[2] + ")"
+	})
+	s = mdEmphasis.ReplaceAllString(s, "$2")
+	s = mdHeading.ReplaceAllString(s, "")
+	s = mdQuote.ReplaceAllString(s, "")
+	s = mdBullet.ReplaceAllString(s, "$1• ")
+	return strings.TrimSpace(s)
+}
+
+// markdownLinks returns the web links in md, without repeats, leaving out
+// images.
+func markdownLinks(md string) []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, m := range mdLink.FindAllStringSubmatch(mdImage.ReplaceAllString(md, ""), -1) {
+		if link := strings.TrimSpace(m[2]); folio.IsWebURL(link) && !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
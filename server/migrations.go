@@ -0,0 +1,47 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Changes to existing data that must only happen once, unlike creating tables
+// if they do not exist, are migrations. Each is recorded in the migrations
+// table in the same transaction as its changes, so it never runs again on
+// data it has already changed.
+
+func createMigrationsTable() {
+	Must(db.Exec(`
+		CREATE TABLE IF NOT EXISTS migrations (
+			name TEXT PRIMARY KEY,
+			applied TEXT NOT NULL
+		);
+	`))
+}
+
+// runMigration runs migrate in a transaction and records it as name, unless
+// a migration by that name has already run.
+func runMigration(name string, migrate func(tx *sql.Tx) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var applied bool
+	if err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM migrations WHERE name = ?);`, name).Scan(&applied); err != nil {
+		return err
+	} else if applied {
+		return nil
+	}
+
+	if err := migrate(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO migrations (name, applied) VALUES (?, ?);
+	`, name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
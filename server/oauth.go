@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"nmilo.ca/portfolio/sso"
+)
+
+// Users log in through the identity providers in authProviders, each at
+// /auth/{provider}/login. Each login gets a random state, PKCE verifier and
+// nonce, kept in the session of the browser that started it together with
+// what to do once it is back. The callback only accepts the state of the
+// latest attempt in its own session, so nobody can finish a login in someone
+// else's browser.
+
+var authProviders sso.Registry
+
+// loadAuthProviders sets up the providers configured in the environment:
+// Google and GitHub with GOOGLE_OAUTH_CLIENT_ID and GITHUB_CLIENT_ID, and
+// any OpenID Connect issuer named in the comma-separated OIDC_PROVIDERS with
+// OIDC_{NAME}_ISSUER, OIDC_{NAME}_CLIENT_ID, OIDC_{NAME}_CLIENT_SECRET and,
+// optionally, OIDC_{NAME}_TITLE.
+func loadAuthProviders() {
+	config := func(name, title, issuer, clientID, clientSecret string) sso.Config {
+		return sso.Config{
+			Name:         name,
+			Title:        title,
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  backend + "/auth/" + name + "/callback",
+		}
+	}
+
+	if id := os.Getenv("GOOGLE_OAUTH_CLIENT_ID"); id != "" {
+		authProviders.Add(sso.NewOIDC(config("google", "Google", "https://accounts.google.com", id, os.Getenv("GOOGLE_OAUTH_CLIENT_SECRET"))))
+	}
+	if id := os.Getenv("GITHUB_CLIENT_ID"); id != "" {
+		authProviders.Add(sso.NewGitHub(config("github", "GitHub", "", id, os.Getenv("GITHUB_CLIENT_SECRET"))))
+	}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		} else if name == emailProvider {
+			log.Printf("OIDC provider cannot be called %q, which is used by email logins\n", name)
+			continue
+		}
+		env := func(key string) string {
+			return os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + key)
+		}
+		title := env("TITLE")
+		if title == "" {
+			title = name
+		}
+		authProviders.Add(sso.NewOIDC(config(name, title, env("ISSUER"), env("CLIENT_ID"), env("CLIENT_SECRET"))))
+	}
+}
+
+// requestedProvider returns the provider named in the path of r, writing an
+// error and returning false if there is none.
+func requestedProvider(w http.ResponseWriter, r *http.Request) (*sso.Provider, bool) {
+	provider, ok := authProviders.Get(r.PathValue("provider"))
+	if !ok {
+		http.Error(w, "unknown login provider", http.StatusNotFound)
+	}
+	return provider, ok
+}
+
+// authProvidersHandler lists the providers users can log in through.
+func authProvidersHandler(r *http.Request) (any, error) {
+	type provider struct {
+		Name  string `json:"name"`
+		Title string `json:"title"`
+	}
+	providers := []provider{}
+	for _, p := range authProviders.All() {
+		providers = append(providers, provider{p.Name, p.Title})
+	}
+	return providers, nil
+}
+
+// oauthAttemptLifetime is how long a login has between leaving for the
+// provider and coming back.
+const oauthAttemptLifetime = 10 * time.Minute
+
+// oauthAttempt is a login that went to a provider and has not come back yet.
+type oauthAttempt struct {
+	Provider string
+	State    string
+	Verifier string
+	Nonce    string
+	// Username is the username to sign up with, or "" when logging in.
+	Username string
+	// LinkTo is the UUID of the user to link the identity to, or "" when
+	// logging in.
+	LinkTo string
+	// ReturnTo is the path on the frontend to go to once logged in.
+	ReturnTo string
+	Expires  time.Time
+}
+
+func init() {
+	gob.Register(oauthAttempt{})
+}
+
+const oauthAttemptKey = "oauth_attempt"
+
+// safeReturnPath returns path if it is a path on the frontend, or fallback if
+// it is empty or could lead elsewhere.
+func safeReturnPath(path, fallback string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.ContainsAny(path, "\\\r\n") {
+		return fallback
+	}
+	return path
+}
+
+// beginOAuth sends r to provider to log in, to do what attempt says once it
+// is back.
+func beginOAuth(w http.ResponseWriter, r *http.Request, provider *sso.Provider, attempt oauthAttempt) {
+	attempt.Provider = provider.Name
+	attempt.State = newRandomToken("")
+	attempt.Verifier = oauth2.GenerateVerifier()
+	attempt.Nonce = newRandomToken("")
+	attempt.Expires = time.Now().Add(oauthAttemptLifetime)
+
+	url, err := provider.AuthCodeURL(r.Context(), attempt.State, attempt.Verifier, attempt.Nonce)
+	if err != nil {
+		http.Error(w, "could not reach "+provider.Title, http.StatusBadGateway)
+		return
+	}
+
+	sessionManager.Put(r.Context(), oauthAttemptKey, attempt)
+	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+}
+
+var errInvalidOAuthState = errors.New("invalid OAuth state")
+
+// finishOAuth returns the attempt r comes back from provider with and who
+// the provider says logged in. The attempt is used up either way.
+func finishOAuth(r *http.Request, provider *sso.Provider) (oauthAttempt, sso.Identity, error) {
+	attempt, ok := sessionManager.Pop(r.Context(), oauthAttemptKey).(oauthAttempt)
+	state := r.FormValue("state")
+	if !ok || attempt.Provider != provider.Name || state == "" ||
+		subtle.ConstantTimeCompare([]byte(state), []byte(attempt.State)) != 1 ||
+		time.Now().After(attempt.Expires) {
+		return oauthAttempt{}, sso.Identity{}, errInvalidOAuthState
+	}
+
+	identity, err := provider.Exchange(r.Context(), r.FormValue("code"), attempt.Verifier, attempt.Nonce)
+	if err != nil {
+		return attempt, sso.Identity{}, err
+	}
+	return attempt, identity, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOAuthLogin(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	s.logIn(t, c, "ada")
+	if !s.loggedIn(t, c) {
+		t.Error("not logged in after coming back from the provider")
+	}
+}
+
+func TestOAuthLoginUnknownIdentity(t *testing.T) {
+	s := newTestServer(t)
+
+	c := newBrowser(t)
+	s.issuer.LogInAs(testUser("nobody"))
+	res := get(t, c, s.authorize(t, c, "/auth/test/login"))
+	wantRedirect(t, res, http.StatusSeeOther, frontend+"/signup?finish=true")
+	if s.loggedIn(t, c) {
+		t.Error("logged in with an identity no user has")
+	}
+}
+
+func TestOAuthCallbackStateMismatch(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	s.issuer.LogInAs(testUser("ada"))
+	callback := s.authorize(t, c, "/auth/test/login")
+	res := get(t, c, withQuery(t, callback, "state", "forged"))
+	wantStatus(t, res, http.StatusBadRequest)
+	if s.loggedIn(t, c) {
+		t.Error("logged in with the wrong state")
+	}
+}
+
+func TestOAuthCallbackOtherBrowser(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	// The callback is only good in the browser that started the login.
+	s.issuer.LogInAs(testUser("ada"))
+	callback := s.authorize(t, newBrowser(t), "/auth/test/login")
+	victim := newBrowser(t)
+	wantStatus(t, get(t, victim, callback), http.StatusBadRequest)
+	if s.loggedIn(t, victim) {
+		t.Error("logged in by a callback from another browser")
+	}
+}
+
+func TestOAuthCallbackReplayed(t *testing.T) {
+	s := newTestServer(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+
+	c := newBrowser(t)
+	s.issuer.LogInAs(testUser("ada"))
+	callback := s.authorize(t, c, "/auth/test/login")
+	wantRedirect(t, get(t, c, callback), http.StatusSeeOther, frontend+"/editor")
+	wantStatus(t, get(t, c, callback), http.StatusBadRequest)
+}
+
+func TestOAuthAttemptExpired(t *testing.T) {
+	newTestServer(t)
+	provider, _ := authProviders.Get("test")
+
+	ctx, err := sessionManager.Load(context.Background(), "")
+	if err != nil {
+		t.Fatalf("loading session: %v", err)
+	}
+	sessionManager.Put(ctx, oauthAttemptKey, oauthAttempt{
+		Provider: "test",
+		State:    "state",
+		Expires:  time.Now().Add(-time.Minute),
+	})
+
+	r := httptest.NewRequest("GET", "/auth/test/callback?state=state&code=code", nil).WithContext(ctx)
+	if _, _, err := finishOAuth(r, provider); !errors.Is(err, errInvalidOAuthState) {
+		t.Errorf("finishOAuth with an expired attempt = %v, want %v", err, errInvalidOAuthState)
+	}
+	if sessionManager.Exists(ctx, oauthAttemptKey) {
+		t.Error("expired attempt was kept in the session")
+	}
+}
+
+func TestSafeReturnPath(t *testing.T) {
+	for _, test := range []struct {
+		path, want string
+	}{
+		{"/editor", "/editor"},
+		{"/editor?tab=accounts#top", "/editor?tab=accounts#top"},
+		{"/", "/"},
+		{"", "/fallback"},
+		{"editor", "/fallback"},
+		{"//evil.example", "/fallback"},
+		{"/\\evil.example", "/fallback"},
+		{"https://evil.example/", "/fallback"},
+		{"javascript:alert(1)", "/fallback"},
+		{"/editor\r\nSet-Cookie: a=b", "/fallback"},
+	} {
+		if got := safeReturnPath(test.path, "/fallback"); got != test.want {
+			t.Errorf("safeReturnPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
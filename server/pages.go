@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+// Public pages are rendered here so that search engines and link unfurlers
+// see the portfolio instead of an empty shell. The page is the client's own
+// index.html with the portfolio rendered into it, so the SPA takes over once
+// its scripts load.
+
+var pageTemplates = template.Must(template.ParseFS(templateFS, "templates/portfolio.html", "templates/head.html"))
+
+// clientBuildDir is where the client's production build is, if this server
+// is serving it. Without it, pages are rendered into a bare HTML shell.
+var clientBuildDir string
+
+const fallbackIndex = `<!DOCTYPE html><html lang="en"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Foliospot</title></head><body><div id="root"></div></body></html>`
+
+var (
+	indexTitle       = regexp.MustCompile(`(?s)<title>.*?</title>`)
+	indexDescription = regexp.MustCompile(`(?s)<meta\s+name="description"[^>]*>`)
+	indexRoot        = regexp.MustCompile(`<div id="root">\s*</div>`)
+)
+
+const metaDescriptionLength = 160
+
+// clientIndex returns the client's index.html. It is read on every request so
+// that deploying a new client build does not need a server restart.
+func clientIndex() []byte {
+	if clientBuildDir == "" {
+		return []byte(fallbackIndex)
+	}
+
+	index, err := os.ReadFile(filepath.Join(clientBuildDir, "index.html"))
+	if err != nil {
+		log.Printf("error reading client index.html: %v\n", err)
+		return []byte(fallbackIndex)
+	}
+	return index
+}
+
+// serveClientIndex serves the client's index.html unchanged, leaving the
+// page to be rendered by the SPA.
+func serveClientIndex(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(clientIndex())
+}
+
+type pageMeta struct {
+	Type        string
+	Title       string
+	Description string
+	URL         string
+	FirstName   string
+	LastName    string
+	Username    string
+	Image       string
+	JSONLD      jsonLD
+	CSS         template.CSS
+}
+
+// summarize shortens text to fit in a meta description, cutting at a word.
+func summarize(text string, max int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= max {
+		return text
+	}
+
+	cut := string([]rune(text)[:max-1])
+	if i := strings.LastIndex(cut, " "); i > max/2 {
+		cut = cut[:i]
+	}
+	return strings.TrimRight(cut, " .,;:") + "…"
+}
+
+func newPageMeta(username string, p folio.Portfolio, theme portfolioTheme) pageMeta {
+	title := strings.TrimSpace(p.FirstName + " " + p.LastName)
+	if title == "" {
+		title = username
+	}
+
+	description := summarize(plainText(p.Bio), metaDescriptionLength)
+	if description == "" {
+		description = title + "'s portfolio on Foliospot"
+	}
+
+	return pageMeta{
+		Type:        "profile",
+		Title:       title,
+		Description: description,
+		URL:         portfolioURL(username),
+		FirstName:   p.FirstName,
+		LastName:    p.LastName,
+		Image:       cachedSocialCardURL(username),
+		JSONLD:      newJSONLD(username, p),
+		CSS:         template.CSS(theme.CSS()),
+	}
+}
+
+// renderPortfolioPage renders the public page of username into the client's
+// index.html. Pages served at the root of their own host name their user for
+// the client, since the username is not in the URL.
+func renderPortfolioPage(username string, p folio.Portfolio, atRoot bool) ([]byte, error) {
+	view := newPortfolioView(p, func(url string) string { return url })
+	trackProjectLinks(&view)
+	meta := newPageMeta(username, p, view.Theme)
+	if atRoot {
+		meta.Username = username
+	}
+
+	var head, body bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&head, "head", meta); err != nil {
+		return nil, err
+	}
+	if err := pageTemplates.ExecuteTemplate(&body, "portfolio", view); err != nil {
+		return nil, err
+	}
+
+	return injectPage(head.Bytes(), body.Bytes()), nil
+}
+
+// injectPage returns the client's index.html with its title and description
+// replaced by head, and body rendered into its root.
+func injectPage(head, body []byte) []byte {
+	page := clientIndex()
+	page = indexTitle.ReplaceAll(page, nil)
+	page = indexDescription.ReplaceAll(page, nil)
+	page = bytes.Replace(page, []byte("</head>"), append(head, "</head>"...), 1)
+	page = indexRoot.ReplaceAllLiteral(page, append(append([]byte(`<div id="root">`), body...), "</div>"...))
+	return page
+}
+
+// userPageHandler serves /{username}. App routes such as /editor are in
+// reservedNames and get the plain client, as do unknown usernames so the SPA
+// can show its own error.
+func userPageHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, "wrong method", http.StatusMethodNotAllowed)
+		return
+	}
+
+	username := r.PathValue("username")
+	if isClientFile(r.URL.Path) {
+		clientHandler().ServeHTTP(w, r)
+		return
+	}
+
+	if _, ok := reservedNames[username]; ok {
+		serveClientIndex(w, http.StatusOK)
+		return
+	}
+
+	servePortfolioPage(w, r, username, false)
+}
+
+// servePortfolioPage serves the public page of username, or the client's
+// index.html with an error status if it cannot be rendered.
+func servePortfolioPage(w http.ResponseWriter, r *http.Request, username string, atRoot bool) {
+	// Protected portfolios are left to the client, which asks for the
+	// password, and are kept out of search engines.
+	if protected, err := isProtectedUsername(username); err != nil {
+		log.Printf("error checking protection of %s: %v\n", username, err)
+		serveClientIndex(w, http.StatusInternalServerError)
+		return
+	} else if protected {
+		w.Header().Set("X-Robots-Tag", "noindex")
+		serveClientIndex(w, http.StatusOK)
+		return
+	}
+
+	p, err := loadPortfolioByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		if !atRoot && redirectRenamed(w, r, username, func(renamed string) string { return "/" + renamed }) {
+			return
+		}
+		serveClientIndex(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("error loading portfolio of %s: %v\n", username, err)
+		serveClientIndex(w, http.StatusInternalServerError)
+		return
+	}
+
+	page, err := renderPortfolioPage(username, p, atRoot)
+	if err != nil {
+		log.Printf("error rendering portfolio of %s: %v\n", username, err)
+		serveClientIndex(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(page)
+}
+
+// isClientFile reports whether path names a file in the client build, such
+// as /favicon.ico.
+func isClientFile(path string) bool {
+	if clientBuildDir == "" || path == "/" {
+		return false
+	}
+
+	info, err := os.Stat(filepath.Join(clientBuildDir, filepath.FromSlash(path)))
+	return err == nil && !info.IsDir()
+}
+
+// clientHandler serves files from the client build, falling back to
+// index.html for the SPA's own routes.
+func clientHandler() http.Handler {
+	if clientBuildDir == "" {
+		return http.NotFoundHandler()
+	}
+
+	files := http.FileServer(http.Dir(clientBuildDir))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isClientFile(r.URL.Path) {
+			files.ServeHTTP(w, r)
+			return
+		}
+		serveClientIndex(w, http.StatusOK)
+	})
+}
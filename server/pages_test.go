@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSummarize(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		max  int
+		want string
+	}{
+		{"", 20, ""},
+		{"Short  and\nsweet.", 20, "Short and sweet."},
+		{"Twenty characters!!!", 20, "Twenty characters!!!"},
+		{"Builds compilers, writes notes, and plays chess.", 30, "Builds compilers, writes…"},
+		{"Supercalifragilisticexpialidocious words", 20, "Supercalifragilisti…"},
+		{"Ünïcödé text is counted by runes here", 12, "Ünïcödé…"},
+	} {
+		if got := summarize(test.text, test.max); got != test.want {
+			t.Errorf("summarize(%q, %d) = %q, want %q", test.text, test.max, got, test.want)
+		}
+	}
+}
+
+func TestInjectPage(t *testing.T) {
+	clientBuildDir = t.TempDir()
+	t.Cleanup(func() { clientBuildDir = "" })
+	index := `<!doctype html><html><head><title>Foliospot</title>
+<meta name="description" content="Make a portfolio">
+<script src="/static/js/main.js"></script></head><body><div id="root">
+</div></body></html>`
+	if err := os.WriteFile(filepath.Join(clientBuildDir, "index.html"), []byte(index), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	page := string(injectPage([]byte("<title>Ada</title>"), []byte("<h1>Ada</h1>")))
+	for _, want := range []string{
+		`<script src="/static/js/main.js"></script><title>Ada</title></head>`,
+		`<div id="root"><h1>Ada</h1></div>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page does not contain %q:\n%s", want, page)
+		}
+	}
+	for _, unwanted := range []string{"<title>Foliospot</title>", "Make a portfolio"} {
+		if strings.Contains(page, unwanted) {
+			t.Errorf("page still contains %q", unwanted)
+		}
+	}
+}
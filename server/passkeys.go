@@ -0,0 +1,359 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+)
+
+// Users can log in with passkeys, which they add from their settings once
+// logged in some other way. Passkeys are discoverable, so logging in needs no
+// username: the authenticator says whose passkey it is by the user handle it
+// was made with, which is the user's UUID.
+//
+// Each ceremony's challenge is kept in the session between the request that
+// begins it and the one that finishes it, and is used up by the latter.
+
+const (
+	passkeyRegistrationKey = "passkey_registration"
+	passkeyLoginKey        = "passkey_login"
+	passkeyCeremonyTimeout = 5 * time.Minute
+	maxPasskeyNameLength   = 64
+)
+
+var webAuthn *webauthn.WebAuthn
+
+// loadWebAuthn sets up passkeys for the frontend. They belong to its host,
+// unless WEBAUTHN_RP_ID names a domain it is under.
+func loadWebAuthn() error {
+	rpID := os.Getenv("WEBAUTHN_RP_ID")
+	if rpID == "" {
+		u, err := url.Parse(frontend)
+		if err != nil {
+			return err
+		}
+		rpID = u.Hostname()
+	}
+
+	timeout := webauthn.TimeoutConfig{Enforce: true, Timeout: passkeyCeremonyTimeout, TimeoutUVD: passkeyCeremonyTimeout}
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "foliospot",
+		RPOrigins:     []string{frontend},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			RequireResidentKey: protocol.ResidentKeyRequired(),
+			ResidentKey:        protocol.ResidentKeyRequirementRequired,
+			UserVerification:   protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{Login: timeout, Registration: timeout},
+	})
+	if err != nil {
+		return err
+	}
+	webAuthn = w
+	return nil
+}
+
+// passkeyUser is a user as the webauthn package sees them.
+type passkeyUser struct {
+	id          uuid.UUID
+	username    string
+	credentials []webauthn.Credential
+}
+
+func (u *passkeyUser) WebAuthnID() []byte                         { return u.id[:] }
+func (u *passkeyUser) WebAuthnName() string                       { return u.username }
+func (u *passkeyUser) WebAuthnDisplayName() string                { return u.username }
+func (u *passkeyUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+// encodeCredentialID returns the form credential IDs are stored and shown in.
+func encodeCredentialID(id []byte) string {
+	return base64.RawURLEncoding.EncodeToString(id)
+}
+
+// loadPasskeyUser returns the user with UUID id and their passkeys. Returns
+// [sql.ErrNoRows] if the user does not exist.
+func loadPasskeyUser(id uuid.UUID) (*passkeyUser, error) {
+	u := &passkeyUser{id: id}
+	if err := db.QueryRow(`SELECT username FROM users WHERE uuid = ?;`, id.String()).Scan(&u.username); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state
+		FROM passkeys
+		WHERE user_uuid = ?;
+	`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var c webauthn.Credential
+		var credentialID, transports string
+		if err := rows.Scan(&credentialID, &c.PublicKey, &c.AttestationType, &c.Authenticator.AAGUID,
+			&c.Authenticator.SignCount, &transports, &c.Flags.BackupEligible, &c.Flags.BackupState); err != nil {
+			return nil, err
+		}
+		if c.ID, err = base64.RawURLEncoding.DecodeString(credentialID); err != nil {
+			return nil, err
+		}
+		for _, t := range strings.Split(transports, ",") {
+			if t != "" {
+				c.Transport = append(c.Transport, protocol.AuthenticatorTransport(t))
+			}
+		}
+		u.credentials = append(u.credentials, c)
+	}
+
+	return u, rows.Err()
+}
+
+// putCeremony keeps the state of a ceremony in r's session under key.
+func putCeremony(r *http.Request, key string, session *webauthn.SessionData) error {
+	j, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	sessionManager.Put(r.Context(), key, j)
+	return nil
+}
+
+// popCeremony returns and forgets the state of the ceremony in r's session
+// under key.
+func popCeremony(r *http.Request, key string) (webauthn.SessionData, error) {
+	var session webauthn.SessionData
+	j := sessionManager.PopBytes(r.Context(), key)
+	if j == nil {
+		return session, apis.NewError("no passkey ceremony was started", http.StatusBadRequest)
+	}
+	if err := json.Unmarshal(j, &session); err != nil {
+		return session, err
+	}
+	return session, nil
+}
+
+// beginPasskeyRegistrationHandler returns the options for the browser to make
+// a passkey for the logged in user with.
+func beginPasskeyRegistrationHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := loadPasskeyUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	creation, session, err := webAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.credentials).CredentialDescriptors()))
+	if err != nil {
+		return nil, err
+	}
+	if err := putCeremony(r, passkeyRegistrationKey, session); err != nil {
+		return nil, err
+	}
+
+	return creation, nil
+}
+
+// finishPasskeyRegistrationHandler stores the passkey the browser made, named
+// by the name query parameter.
+func finishPasskeyRegistrationHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := popCeremony(r, passkeyRegistrationKey)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := loadPasskeyUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	} else if utf8.RuneCountInString(name) > maxPasskeyNameLength {
+		return nil, apis.NewError(fmt.Sprintf("passkey names can be at most %d characters", maxPasskeyNameLength), http.StatusBadRequest)
+	}
+
+	credential, err := webAuthn.FinishRegistration(user, session, r)
+	if err != nil {
+		return nil, apis.WrapError(err, http.StatusBadRequest)
+	}
+
+	transports := make([]string, len(credential.Transport))
+	for i, t := range credential.Transport {
+		transports[i] = string(t)
+	}
+
+	info := passkeyInfo{
+		ID:         encodeCredentialID(credential.ID),
+		Name:       name,
+		Transports: transports,
+		BackedUp:   credential.Flags.BackupState,
+		Created:    time.Now().Format(time.RFC3339),
+	}
+	if _, err := db.Exec(`
+		INSERT INTO passkeys (id, user_uuid, name, public_key, attestation_type, aaguid, sign_count, transports, backup_eligible, backup_state, created)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+	`, info.ID, id.String(), name, credential.PublicKey, credential.AttestationType, credential.Authenticator.AAGUID,
+		credential.Authenticator.SignCount, strings.Join(transports, ","), credential.Flags.BackupEligible,
+		credential.Flags.BackupState, info.Created); err != nil {
+		return nil, err
+	}
+
+	sendAccountEmail(id, "passkey", struct {
+		Name      string
+		EditorURL string
+	}{name, frontend + "/editor"})
+
+	return info, nil
+}
+
+// beginPasskeyLoginHandler returns the options for the browser to log in with
+// any passkey it has for the site.
+func beginPasskeyLoginHandler(r *http.Request) (any, error) {
+	assertion, session, err := webAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, err
+	}
+	if err := putCeremony(r, passkeyLoginKey, session); err != nil {
+		return nil, err
+	}
+	return assertion, nil
+}
+
+// finishPasskeyLoginHandler logs in as the owner of the passkey the browser
+// signed the challenge with.
+func finishPasskeyLoginHandler(r *http.Request) (any, error) {
+	session, err := popCeremony(r, passkeyLoginKey)
+	if err != nil {
+		return nil, err
+	}
+
+	findUser := func(rawID, userHandle []byte) (webauthn.User, error) {
+		id, err := uuid.FromBytes(userHandle)
+		if err != nil {
+			return nil, err
+		}
+		return loadPasskeyUser(id)
+	}
+	found, credential, err := webAuthn.FinishPasskeyLogin(findUser, session, r)
+	if err != nil {
+		return nil, apis.NewError("could not log in with this passkey", http.StatusUnauthorized)
+	}
+	user := found.(*passkeyUser)
+
+	if credential.Authenticator.CloneWarning {
+		log.Printf("passkey %s of %s may have been cloned\n", encodeCredentialID(credential.ID), user.username)
+		return nil, apis.NewError("this passkey may have been copied, log in another way and remove it", http.StatusForbidden)
+	}
+
+	if _, err := db.Exec(`
+		UPDATE passkeys SET sign_count = ?, backup_state = ?, last_used = ? WHERE id = ?;
+	`, credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now().Format(time.RFC3339),
+		encodeCredentialID(credential.ID)); err != nil {
+		return nil, err
+	}
+
+	if err := startSession(r, user.id.String()); err != nil {
+		return nil, err
+	}
+	return struct {
+		Username string `json:"username"`
+	}{user.username}, nil
+}
+
+type passkeyInfo struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Transports []string `json:"transports"`
+	BackedUp   bool     `json:"backedUp"`
+	Created    string   `json:"created"`
+	LastUsed   string   `json:"lastUsed,omitempty"`
+}
+
+// listPasskeysHandler lists the logged in user's passkeys.
+func listPasskeysHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, name, transports, backup_state, created, last_used
+		FROM passkeys
+		WHERE user_uuid = ?
+		ORDER BY created;
+	`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := make([]passkeyInfo, 0)
+	for rows.Next() {
+		var p passkeyInfo
+		var transports string
+		var lastUsed sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &transports, &p.BackedUp, &p.Created, &lastUsed); err != nil {
+			return nil, err
+		}
+		p.Transports = []string{}
+		if transports != "" {
+			p.Transports = strings.Split(transports, ",")
+		}
+		p.LastUsed = lastUsed.String
+		passkeys = append(passkeys, p)
+	}
+
+	return passkeys, rows.Err()
+}
+
+// deletePasskeyHandler removes one of the logged in user's passkeys, so it can
+// no longer be used to log in.
+func deletePasskeyHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	result, err := db.Exec(`DELETE FROM passkeys WHERE id = ? AND user_uuid = ?;`, req.ID, id.String())
+	if err != nil {
+		return nil, err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+	return nil, nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/google/uuid"
+)
+
+// testAuthenticator is a software authenticator holding one passkey.
+type testAuthenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	signCount  uint32
+}
+
+func newTestAuthenticator(t *testing.T) *testAuthenticator {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &testAuthenticator{key: key, id: id}
+}
+
+// authenticatorData returns authenticator data for the frontend's RP ID,
+// followed by attested, if any.
+func (a *testAuthenticator) authenticatorData(flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(webAuthn.Config.RPID))
+	data := append(rpIDHash[:], byte(flags))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	return append(data, attested...)
+}
+
+// clientData returns the client data a browser at the frontend would make for
+// a ceremony of typ with challenge.
+func clientData(typ protocol.CeremonyType, challenge protocol.URLEncodedBase64) []byte {
+	j, _ := json.Marshal(map[string]string{
+		"type":      string(typ),
+		"challenge": challenge.String(),
+		"origin":    frontend,
+	})
+	return j
+}
+
+// create returns the credential the authenticator makes for options.
+func (a *testAuthenticator) create(t *testing.T, options *protocol.CredentialCreation) string {
+	t.Helper()
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	publicKey, err := cbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatalf("encoding public key: %v", err)
+	}
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.id)))
+	attested = append(append(attested, a.id...), publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(protocol.FlagUserPresent|protocol.FlagUserVerified|protocol.FlagAttestedCredentialData, attested),
+	})
+	if err != nil {
+		t.Fatalf("encoding attestation: %v", err)
+	}
+
+	j, _ := json.Marshal(map[string]any{
+		"id":    encodeCredentialID(a.id),
+		"rawId": encodeCredentialID(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    encodeCredentialID(clientData(protocol.CreateCeremony, options.Response.Challenge)),
+			"attestationObject": encodeCredentialID(attestation),
+			"transports":        []string{"internal", "hybrid"},
+		},
+	})
+	return string(j)
+}
+
+// get returns the assertion the authenticator makes for options.
+func (a *testAuthenticator) get(t *testing.T, options *protocol.CredentialAssertion) string {
+	t.Helper()
+	a.signCount++
+	data := a.authenticatorData(protocol.FlagUserPresent|protocol.FlagUserVerified, nil)
+	client := clientData(protocol.AssertCeremony, options.Response.Challenge)
+	clientHash := sha256.Sum256(client)
+	digest := sha256.Sum256(append(data, clientHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("signing assertion: %v", err)
+	}
+
+	j, _ := json.Marshal(map[string]any{
+		"id":    encodeCredentialID(a.id),
+		"rawId": encodeCredentialID(a.id),
+		"type":  "public-key",
+		"response": map[string]any{
+			"clientDataJSON":    encodeCredentialID(client),
+			"authenticatorData": encodeCredentialID(data),
+			"signature":         encodeCredentialID(signature),
+			"userHandle":        encodeCredentialID(a.userHandle),
+		},
+	})
+	return string(j)
+}
+
+// continueRequest returns a request to target in the same session as r.
+func continueRequest(r *http.Request, method, target, body string) *http.Request {
+	return httptest.NewRequest(method, target, strings.NewReader(body)).WithContext(r.Context())
+}
+
+// registerTestPasskey makes a passkey on a for the user with UUID id.
+func registerTestPasskey(t *testing.T, a *testAuthenticator, id, name string) passkeyInfo {
+	t.Helper()
+	r := loggedInRequest(t, "POST", "/api/passkey/begin_registration", "", id)
+	options, err := beginPasskeyRegistrationHandler(r)
+	if err != nil {
+		t.Fatalf("beginning registration: %v", err)
+	}
+	body := a.create(t, options.(*protocol.CredentialCreation))
+	info, err := finishPasskeyRegistrationHandler(continueRequest(r, "POST", "/api/passkey/finish_registration?name="+name, body))
+	if err != nil {
+		t.Fatalf("finishing registration: %v", err)
+	}
+	return info.(passkeyInfo)
+}
+
+// logInWithTestPasskey logs in with a from a new session, returning the
+// session's request and the error logging in.
+func logInWithTestPasskey(t *testing.T, a *testAuthenticator) (*http.Request, error) {
+	t.Helper()
+	r := sessionRequest(t, "POST", "/api/passkey/begin_login", "")
+	options, err := beginPasskeyLoginHandler(r)
+	if err != nil {
+		t.Fatalf("beginning login: %v", err)
+	}
+	r = continueRequest(r, "POST", "/api/passkey/finish_login", a.get(t, options.(*protocol.CredentialAssertion)))
+	_, err = finishPasskeyLoginHandler(r)
+	return r, err
+}
+
+func listTestPasskeys(t *testing.T, id string) []passkeyInfo {
+	t.Helper()
+	res, err := listPasskeysHandler(loggedInRequest(t, "GET", "/api/list_passkeys", "", id))
+	if err != nil {
+		t.Fatalf("listing passkeys: %v", err)
+	}
+	return res.([]passkeyInfo)
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	a := newTestAuthenticator(t)
+	info := registerTestPasskey(t, a, ada, "Laptop")
+	if info.ID != encodeCredentialID(a.id) || info.Name != "Laptop" {
+		t.Errorf("registered passkey = %+v", info)
+	}
+	if handle, err := uuid.FromBytes(a.userHandle); err != nil || handle.String() != ada {
+		t.Errorf("user handle = %v, %v, want %s", handle, err, ada)
+	}
+
+	r, err := logInWithTestPasskey(t, a)
+	if err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+	if got := sessionManager.GetString(r.Context(), "userid"); got != ada {
+		t.Errorf("logged in as %q, want %s", got, ada)
+	}
+
+	passkeys := listTestPasskeys(t, ada)
+	if len(passkeys) != 1 || passkeys[0].LastUsed == "" || strings.Join(passkeys[0].Transports, ",") != "internal,hybrid" {
+		t.Errorf("passkeys after logging in = %+v", passkeys)
+	}
+	var signCount uint32
+	if err := db.QueryRow(`SELECT sign_count FROM passkeys;`).Scan(&signCount); err != nil || signCount != a.signCount {
+		t.Errorf("stored sign count = %d, %v, want %d", signCount, err, a.signCount)
+	}
+}
+
+func TestPasskeyRegistrationEmail(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	registerTestPasskey(t, newTestAuthenticator(t), ada, "Phone")
+
+	var text string
+	if err := db.QueryRow(`
+		SELECT text FROM mail_queue WHERE recipient = 'ada@example.com' ORDER BY queued DESC, rowid DESC LIMIT 1;
+	`).Scan(&text); err != nil {
+		t.Fatalf("finding passkey email: %v", err)
+	}
+	if !strings.Contains(text, `"Phone"`) {
+		t.Errorf("passkey email does not name the passkey:\n%s", text)
+	}
+}
+
+func TestPasskeyCloned(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	a := newTestAuthenticator(t)
+	registerTestPasskey(t, a, ada, "")
+	if _, err := logInWithTestPasskey(t, a); err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+
+	a.signCount = 0
+	r, err := logInWithTestPasskey(t, a)
+	if status := errorStatus(err); status != http.StatusForbidden {
+		t.Errorf("logging in with a lower sign count: %v, want status %d", err, http.StatusForbidden)
+	}
+	if sessionManager.Exists(r.Context(), "userid") {
+		t.Error("logged in with a passkey that may have been cloned")
+	}
+}
+
+func TestPasskeyDeleted(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	bob := createTestUser(t, "bob", testIdentity("bob"))
+	a := newTestAuthenticator(t)
+	info := registerTestPasskey(t, a, ada, "")
+	body := `{"id": "` + info.ID + `"}`
+
+	_, err := deletePasskeyHandler(loggedInRequest(t, "POST", "/api/delete_passkey", body, bob))
+	if status := errorStatus(err); status != http.StatusNotFound {
+		t.Errorf("deleting someone else's passkey: %v, want status %d", err, http.StatusNotFound)
+	}
+
+	if _, err := deletePasskeyHandler(loggedInRequest(t, "POST", "/api/delete_passkey", body, ada)); err != nil {
+		t.Fatalf("deleting passkey: %v", err)
+	}
+	if passkeys := listTestPasskeys(t, ada); len(passkeys) != 0 {
+		t.Errorf("passkeys after deleting = %+v", passkeys)
+	}
+	if _, err := logInWithTestPasskey(t, a); errorStatus(err) != http.StatusUnauthorized {
+		t.Errorf("logging in with a deleted passkey: %v, want status %d", err, http.StatusUnauthorized)
+	}
+}
+
+func TestPasskeyCeremonyUsedUp(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	a := newTestAuthenticator(t)
+	registerTestPasskey(t, a, ada, "")
+
+	r := sessionRequest(t, "POST", "/api/passkey/begin_login", "")
+	options, err := beginPasskeyLoginHandler(r)
+	if err != nil {
+		t.Fatalf("beginning login: %v", err)
+	}
+	body := a.get(t, options.(*protocol.CredentialAssertion))
+	if _, err := finishPasskeyLoginHandler(continueRequest(r, "POST", "/api/passkey/finish_login", body)); err != nil {
+		t.Fatalf("logging in: %v", err)
+	}
+
+	_, err = finishPasskeyLoginHandler(continueRequest(r, "POST", "/api/passkey/finish_login", body))
+	if status := errorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("finishing a login twice: %v, want status %d", err, http.StatusBadRequest)
+	}
+}
+
+func TestPasskeyNameTooLong(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	r := loggedInRequest(t, "POST", "/api/passkey/begin_registration", "", ada)
+	options, err := beginPasskeyRegistrationHandler(r)
+	if err != nil {
+		t.Fatalf("beginning registration: %v", err)
+	}
+	body := newTestAuthenticator(t).create(t, options.(*protocol.CredentialCreation))
+	name := strings.Repeat("a", maxPasskeyNameLength+1)
+	_, err = finishPasskeyRegistrationHandler(continueRequest(r, "POST", "/api/passkey/finish_registration?name="+name, body))
+	if status := errorStatus(err); status != http.StatusBadRequest {
+		t.Errorf("registering a passkey with a long name: %v, want status %d", err, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"image"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"nmilo.ca/portfolio/folio"
+)
+
+const (
+	pdfMargin       = 18.0 // mm
+	pdfLineHeight   = 5.5  // mm
+	pdfMaxImageH    = 70.0 // mm
+	pdfBodyFontSize = 10.5 // pt
+)
+
+// pdfFont is a family embedded in PDFs, whose text is written as UTF-8. The
+// PDF core fonts only cover Latin-1, so any other text would be garbled.
+type pdfFont struct {
+	regular, bold []byte
+}
+
+// There is no serif Go font, so serif text is set in DejaVu Serif, whose
+// licence is in fonts/LICENSE.
+var (
+	//go:embed fonts/DejaVuSerif.ttf
+	serifTTF []byte
+	//go:embed fonts/DejaVuSerif-Bold.ttf
+	serifBoldTTF []byte
+)
+
+// pdfFonts maps a portfolio's Font to the family used for it.
+var pdfFonts = map[string]pdfFont{
+	"sans":  {goregular.TTF, gobold.TTF},
+	"serif": {serifTTF, serifBoldTTF},
+	"mono":  {gomono.TTF, gomonobold.TTF},
+}
+
+// pdfFamily is the name fonts from pdfFonts are added to a PDF under.
+const pdfFamily = "body"
+
+// isPDFLink reports whether link may be made clickable in a PDF, which like
+// html/template in pages allows only http, https and mailto URLs.
+func isPDFLink(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return u.Host != ""
+	case "mailto":
+		return u.Opaque != ""
+	}
+	return false
+}
+
+// renderPDF writes p as a paginated résumé. Project images are only fetched
+// from storage when withImages is set; images that fail to load are skipped.
+func renderPDF(p folio.Portfolio, withImages bool) (*fpdf.Fpdf, error) {
+	font, ok := pdfFonts[p.Font]
+	if !ok {
+		font = pdfFonts[defaultPortfolio.Font]
+	}
+
+	name := strings.TrimSpace(p.FirstName + " " + p.LastName)
+
+	pdf := fpdf.New("P", "mm", "Letter", "")
+	pdf.AddUTF8FontFromBytes(pdfFamily, "", font.regular)
+	pdf.AddUTF8FontFromBytes(pdfFamily, "B", font.bold)
+
+	pdf.SetTitle(name, true)
+	pdf.SetCreator("foliospot.io", true)
+	pdf.SetMargins(pdfMargin, pdfMargin, pdfMargin)
+	pdf.SetAutoPageBreak(true, pdfMargin)
+	pdf.AliasNbPages("")
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-pdfMargin + 4)
+		pdf.SetFont(pdfFamily, "", 8)
+		pdf.SetTextColor(128, 128, 128)
+		pdf.CellFormat(0, 4, fmt.Sprintf("%s — page %d of {nb}", name, pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+
+	width, _ := pdf.GetPageSize()
+	contentWidth := width - 2*pdfMargin
+
+	pdf.SetTextColor(0, 0, 0)
+	pdf.SetFont(pdfFamily, "B", 24)
+	pdf.MultiCell(0, 10, name, "", "L", false)
+
+	if p.Location != "" {
+		pdf.SetFont(pdfFamily, "", pdfBodyFontSize)
+		pdf.SetTextColor(90, 90, 90)
+		pdf.MultiCell(0, pdfLineHeight, p.Location, "", "L", false)
+		pdf.SetTextColor(0, 0, 0)
+	}
+
+	if p.Bio != "" {
+		pdf.Ln(3)
+		pdf.SetFont(pdfFamily, "", pdfBodyFontSize)
+		pdf.MultiCell(0, pdfLineHeight, plainText(p.Bio), "", "L", false)
+	}
+
+	for _, section := range p.Sections {
+		pdf.Ln(6)
+		pdf.SetFont(pdfFamily, "B", 15)
+		pdf.MultiCell(0, 8, section.Title, "", "L", false)
+		y := pdf.GetY()
+		pdf.SetLineWidth(0.4)
+		pdf.Line(pdfMargin, y, pdfMargin+contentWidth, y)
+		pdf.Ln(3)
+
+		for _, project := range section.Projects {
+			// keep a project's heading together with at least a few lines of it
+			if _, pageHeight := pdf.GetPageSize(); pdf.GetY()+4*pdfLineHeight > pageHeight-pdfMargin {
+				pdf.AddPage()
+			}
+
+			pdf.SetFont(pdfFamily, "B", 12)
+			pdf.MultiCell(0, 6.5, project.Name, "", "L", false)
+
+			if isPDFLink(project.Link) {
+				pdf.SetFont(pdfFamily, "U", 9)
+				pdf.SetTextColor(37, 99, 235)
+				pdf.WriteLinkString(pdfLineHeight, project.Link, project.Link)
+				pdf.Ln(pdfLineHeight)
+				pdf.SetTextColor(0, 0, 0)
+			}
+
+			if project.Description != "" {
+				pdf.SetFont(pdfFamily, "", pdfBodyFontSize)
+				pdf.MultiCell(0, pdfLineHeight, plainText(project.Description), "", "L", false)
+			}
+
+			if withImages && project.ImageURL != "" {
+				if err := addPDFImage(pdf, project.ImageURL, contentWidth); err != nil {
+					log.Printf("skipping image %s in PDF: %v\n", project.ImageURL, err)
+				}
+			}
+
+			pdf.Ln(4)
+		}
+	}
+
+	return pdf, pdf.Error()
+}
+
+var pdfImageTypes = map[string]string{
+	"jpeg": "JPG",
+	"png":  "PNG",
+}
+
+func addPDFImage(pdf *fpdf.Fpdf, url string, maxWidth float64) error {
+	data, _, err := loadImageFromS3(url)
+	if err != nil {
+		return err
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	imageType, ok := pdfImageTypes[format]
+	if !ok {
+		return fmt.Errorf("unsupported image format %s", format)
+	}
+
+	opts := fpdf.ImageOptions{ImageType: imageType}
+	pdf.RegisterImageOptionsReader(url, opts, bytes.NewReader(data))
+	if err := pdf.Error(); err != nil {
+		return err
+	}
+
+	w, h := maxWidth, maxWidth*float64(cfg.Height)/float64(cfg.Width)
+	if h > pdfMaxImageH {
+		w, h = w*pdfMaxImageH/h, pdfMaxImageH
+	}
+
+	if _, pageHeight := pdf.GetPageSize(); pdf.GetY()+h > pageHeight-pdfMargin {
+		pdf.AddPage()
+	}
+
+	pdf.Ln(2)
+	pdf.ImageOptions(url, pdfMargin, pdf.GetY(), w, h, true, opts, 0, "")
+	return nil
+}
+
+var unsafeFilenameChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// downloadFilename builds an attachment filename like "jane-doe.pdf" from the
+// portfolio's name, falling back to "portfolio" if it has none.
+func downloadFilename(p folio.Portfolio, ext string) string {
+	name := strings.Trim(unsafeFilenameChars.ReplaceAllString(strings.ToLower(p.FirstName+"-"+p.LastName), "-"), "-")
+	if name == "" {
+		name = "portfolio"
+	}
+	return name + "." + ext
+}
+
+// exportPDFHandler renders the requested portfolio as a PDF résumé. Images are
+// included when the images query parameter is "true".
+func exportPDFHandler(r *http.Request) (any, error) {
+	p, err := requestedPortfolio(r)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf, err := renderPDF(p, r.URL.Query().Get("images") == "true")
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, downloadFilename(p, "pdf")))
+		if err := pdf.Output(w); err != nil {
+			log.Printf("error writing PDF: %v\n", err)
+		}
+	}), nil
+}
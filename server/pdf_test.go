@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/sfnt"
+	"nmilo.ca/portfolio/folio"
+)
+
+func TestIsPDFLink(t *testing.T) {
+	for _, test := range []struct {
+		link string
+		want bool
+	}{
+		{"https://example.com/project", true},
+		{"http://example.com", true},
+		{"mailto:ada@example.com", true},
+		{"HTTPS://example.com", true},
+		{"", false},
+		{"example.com", false},
+		{"https://", false},
+		{"mailto:", false},
+		{"javascript:alert(1)", false},
+		{"file:///etc/passwd", false},
+		{"data:text/html,hi", false},
+	} {
+		if got := isPDFLink(test.link); got != test.want {
+			t.Errorf("isPDFLink(%q) = %v, want %v", test.link, got, test.want)
+		}
+	}
+}
+
+func renderTestPDF(t *testing.T, p folio.Portfolio) string {
+	t.Helper()
+	pdf, err := renderPDF(p, false)
+	if err != nil {
+		t.Fatalf("rendering PDF: %v", err)
+	}
+	pdf.SetCompression(false)
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		t.Fatalf("writing PDF: %v", err)
+	}
+	return buf.String()
+}
+
+func TestRenderPDF(t *testing.T) {
+	for _, font := range append(folio.Fonts, "unknown") {
+		t.Run(font, func(t *testing.T) {
+			out := renderTestPDF(t, folio.Portfolio{
+				FirstName: "Анна",
+				LastName:  "Σμίθ",
+				Location:  "Zürich",
+				Bio:       "Writes **compilers** — and 日本語.",
+				Font:      font,
+				Sections: []folio.Section{{
+					Title: "Projects",
+					Projects: []folio.Project{
+						{Name: "Safe", Description: "A project.", Link: "https://example.com/safe"},
+						{Name: "Mail", Link: "mailto:ada@example.com"},
+						{Name: "Unsafe", Link: "javascript:alert(1)"},
+					},
+				}},
+			})
+
+			if !strings.HasPrefix(out, "%PDF-") {
+				t.Fatalf("output does not start with a PDF header: %q", out[:min(len(out), 20)])
+			}
+			if !strings.Contains(out, "/FontFile2") {
+				t.Error("PDF does not embed its font")
+			}
+			for _, link := range []string{"https://example.com/safe", "mailto:ada@example.com"} {
+				if !strings.Contains(out, "/URI ("+link+")") {
+					t.Errorf("PDF does not link to %s", link)
+				}
+			}
+			if strings.Contains(out, "javascript:") {
+				t.Error("PDF has a javascript: link")
+			}
+		})
+	}
+}
+
+func TestPDFFonts(t *testing.T) {
+	for font, want := range map[string]string{"sans": "Go", "serif": "DejaVu Serif", "mono": "Go Mono"} {
+		for _, ttf := range [][]byte{pdfFonts[font].regular, pdfFonts[font].bold} {
+			f, err := sfnt.Parse(ttf)
+			if err != nil {
+				t.Fatalf("parsing %s font: %v", font, err)
+			}
+			if family, err := f.Name(nil, sfnt.NameIDFamily); err != nil || family != want {
+				t.Errorf("%s font is in family %q, %v, want %q", font, family, err, want)
+			}
+		}
+	}
+}
+
+func TestDownloadFilename(t *testing.T) {
+	for _, test := range []struct {
+		first, last, want string
+	}{
+		{"Ada", "Lovelace", "ada-lovelace.pdf"},
+		{"Ada", "", "ada.pdf"},
+		{"", "", "portfolio.pdf"},
+		{"Ada", "O'Brien", "ada-o-brien.pdf"},
+		{"Jean-Luc", "Picard", "jean-luc-picard.pdf"},
+		{"Анна", "", "portfolio.pdf"},
+	} {
+		p := folio.Portfolio{FirstName: test.first, LastName: test.last}
+		if got := downloadFilename(p, "pdf"); got != test.want {
+			t.Errorf("downloadFilename(%q %q) = %q, want %q", test.first, test.last, got, test.want)
+		}
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	for _, test := range []struct {
+		md, want string
+	}{
+		{"", ""},
+		{"Just text.", "Just text."},
+		{"Some **bold**, _italic_ and `code`.", "Some bold, italic and code."},
+		{"# Heading\n\n> quoted", "Heading\n\nquoted"},
+		{"- one\n* two\n  + three", "• one\n• two\n  • three"},
+		{"See [my site](https://example.com).", "See my site (https://example.com)."},
+		{"[https://example.com](https://example.com)", "https://example.com"},
+		{"![diagram](https://example.com/d.png) below", "diagram below"},
+	} {
+		if got := plainText(test.md); got != test.want {
+			t.Errorf("plainText(%q) = %q, want %q", test.md, got, test.want)
+		}
+	}
+}
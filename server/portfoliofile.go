@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+// findPortfolioFormat picks the format of a request from its format query
+// parameter, falling back to its Content-Type.
+func findPortfolioFormat(r *http.Request) (*folio.Format, error) {
+	name := r.URL.Query().Get("format")
+	if name == "" {
+		ctype := r.Header.Get("Content-Type")
+		for _, f := range folio.Formats {
+			if strings.HasSuffix(ctype, "/"+f.Name) || strings.HasSuffix(ctype, "/x-"+f.Name) {
+				name = f.Name
+			}
+		}
+	}
+
+	f, err := folio.FormatFor(name)
+	if err != nil {
+		return nil, apis.WrapError(err, http.StatusBadRequest)
+	}
+	return f, nil
+}
+
+// exportPortfolioHandler returns the requested portfolio as a YAML or TOML
+// file download, chosen by the format query parameter.
+func exportPortfolioHandler(r *http.Request) (any, error) {
+	f, err := findPortfolioFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := requestedPortfolio(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := f.Encode(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", f.ContentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, downloadFilename(p, f.Extension)))
+		w.Write(data)
+	}), nil
+}
+
+// importPortfolioHandler replaces the logged in user's portfolio with the
+// uploaded YAML or TOML file. If the file is invalid, nothing is saved and
+// the response data lists every problem with the line it was found on.
+func importPortfolioHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := findPortfolioFormat(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, 1024*1024))
+	if err != nil {
+		return nil, apis.WrapError(err, http.StatusRequestEntityTooLarge)
+	}
+
+	p, errs := f.Decode(data)
+	if errs != nil {
+		return nil, apis.NewErrorWithData(fmt.Sprintf("invalid %s portfolio: %s", f.Name, errs[0]), http.StatusUnprocessableEntity, errs)
+	}
+
+	if err := savePortfolio(id, p); err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+	"nmilo.ca/portfolio/folio"
+)
+
+// Every project has a permalink at /p/{id} showing just that project. Project
+// IDs are kept when projects are renamed or moved between sections, so the
+// link keeps working. project_permalinks records whose portfolio each ID is
+// in, and stops anyone from taking an ID another user has. IDs taken out of a
+// portfolio are kept as released rather than forgotten, so that an old link
+// never leads to someone else's project.
+
+// projectPermalinkURL returns the URL of the permalink page of the project
+// with ID id.
+func projectPermalinkURL(id string) string {
+	return frontend + "/p/" + id
+}
+
+// claimProjectIDs gives the projects in p, about to be saved as the portfolio
+// of the user with UUID id, IDs if they have none or another user has or had,
+// and records them as the user's. The user's other IDs are released.
+func claimProjectIDs(tx *sql.Tx, id uuid.UUID, p *folio.Portfolio) error {
+	folio.AssignProjectIDs(p, id.String())
+
+	for _, section := range p.Sections {
+		for _, project := range section.Projects {
+			var owner string
+			err := tx.QueryRow(`SELECT user_uuid FROM project_permalinks WHERE id = ?;`, project.ID).Scan(&owner)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && owner == id.String()) {
+				continue
+			} else if err != nil {
+				return err
+			}
+			folio.ReplaceProjectID(p, project.ID)
+		}
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE project_permalinks SET released = ? WHERE user_uuid = ? AND released IS NULL;
+	`, time.Now().UTC().Format(time.RFC3339), id.String()); err != nil {
+		return err
+	}
+	for _, section := range p.Sections {
+		for _, project := range section.Projects {
+			if _, err := tx.Exec(`
+				INSERT INTO project_permalinks (id, user_uuid) VALUES (?, ?)
+				ON CONFLICT (id) DO UPDATE SET released = NULL WHERE user_uuid = excluded.user_uuid;
+			`, project.ID, id.String()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// assignMissingProjectIDs gives IDs to the projects of portfolios and drafts
+// saved before projects had them.
+func assignMissingProjectIDs() error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type portfolio struct {
+		id string
+		p  folio.Portfolio
+	}
+	load := func(query string) ([]portfolio, error) {
+		rows, err := tx.Query(query)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var ps []portfolio
+		for rows.Next() {
+			var pf portfolio
+			var j []byte
+			if err := rows.Scan(&pf.id, &j); err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(j, &pf.p); err != nil {
+				log.Printf("skipping project IDs of %s, could not parse portfolio: %v\n", pf.id, err)
+				continue
+			}
+			if folio.AssignProjectIDs(&pf.p, pf.id) {
+				ps = append(ps, pf)
+			}
+		}
+		return ps, rows.Err()
+	}
+
+	users, err := load(`SELECT uuid, portfolio FROM users;`)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		id, err := uuid.Parse(u.id)
+		if err != nil {
+			return err
+		}
+		if err := claimProjectIDs(tx, id, &u.p); err != nil {
+			return err
+		}
+		j, err := json.Marshal(u.p)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE users SET portfolio = ? WHERE uuid = ?;`, j, u.id); err != nil {
+			return err
+		}
+	}
+
+	drafts, err := load(`SELECT uuid, portfolio FROM drafts;`)
+	if err != nil {
+		return err
+	}
+	for _, d := range drafts {
+		j, err := json.Marshal(d.p)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`UPDATE drafts SET portfolio = ? WHERE uuid = ?;`, j, d.id); err != nil {
+			return err
+		}
+	}
+
+	if len(users) > 0 || len(drafts) > 0 {
+		log.Printf("gave project IDs to %d portfolios and %d drafts\n", len(users), len(drafts))
+	}
+	return tx.Commit()
+}
+
+// permalinkedProject is a project found by its ID, with the portfolio it is
+// in.
+type permalinkedProject struct {
+	UserID    uuid.UUID
+	Username  string
+	Portfolio folio.Portfolio
+	Section   string
+	Project   folio.Project
+}
+
+// findProject returns the published project with ID id. Returns
+// [sql.ErrNoRows] if there is none.
+func findProject(id string) (permalinkedProject, error) {
+	if !folio.IsProjectID(id) {
+		return permalinkedProject{}, sql.ErrNoRows
+	}
+
+	var found permalinkedProject
+	var idstr string
+	var j []byte
+	err := db.QueryRow(`
+		SELECT users.uuid, users.username, users.portfolio
+		FROM project_permalinks JOIN users ON users.uuid = project_permalinks.user_uuid
+		WHERE project_permalinks.id = ? AND project_permalinks.released IS NULL;
+	`, id).Scan(&idstr, &found.Username, &j)
+	if err != nil {
+		return permalinkedProject{}, err
+	}
+	if found.UserID, err = uuid.Parse(idstr); err != nil {
+		return permalinkedProject{}, err
+	}
+	if err := json.Unmarshal(j, &found.Portfolio); err != nil {
+		return permalinkedProject{}, err
+	}
+
+	for _, section := range found.Portfolio.Sections {
+		for _, project := range section.Projects {
+			if project.ID == id {
+				found.Section = section.Title
+				found.Project = project
+				return found, nil
+			}
+		}
+	}
+	return permalinkedProject{}, sql.ErrNoRows
+}
+
+// projectHandler returns the project given by the id query parameter, with
+// its owner and the colors of their portfolio.
+func projectHandler(r *http.Request) (any, error) {
+	found, err := findProject(r.URL.Query().Get("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apis.StatusNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := requireUnlocked(r, found.Username); err != nil {
+		return nil, err
+	}
+
+	p := found.Portfolio
+	type owner struct {
+		Username     string `json:"username"`
+		FirstName    string `json:"firstName"`
+		LastName     string `json:"lastName"`
+		PortfolioURL string `json:"portfolioURL"`
+	}
+	type theme struct {
+		SidebarColor    string `json:"sidebarColor"`
+		BackgroundColor string `json:"backgroundColor"`
+		ProjectColor    string `json:"projectColor"`
+		AccentColor     string `json:"accentColor"`
+		Font            string `json:"font"`
+	}
+	return struct {
+		Project folio.Project `json:"project"`
+		Section string        `json:"section"`
+		Owner   owner         `json:"owner"`
+		Theme   theme         `json:"theme"`
+	}{
+		Project: found.Project,
+		Section: found.Section,
+		Owner:   owner{found.Username, p.FirstName, p.LastName, portfolioURL(found.Username)},
+		Theme:   theme{p.SidebarColor, p.BackgroundColor, p.ProjectColor, p.AccentColor, p.Font},
+	}, nil
+}
+
+// projectPageHandler serves /p/{id}, the permalink page of a project. The
+// page is rendered by the client; only its head is filled in here, for link
+// unfurlers.
+func projectPageHandler(w http.ResponseWriter, r *http.Request) {
+	found, err := findProject(r.PathValue("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		serveClientIndex(w, http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Printf("error loading project %s: %v\n", r.PathValue("id"), err)
+		serveClientIndex(w, http.StatusInternalServerError)
+		return
+	}
+
+	if protected, err := isProtectedUsername(found.Username); err != nil {
+		log.Printf("error checking protection of %s: %v\n", found.Username, err)
+		serveClientIndex(w, http.StatusInternalServerError)
+		return
+	} else if protected {
+		w.Header().Set("X-Robots-Tag", "noindex")
+		serveClientIndex(w, http.StatusOK)
+		return
+	}
+
+	p := found.Portfolio
+	meta := newPageMeta(found.Username, p, newPortfolioTheme(p))
+	url := projectPermalinkURL(found.Project.ID)
+
+	owner := meta.Title
+	meta.Type = "article"
+	meta.Title = found.Project.Name + " by " + owner
+	if strings.TrimSpace(found.Project.Name) == "" {
+		meta.Title = "A project by " + owner
+	}
+	meta.Description = summarize(plainText(found.Project.Description), metaDescriptionLength)
+	if meta.Description == "" {
+		meta.Description = "A project in " + owner + "'s portfolio on Foliospot"
+	}
+	meta.URL = url
+	meta.FirstName, meta.LastName = "", ""
+	meta.CSS = ""
+	if found.Project.ImageURL != "" {
+		meta.Image = found.Project.ImageURL
+	}
+
+	graph := meta.JSONLD.Graph[:1]
+	for _, node := range meta.JSONLD.Graph[1:] {
+		if node.ID == url {
+			graph = append(graph, node)
+		}
+	}
+	meta.JSONLD.Graph = graph
+
+	var head bytes.Buffer
+	if err := pageTemplates.ExecuteTemplate(&head, "head", meta); err != nil {
+		log.Printf("error rendering project %s: %v\n", found.Project.ID, err)
+		serveClientIndex(w, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(injectPage(head.Bytes(), nil))
+}
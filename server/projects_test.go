@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+func TestProjectPermalink(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	engine := saveTestProjects(t, ada, folio.Project{Name: "Engine"})[0]
+
+	res, err := projectHandler(sessionRequest(t, "GET", "/api/project?id="+engine.ID, ""))
+	if err != nil {
+		t.Fatalf("getting project: %v", err)
+	}
+	// The response's types are local to projectHandler, so it is read back
+	// from its JSON.
+	var found struct {
+		Project folio.Project
+		Section string
+		Owner   struct{ Username string }
+	}
+	if err := json.Unmarshal(Must(json.Marshal(res)), &found); err != nil {
+		t.Fatal(err)
+	}
+	if found.Project.Name != "Engine" || found.Section != "Work" || found.Owner.Username != "ada" {
+		t.Errorf("got project %q in %q by %q, want Engine in Work by ada", found.Project.Name, found.Section, found.Owner.Username)
+	}
+
+	// Other users cannot take the ID by putting it in their portfolio.
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	copied := saveTestProjects(t, grace, engine)[0]
+	if copied.ID == engine.ID {
+		t.Errorf("grace's copy of engine kept its ID %s", engine.ID)
+	}
+	if found, err := findProject(engine.ID); err != nil || found.Username != "ada" {
+		t.Errorf("engine's ID is %s's (%v), want still ada's", found.Username, err)
+	}
+
+	// Projects removed from the portfolio lose their permalink.
+	saveTestProjects(t, ada)
+	if _, err := projectHandler(sessionRequest(t, "GET", "/api/project?id="+engine.ID, "")); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("getting a removed project = %v, want 404", err)
+	}
+
+	// Its ID stays ada's, so a link to it never leads to someone else's
+	// project, and it comes back if ada puts the project back.
+	if copied := saveTestProjects(t, grace, engine)[0]; copied.ID == engine.ID {
+		t.Errorf("grace took the released ID %s", engine.ID)
+	}
+	if restored := saveTestProjects(t, ada, engine)[0]; restored.ID != engine.ID {
+		t.Errorf("engine came back as %s, want its ID %s", restored.ID, engine.ID)
+	}
+	if found, err := findProject(engine.ID); err != nil || found.Username != "ada" {
+		t.Errorf("restored engine's ID is %s's (%v), want ada's", found.Username, err)
+	}
+}
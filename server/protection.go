@@ -0,0 +1,215 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"nmilo.ca/portfolio/apis"
+)
+
+// A portfolio with a password is only shown to its owner and to visitors who
+// unlocked it with the password. Unlocking gives the visitor a grant, which
+// the client sends back as the grant query parameter. Grants are tokens rather
+// than session values so they also work on custom domains, which cannot send
+// the server's cookies.
+
+const (
+	grantPrefix       = "pg_"
+	grantLifetime     = 2 * time.Hour
+	minPasswordLength = 6
+	maxPasswordLength = 72 // bcrypt ignores anything longer
+
+	unlockAttempts = 5
+	unlockWindow   = 15 * time.Minute
+)
+
+// errPortfolioLocked is returned for a protected portfolio until it is
+// unlocked. The client shows a password prompt when it sees it.
+var errPortfolioLocked = apis.NewErrorWithData("this portfolio is password protected", http.StatusUnauthorized, struct {
+	Protected bool `json:"protected"`
+}{true})
+
+// isProtected reports whether the user with UUID id has a portfolio password.
+func isProtected(id uuid.UUID) (bool, error) {
+	var protected bool
+	err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM portfolio_passwords WHERE uuid = ?);`, id.String()).Scan(&protected)
+	return protected, err
+}
+
+// isProtectedUsername is like isProtected, for the user with username.
+func isProtectedUsername(username string) (bool, error) {
+	var protected bool
+	err := db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1 FROM portfolio_passwords JOIN users ON users.uuid = portfolio_passwords.uuid
+			WHERE users.username = ?
+		);
+	`, username).Scan(&protected)
+	return protected, err
+}
+
+// requireUnlocked returns errPortfolioLocked if the portfolio of username is
+// protected and r is neither from its owner nor carries a grant to it.
+func requireUnlocked(r *http.Request, username string) error {
+	var idstr string
+	err := db.QueryRow(`
+		SELECT users.uuid FROM portfolio_passwords JOIN users ON users.uuid = portfolio_passwords.uuid
+		WHERE users.username = ?;
+	`, username).Scan(&idstr)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if id, err := getLogin(r, scopeRead); err == nil && id.String() == idstr {
+		return nil
+	}
+
+	if grant := r.URL.Query().Get("grant"); grant != "" {
+		var valid bool
+		err := db.QueryRow(`
+			SELECT EXISTS(SELECT 1 FROM portfolio_grants WHERE hash = ? AND user_uuid = ? AND expires > ?);
+		`, hashToken(grant), idstr, time.Now().Format(time.RFC3339)).Scan(&valid)
+		if err != nil {
+			return err
+		} else if valid {
+			return nil
+		}
+	}
+
+	return errPortfolioLocked
+}
+
+// unlockLimiter counts unlock attempts per visitor and portfolio, so
+// passwords cannot be guessed quickly. Attempts are counted before the
+// password is checked, so guesses sent at once are all counted.
+var unlockLimiter = newRateLimiter(unlockAttempts, unlockWindow)
+
+// unlockPortfolioHandler checks a visitor's password for a portfolio and gives
+// them a grant to view it if it is right.
+func unlockPortfolioHandler(r *http.Request) (any, error) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	key := remoteIP(r) + " " + req.Username
+	if !unlockLimiter.Allow(key) {
+		return nil, apis.NewError("too many attempts, try again later", http.StatusTooManyRequests)
+	}
+
+	var idstr, hash string
+	err := db.QueryRow(`
+		SELECT users.uuid, portfolio_passwords.hash
+		FROM portfolio_passwords JOIN users ON users.uuid = portfolio_passwords.uuid
+		WHERE users.username = ?;
+	`, req.Username).Scan(&idstr, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apis.NewError("this portfolio is not password protected", http.StatusNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)); err != nil {
+		return nil, apis.NewErrorWithData("wrong password", http.StatusUnauthorized, struct {
+			Protected bool `json:"protected"`
+		}{true})
+	}
+
+	now := time.Now()
+	grant := newRandomToken(grantPrefix)
+	expires := now.Add(grantLifetime).Format(time.RFC3339)
+
+	if _, err := db.Exec(`DELETE FROM portfolio_grants WHERE expires <= ?;`, now.Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(`
+		INSERT INTO portfolio_grants (hash, user_uuid, expires) VALUES (?, ?, ?);
+	`, hashToken(grant), idstr, expires); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Grant   string `json:"grant"`
+		Expires string `json:"expires"`
+	}{grant, expires}, nil
+}
+
+func getPortfolioPasswordHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	protected, err := isProtected(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return struct {
+		Protected bool `json:"protected"`
+	}{protected}, nil
+}
+
+// setPortfolioPasswordHandler sets the logged in user's portfolio password,
+// or removes it if the password is empty. Either way, every grant to the
+// portfolio is revoked.
+func setPortfolioPasswordHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	if _, err := db.Exec(`DELETE FROM portfolio_grants WHERE user_uuid = ?;`, id.String()); err != nil {
+		return nil, err
+	}
+
+	if req.Password == "" {
+		if _, err := db.Exec(`DELETE FROM portfolio_passwords WHERE uuid = ?;`, id.String()); err != nil {
+			return nil, err
+		}
+		refreshDirectory(id)
+		refreshSocialCard(id)
+		return nil, nil
+	}
+
+	if len(req.Password) < minPasswordLength || len(req.Password) > maxPasswordLength {
+		return nil, apis.NewError(fmt.Sprintf("password must be between %d and %d characters", minPasswordLength, maxPasswordLength), http.StatusBadRequest)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO portfolio_passwords (uuid, hash, changed)
+		VALUES (?, ?, ?)
+		ON CONFLICT (uuid) DO UPDATE SET
+			hash = excluded.hash,
+			changed = excluded.changed;
+	`, id.String(), hash, time.Now().Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+
+	refreshDirectory(id)
+	refreshSocialCard(id)
+	return nil, nil
+}
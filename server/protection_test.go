@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const testPassword = "hunter22"
+
+// lockTestPortfolio gives the portfolio of the user with UUID id the password
+// testPassword.
+func lockTestPortfolio(t *testing.T, id string) {
+	t.Helper()
+	if _, err := setPortfolioPasswordHandler(loggedInRequest(t, "POST", "/api/set_portfolio_password", `{"password": "`+testPassword+`"}`, id)); err != nil {
+		t.Fatalf("locking portfolio: %v", err)
+	}
+}
+
+// unlock tries to unlock the portfolio of username with password, returning
+// the grant.
+func unlock(t *testing.T, username, password string) (string, error) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	res, err := unlockPortfolioHandler(sessionRequest(t, "POST", "/api/unlock_portfolio", string(body)))
+	if err != nil {
+		return "", err
+	}
+	return reflect.ValueOf(res).FieldByName("Grant").String(), nil
+}
+
+func withGrant(t *testing.T, grant string) *http.Request {
+	return sessionRequest(t, "GET", "/?grant="+grant, "")
+}
+
+func TestUnlockPortfolio(t *testing.T) {
+	newTestDB(t)
+	unlockLimiter = newRateLimiter(unlockAttempts, unlockWindow)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	createTestUser(t, "grace", testIdentity("grace"))
+	lockTestPortfolio(t, ada)
+
+	if err := requireUnlocked(sessionRequest(t, "GET", "/", ""), "ada"); err != errPortfolioLocked {
+		t.Errorf("requireUnlocked without a grant = %v, want errPortfolioLocked", err)
+	}
+	if err := requireUnlocked(loggedInRequest(t, "GET", "/", "", ada), "ada"); err != nil {
+		t.Errorf("requireUnlocked for the owner = %v", err)
+	}
+	if err := requireUnlocked(sessionRequest(t, "GET", "/", ""), "grace"); err != nil {
+		t.Errorf("requireUnlocked without a password = %v", err)
+	}
+
+	if _, err := unlock(t, "ada", "wrong password"); errorStatus(err) != http.StatusUnauthorized {
+		t.Errorf("unlocking with the wrong password = %v, want 401", err)
+	}
+	if _, err := unlock(t, "grace", testPassword); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("unlocking a portfolio without a password = %v, want 404", err)
+	}
+
+	grant, err := unlock(t, "ada", testPassword)
+	if err != nil {
+		t.Fatalf("unlocking: %v", err)
+	}
+	if err := requireUnlocked(withGrant(t, grant), "ada"); err != nil {
+		t.Errorf("requireUnlocked with a grant = %v", err)
+	}
+	if err := requireUnlocked(withGrant(t, grant+"x"), "ada"); err != errPortfolioLocked {
+		t.Errorf("requireUnlocked with a wrong grant = %v, want errPortfolioLocked", err)
+	}
+
+	// Changing the password revokes grants.
+	lockTestPortfolio(t, ada)
+	if err := requireUnlocked(withGrant(t, grant), "ada"); err != errPortfolioLocked {
+		t.Errorf("requireUnlocked with a revoked grant = %v, want errPortfolioLocked", err)
+	}
+}
+
+func TestUnlockPortfolioExpiredGrant(t *testing.T) {
+	newTestDB(t)
+	unlockLimiter = newRateLimiter(unlockAttempts, unlockWindow)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	lockTestPortfolio(t, ada)
+
+	grant, err := unlock(t, "ada", testPassword)
+	if err != nil {
+		t.Fatalf("unlocking: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE portfolio_grants SET expires = ?;`, time.Now().Add(-time.Minute).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	if err := requireUnlocked(withGrant(t, grant), "ada"); err != errPortfolioLocked {
+		t.Errorf("requireUnlocked with an expired grant = %v, want errPortfolioLocked", err)
+	}
+}
+
+func TestUnlockPortfolioRateLimit(t *testing.T) {
+	newTestDB(t)
+	unlockLimiter = newRateLimiter(unlockAttempts, unlockWindow)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	lockTestPortfolio(t, ada)
+
+	for range unlockAttempts {
+		unlock(t, "ada", "wrong password")
+	}
+	if _, err := unlock(t, "ada", testPassword); errorStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("unlocking after %d wrong passwords = %v, want 429", unlockAttempts, err)
+	}
+}
+
+func TestSitemapLeavesOutLockedPortfolios(t *testing.T) {
+	newTestDB(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	lockTestPortfolio(t, grace)
+
+	w := httptest.NewRecorder()
+	sitemapHandler(w, httptest.NewRequest("GET", "/sitemap.xml", nil))
+	if got, want := sitemapLocs(t, w.Result()), []string{frontend + "/ada"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sitemap lists %q, want %q", got, want)
+	}
+}
+
+func TestDomainSitemapLeavesOutLockedPortfolios(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	verifyTestDomain(t, ada, "ada.example", time.Now())
+	lockTestPortfolio(t, ada)
+
+	wantStatus(t, serveHost("ada.example", "/sitemap.xml"), http.StatusNotFound)
+}
+
+func TestLockedPortfolioHasNoSocialCard(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	id := uuid.MustParse(ada)
+	url, err := socialCardURL(id)
+	if err != nil {
+		t.Fatalf("generating card: %v", err)
+	}
+
+	lockTestPortfolio(t, ada)
+	if _, err := socialCardURL(id); err != errNoCard {
+		t.Errorf("card of a locked portfolio = %v, want errNoCard", err)
+	}
+	var retired bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM retired_social_cards WHERE url = ?);`, url).Scan(&retired); err != nil {
+		t.Fatal(err)
+	}
+	if !retired {
+		t.Error("card of a locked portfolio was not retired")
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// rateLimiter allows up to limit events per key within a sliding window. It is
+// kept in memory, so limits reset when the server restarts.
+type rateLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	events map[string][]time.Time
+
+	// lastSweep is when keys not seen within the window were last forgotten.
+	lastSweep time.Time
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, events: make(map[string][]time.Time)}
+}
+
+// recent returns the events under key within the window, forgetting older
+// ones. l.mu must be held.
+func (l *rateLimiter) recent(key string, now time.Time) []time.Time {
+	var recent []time.Time
+	for _, t := range l.events[key] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	if recent == nil {
+		delete(l.events, key)
+	} else {
+		l.events[key] = recent
+	}
+	return recent
+}
+
+// Allow records an event under key and reports whether it is within the
+// limit. Events over the limit are not recorded. Checking and recording
+// happen together, so concurrent events cannot all slip in under the limit.
+func (l *rateLimiter) Allow(key string) bool {
+	return l.allow(key, time.Now())
+}
+
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	recent := l.recent(key, now)
+	if len(recent) >= l.limit {
+		return false
+	}
+	l.events[key] = append(recent, now)
+
+	// Keys that are never seen again would otherwise be remembered forever.
+	// Sweeping once per window keeps that from costing every event.
+	if now.Sub(l.lastSweep) >= l.window {
+		for k := range l.events {
+			l.recent(k, now)
+		}
+		l.lastSweep = now
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	type event struct {
+		key   string
+		after time.Duration
+		want  bool
+	}
+	for _, test := range []struct {
+		name   string
+		events []event
+	}{
+		{"within the limit", []event{
+			{"a", 0, true},
+			{"a", time.Second, true},
+			{"a", 2 * time.Second, true},
+		}},
+		{"over the limit", []event{
+			{"a", 0, true},
+			{"a", 0, true},
+			{"a", 0, true},
+			{"a", time.Second, false},
+		}},
+		{"keys are separate", []event{
+			{"a", 0, true},
+			{"a", 0, true},
+			{"a", 0, true},
+			{"b", 0, true},
+			{"a", 0, false},
+		}},
+		{"events leave the window", []event{
+			{"a", 0, true},
+			{"a", 0, true},
+			{"a", 30 * time.Minute, true},
+			{"a", 59 * time.Minute, false},
+			{"a", time.Hour, true},
+			{"a", time.Hour, true},
+			{"a", time.Hour, false},
+			{"a", 90 * time.Minute, true},
+		}},
+		{"refused events are not counted", []event{
+			{"a", 0, true},
+			{"a", 0, true},
+			{"a", 0, true},
+			{"a", 30 * time.Minute, false},
+			{"a", 30 * time.Minute, false},
+			{"a", time.Hour, true},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			l := newRateLimiter(3, time.Hour)
+			for i, e := range test.events {
+				if got := l.allow(e.key, start.Add(e.after)); got != e.want {
+					t.Errorf("event %d: allow(%q) after %v = %v, want %v", i, e.key, e.after, got, e.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterForgets(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := newRateLimiter(3, time.Hour)
+	l.allow("a", start)
+	l.allow("b", start.Add(time.Minute))
+	if len(l.events) != 2 {
+		t.Fatalf("limiter has %d keys, want 2", len(l.events))
+	}
+
+	// Sweeps happen at most once per window, so b is kept until the next.
+	l.allow("c", start.Add(time.Hour+30*time.Second))
+	if _, ok := l.events["a"]; ok {
+		t.Error("limiter still has a after its events left the window")
+	}
+	if _, ok := l.events["b"]; !ok {
+		t.Error("limiter forgot b while its event was within the window")
+	}
+
+	l.allow("c", start.Add(90*time.Minute))
+	if _, ok := l.events["b"]; !ok {
+		t.Error("limiter swept again within a window")
+	}
+	l.allow("c", start.Add(2*time.Hour+time.Minute))
+	if _, ok := l.events["b"]; ok {
+		t.Error("limiter still has b after the next sweep")
+	}
+}
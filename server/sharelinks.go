@@ -0,0 +1,194 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+)
+
+// Share links let anyone with the link see a user's draft, so it can be
+// shown to others before it is published. A link can expire and can have a
+// limited number of views.
+
+const shareTokenPrefix = "sh_"
+const maxShareLinksPerUser = 50
+
+type shareLinkInfo struct {
+	ID       string `json:"id"`
+	Created  string `json:"created"`
+	Expires  string `json:"expires,omitempty"`
+	MaxViews int64  `json:"maxViews,omitempty"`
+	Views    int64  `json:"views"`
+}
+
+func shareLinkURL(token string) string {
+	return frontend + "/share/" + token
+}
+
+func listShareLinksHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeRead)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, created, expires, max_views, views
+		FROM share_links
+		WHERE user_uuid = ?
+		ORDER BY created;
+	`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := make([]shareLinkInfo, 0)
+	for rows.Next() {
+		var l shareLinkInfo
+		var expires sql.NullString
+		var maxViews sql.NullInt64
+		if err := rows.Scan(&l.ID, &l.Created, &expires, &maxViews, &l.Views); err != nil {
+			return nil, err
+		}
+		l.Expires = expires.String
+		l.MaxViews = maxViews.Int64
+		links = append(links, l)
+	}
+
+	return links, rows.Err()
+}
+
+// createShareLinkHandler creates a share link to the logged in user's draft.
+// Like tokens, the link is only ever returned here.
+func createShareLinkHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		ExpiresInDays int   `json:"expiresInDays"`
+		MaxViews      int64 `json:"maxViews"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	if req.ExpiresInDays < 0 {
+		return nil, apis.NewError("expiresInDays must not be negative", http.StatusBadRequest)
+	}
+	if req.MaxViews < 0 {
+		return nil, apis.NewError("maxViews must not be negative", http.StatusBadRequest)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM share_links WHERE user_uuid = ?;`, id.String()).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= maxShareLinksPerUser {
+		return nil, apis.NewError(fmt.Sprintf("you can have at most %d share links", maxShareLinksPerUser), http.StatusConflict)
+	}
+
+	now := time.Now()
+	token := newRandomToken(shareTokenPrefix)
+	info := shareLinkInfo{
+		ID:       uuid.New().String(),
+		Created:  now.Format(time.RFC3339),
+		MaxViews: req.MaxViews,
+	}
+
+	var expires sql.NullString
+	if req.ExpiresInDays > 0 {
+		info.Expires = now.AddDate(0, 0, req.ExpiresInDays).Format(time.RFC3339)
+		expires = sql.NullString{String: info.Expires, Valid: true}
+	}
+
+	maxViews := sql.NullInt64{Int64: req.MaxViews, Valid: req.MaxViews > 0}
+
+	if _, err := db.Exec(`
+		INSERT INTO share_links (id, user_uuid, hash, created, expires, max_views, views)
+		VALUES (?, ?, ?, ?, ?, ?, 0);
+	`, info.ID, id.String(), hashToken(token), info.Created, expires, maxViews); err != nil {
+		return nil, err
+	}
+
+	return struct {
+		shareLinkInfo
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}{info, token, shareLinkURL(token)}, nil
+}
+
+func revokeShareLinkHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeWritePortfolio)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	result, err := db.Exec(`DELETE FROM share_links WHERE id = ? AND user_uuid = ?;`, req.ID, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+
+	return nil, nil
+}
+
+// sharedPortfolioHandler returns the draft shared by the token query
+// parameter, counting the view. No login is needed. Links show nothing while
+// their user has no draft, rather than their published portfolio.
+func sharedPortfolioHandler(r *http.Request) (any, error) {
+	token := r.URL.Query().Get("token")
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var idstr string
+	err = tx.QueryRow(`
+		UPDATE share_links SET views = views + 1
+		WHERE hash = ?
+			AND (expires IS NULL OR expires > ?)
+			AND (max_views IS NULL OR views < max_views)
+		RETURNING user_uuid;
+	`, hashToken(token), time.Now().Format(time.RFC3339)).Scan(&idstr)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apis.NewError("this share link is invalid or has expired", http.StatusNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.Parse(idstr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The view is only counted if there is a draft to see.
+	p, err := loadSavedDraft(tx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, apis.NewError("there is no draft to show", http.StatusNotFound)
+	} else if err != nil {
+		return nil, err
+	}
+	return p, tx.Commit()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+// createTestShareLink creates a share link for the user with UUID id from the
+// JSON request body, returning its token.
+func createTestShareLink(t *testing.T, id, body string) string {
+	t.Helper()
+	res, err := createShareLinkHandler(loggedInRequest(t, "POST", "/api/create_share_link", body, id))
+	if err != nil {
+		t.Fatalf("creating share link: %v", err)
+	}
+	return res.(struct {
+		shareLinkInfo
+		Token string `json:"token"`
+		URL   string `json:"url"`
+	}).Token
+}
+
+func viewShareLink(t *testing.T, token string) (folio.Portfolio, error) {
+	t.Helper()
+	p, err := sharedPortfolioHandler(sessionRequest(t, "GET", "/api/shared_portfolio?token="+url.QueryEscape(token), ""))
+	if err != nil {
+		return folio.Portfolio{}, err
+	}
+	return p.(folio.Portfolio), nil
+}
+
+func TestShareLinkShowsDraft(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	draft := defaultPortfolio
+	draft.FirstName = "Ada"
+	if err := putTestDraft(t, ada, draft); err != nil {
+		t.Fatalf("putting draft: %v", err)
+	}
+
+	token := createTestShareLink(t, ada, `{}`)
+	p, err := viewShareLink(t, token)
+	if err != nil {
+		t.Fatalf("viewing share link: %v", err)
+	}
+	if p.FirstName != "Ada" {
+		t.Errorf("share link shows first name %q, want the draft's", p.FirstName)
+	}
+
+	if _, err := viewShareLink(t, token+"x"); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("viewing a wrong token = %v, want 404", err)
+	}
+
+	// Once the draft is published, the link shows nothing rather than the
+	// published portfolio, and the view is not counted.
+	publishTestDraft(t, ada)
+	if _, err := viewShareLink(t, token); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("viewing without a draft = %v, want 404", err)
+	}
+	var views int
+	if err := db.QueryRow(`SELECT views FROM share_links;`).Scan(&views); err != nil {
+		t.Fatal(err)
+	}
+	if views != 1 {
+		t.Errorf("share link has %d views, want 1", views)
+	}
+}
+
+func TestShareLinkExpires(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	if err := putTestDraft(t, ada, defaultPortfolio); err != nil {
+		t.Fatalf("putting draft: %v", err)
+	}
+
+	limited := createTestShareLink(t, ada, `{"maxViews": 2}`)
+	for i := range 2 {
+		if _, err := viewShareLink(t, limited); err != nil {
+			t.Fatalf("view %d: %v", i+1, err)
+		}
+	}
+	if _, err := viewShareLink(t, limited); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("view past maxViews = %v, want 404", err)
+	}
+
+	dated := createTestShareLink(t, ada, `{"expiresInDays": 1}`)
+	if _, err := viewShareLink(t, dated); err != nil {
+		t.Fatalf("viewing before expiry: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE share_links SET expires = ?;`, time.Now().Add(-time.Minute).Format(time.RFC3339)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := viewShareLink(t, dated); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("viewing after expiry = %v, want 404", err)
+	}
+}
+
+func TestRevokeShareLink(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	token := createTestShareLink(t, ada, `{}`)
+
+	var id string
+	if err := db.QueryRow(`SELECT id FROM share_links;`).Scan(&id); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"id": "` + id + `"}`
+	if _, err := revokeShareLinkHandler(loggedInRequest(t, "POST", "/api/revoke_share_link", body, grace)); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("revoking someone else's link = %v, want 404", err)
+	}
+	if _, err := revokeShareLinkHandler(loggedInRequest(t, "POST", "/api/revoke_share_link", body, ada)); err != nil {
+		t.Fatalf("revoking: %v", err)
+	}
+	if _, err := viewShareLink(t, token); errorStatus(err) != http.StatusNotFound {
+		t.Errorf("viewing a revoked link = %v, want 404", err)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"embed"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"nmilo.ca/portfolio/folio"
+)
+
+//go:embed templates
+var templateFS embed.FS
+
+var siteTemplates = template.Must(template.ParseFS(templateFS, "templates/portfolio.html", "templates/site.html"))
+
+var siteBaseCSS = string(Must(templateFS.ReadFile("templates/site.css")))
+
+// portfolioTheme holds the class lists for a rendered portfolio. They are
+// derived the same way as defaultTheme in the client's theme.ts, with the
+// layout utilities replaced by the rules in templates/site.css.
+type portfolioTheme struct {
+	Holder           string
+	Sidebar          string
+	SidebarSeparator string
+	SectionSeparator string
+	Project          string
+
+	// Colors is every Tailwind color used by the classes above.
+	Colors []string
+	// HoverColors is every Tailwind color used with a hover: prefix.
+	HoverColors []string
+}
+
+func textColor(dark bool) string {
+	if dark {
+		return "white"
+	}
+	return "black"
+}
+
+func newPortfolioTheme(p folio.Portfolio) portfolioTheme {
+	font := p.Font
+	if _, ok := pdfFonts[font]; !ok {
+		font = defaultPortfolio.Font
+	}
+
+	sidebarText := textColor(folio.IsDarkColor(p.SidebarColor))
+	mainText := textColor(folio.IsDarkColor(p.BackgroundColor))
+	projectText := textColor(folio.IsDarkColor(p.ProjectColor))
+
+	return portfolioTheme{
+		Holder:           fmt.Sprintf("holder font-%s bg-%s text-%s", font, p.BackgroundColor, mainText),
+		Sidebar:          fmt.Sprintf("sidebar bg-%s text-%s", p.SidebarColor, sidebarText),
+		SidebarSeparator: "sidebar-separator border-" + sidebarText,
+		SectionSeparator: "section-separator border-" + mainText,
+		Project:          fmt.Sprintf("project bg-%s hover:bg-%s text-%s", p.ProjectColor, p.AccentColor, projectText),
+		Colors:           []string{p.BackgroundColor, p.SidebarColor, p.ProjectColor},
+		HoverColors:      []string{p.AccentColor},
+	}
+}
+
+// CSS returns the stylesheet for a portfolio rendered with t: the shared
+// layout rules followed by rules for only the colors t uses.
+func (t portfolioTheme) CSS() string {
+	var b strings.Builder
+	b.WriteString(siteBaseCSS)
+	b.WriteString("\n")
+
+	seen := make(map[string]struct{})
+	write := func(selector, color string) {
+		hex, ok := folio.TailwindColor(color)
+		if !ok {
+			return
+		}
+		if _, ok := seen[selector]; ok {
+			return
+		}
+		seen[selector] = struct{}{}
+		fmt.Fprintf(&b, "%s { background-color: %s; }\n", selector, hex)
+	}
+
+	for _, c := range t.Colors {
+		write(".bg-"+c, c)
+	}
+	for _, c := range t.HoverColors {
+		write(`.hover\:bg-`+c+":hover", c)
+	}
+
+	return b.String()
+}
+
+type portfolioView struct {
+	folio.Portfolio
+	Theme    portfolioTheme
+	BioHTML  template.HTML
+	Sections []sectionView
+}
+
+type sectionView struct {
+	Title    string
+	Projects []projectView
+}
+
+type projectView struct {
+	folio.Project
+	DescriptionHTML template.HTML
+	ImageSrc        string
+	Href            string
+}
+
+// renderMarkdown converts the Markdown used in bios and descriptions to HTML.
+// Raw HTML and dangerous link targets are dropped by goldmark's defaults.
+func renderMarkdown(md string) template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(md), &buf); err != nil {
+		return template.HTML(template.HTMLEscapeString(md))
+	}
+	return template.HTML(buf.String())
+}
+
+// newPortfolioView prepares p for the portfolio templates. imageSrc maps each
+// project's ImageURL to the src used in the page; projects for which it
+// returns "" are rendered without an image. Projects link straight to their
+// Link.
+func newPortfolioView(p folio.Portfolio, imageSrc func(url string) string) portfolioView {
+	view := portfolioView{
+		Portfolio: p,
+		Theme:     newPortfolioTheme(p),
+		BioHTML:   renderMarkdown(p.Bio),
+		Sections:  make([]sectionView, len(p.Sections)),
+	}
+
+	for i, section := range p.Sections {
+		view.Sections[i].Title = section.Title
+		for _, project := range section.Projects {
+			pv := projectView{
+				Project:         project,
+				DescriptionHTML: renderMarkdown(project.Description),
+				Href:            project.Link,
+			}
+			if project.ImageURL != "" {
+				pv.ImageSrc = imageSrc(project.ImageURL)
+			}
+			view.Sections[i].Projects = append(view.Sections[i].Projects, pv)
+		}
+	}
+
+	return view
+}
+
+// staticSite is a portfolio rendered as a standalone website.
+type staticSite struct {
+	Index  []byte
+	CSS    string
+	Images map[string][]byte // path within the site -> contents
+}
+
+// renderStaticSite renders p as a website that needs nothing from foliospot.
+// Images stored in our bucket are copied into the site; any others are left
+// pointing at their original URL.
+func renderStaticSite(p folio.Portfolio) (staticSite, error) {
+	site := staticSite{Images: make(map[string][]byte)}
+	local := make(map[string]string)
+
+	for _, section := range p.Sections {
+		for _, project := range section.Projects {
+			if project.ImageURL == "" {
+				continue
+			}
+			if _, ok := local[project.ImageURL]; ok {
+				continue
+			}
+
+			data, ctype, err := loadImageFromS3(project.ImageURL)
+			if err != nil {
+				log.Printf("not copying image %s into static site: %v\n", project.ImageURL, err)
+				local[project.ImageURL] = project.ImageURL
+				continue
+			}
+
+			name := path.Base(project.ImageURL)
+			if exts, _ := mime.ExtensionsByType(ctype); path.Ext(name) == "" && len(exts) > 0 {
+				name += exts[0]
+			}
+			name = "images/" + name
+
+			site.Images[name] = data
+			local[project.ImageURL] = name
+		}
+	}
+
+	view := newPortfolioView(p, func(url string) string {
+		return local[url]
+	})
+
+	var buf bytes.Buffer
+	if err := siteTemplates.ExecuteTemplate(&buf, "site.html", view); err != nil {
+		return staticSite{}, err
+	}
+
+	site.Index = buf.Bytes()
+	site.CSS = view.Theme.CSS()
+	return site, nil
+}
+
+// WriteZip writes the site as a ZIP archive with index.html at its root.
+func (s staticSite) WriteZip(w *zip.Writer) error {
+	names := make([]string, 0, len(s.Images))
+	for name := range s.Images {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := writeZipFile(w, "index.html", s.Index); err != nil {
+		return err
+	}
+	if err := writeZipFile(w, "style.css", []byte(s.CSS)); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := writeZipFile(w, name, s.Images[name]); err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
+}
+
+func writeZipFile(w *zip.Writer, name string, data []byte) error {
+	fw, err := w.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+// exportSiteHandler renders the requested portfolio as a static website and
+// streams it back as a ZIP archive.
+func exportSiteHandler(r *http.Request) (any, error) {
+	p, err := requestedPortfolio(r)
+	if err != nil {
+		return nil, err
+	}
+
+	site, err := renderStaticSite(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, downloadFilename(p, "zip")))
+		if err := site.WriteZip(zip.NewWriter(w)); err != nil {
+			log.Printf("error writing static site: %v\n", err)
+		}
+	}), nil
+}
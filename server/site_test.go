@@ -0,0 +1,92 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+func TestRenderStaticSite(t *testing.T) {
+	p := defaultPortfolio
+	p.FirstName = "Ada"
+	p.LastName = "<Lovelace>"
+	p.SidebarColor = "slate-900"
+	p.Bio = "Wrote the first **program**.\n\n<script>alert(1)</script>\n\n[evil](javascript:alert(1))"
+	p.Sections = []folio.Section{{
+		Title: "Projects",
+		Projects: []folio.Project{
+			{Name: "Engine", Link: "https://example.com/engine", ImageURL: "https://elsewhere.example/engine.png"},
+		},
+	}}
+
+	site, err := renderStaticSite(p)
+	if err != nil {
+		t.Fatalf("rendering: %v", err)
+	}
+	index := string(site.Index)
+
+	for _, want := range []string{
+		"<title>Ada &lt;Lovelace&gt;</title>",
+		"<strong>program</strong>",
+		`<a href="https://example.com/engine">Engine</a>`,
+		`src="https://elsewhere.example/engine.png"`,
+		"sidebar bg-slate-900 text-white",
+	} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index.html does not contain %q", want)
+		}
+	}
+	for _, unwanted := range []string{"<script>", "javascript:"} {
+		if strings.Contains(index, unwanted) {
+			t.Errorf("index.html contains %q", unwanted)
+		}
+	}
+
+	for _, want := range []string{".bg-slate-900 { background-color: #0f172a; }", `.hover\:bg-slate-200:hover`} {
+		if !strings.Contains(site.CSS, want) {
+			t.Errorf("style.css does not contain %q", want)
+		}
+	}
+}
+
+func TestStaticSiteZip(t *testing.T) {
+	site := staticSite{
+		Index:  []byte("<html></html>"),
+		CSS:    "body {}",
+		Images: map[string][]byte{"images/b.png": []byte("b"), "images/a.png": []byte("a")},
+	}
+
+	var buf bytes.Buffer
+	if err := site.WriteZip(zip.NewWriter(&buf)); err != nil {
+		t.Fatalf("writing zip: %v", err)
+	}
+
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("reading zip: %v", err)
+	}
+	files := make(map[string]string)
+	var names []string
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		names = append(names, f.Name)
+		files[f.Name] = string(data)
+	}
+
+	if want := []string{"index.html", "style.css", "images/a.png", "images/b.png"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("zip has %q, want %q", names, want)
+	}
+	if files["index.html"] != "<html></html>" || files["style.css"] != "body {}" || files["images/a.png"] != "a" {
+		t.Errorf("zip has the wrong contents: %q", files)
+	}
+}
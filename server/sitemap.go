@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The sitemap lists every published portfolio without a password. Sitemaps
+// are limited to 50,000 URLs, so past that /sitemap.xml becomes an index of
+// numbered sitemaps under /sitemaps/. Search engines ignore URLs on other
+// hosts than the sitemap's, so portfolios on custom domains are left out and
+// listed by a sitemap on their domain instead.
+
+const sitemapSize = 50000
+
+// sitemapUsers is the condition on users for being in the sitemap.
+const sitemapUsers = `
+	uuid NOT IN (SELECT uuid FROM portfolio_passwords)
+	AND uuid NOT IN (SELECT user_uuid FROM custom_domains WHERE verified IS NOT NULL)
+`
+
+const sitemapXMLNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Sitemaps []sitemapURL `xml:"sitemap"`
+}
+
+func writeSitemap(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("error writing sitemap: %v\n", err)
+	}
+}
+
+// sitemapPage returns the URLs in the sitemap numbered page, starting at 1.
+func sitemapPage(page int) ([]sitemapURL, error) {
+	rows, err := db.Query(`
+		SELECT username, last_saved FROM users
+		WHERE `+sitemapUsers+`
+		ORDER BY username
+		LIMIT ? OFFSET ?;
+	`, sitemapSize, (page-1)*sitemapSize)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]sitemapURL, 0)
+	for rows.Next() {
+		var username, lastSaved string
+		if err := rows.Scan(&username, &lastSaved); err != nil {
+			return nil, err
+		}
+		urls = append(urls, sitemapURL{Loc: frontend + "/" + username, LastMod: lastSaved})
+	}
+	return urls, rows.Err()
+}
+
+// sitemapHandler serves /sitemap.xml, which is the only sitemap until there
+// are too many users for one.
+func sitemapHandler(w http.ResponseWriter, r *http.Request) {
+	var count int
+	if err := db.QueryRow(`
+		SELECT COUNT(*) FROM users WHERE ` + sitemapUsers + `;
+	`).Scan(&count); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if count <= sitemapSize {
+		urls, err := sitemapPage(1)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSitemap(w, sitemapURLSet{XMLNS: sitemapXMLNS, URLs: urls})
+		return
+	}
+
+	index := sitemapIndex{XMLNS: sitemapXMLNS}
+	for page := 1; (page-1)*sitemapSize < count; page++ {
+		index.Sitemaps = append(index.Sitemaps, sitemapURL{Loc: fmt.Sprintf("%s/sitemaps/%d.xml", frontend, page)})
+	}
+	writeSitemap(w, index)
+}
+
+// sitemapPageHandler serves the numbered sitemaps listed in the sitemap
+// index.
+func sitemapPageHandler(w http.ResponseWriter, r *http.Request) {
+	page, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("page"), ".xml"))
+	if err != nil || page < 1 {
+		http.NotFound(w, r)
+		return
+	}
+
+	urls, err := sitemapPage(page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if len(urls) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeSitemap(w, sitemapURLSet{XMLNS: sitemapXMLNS, URLs: urls})
+}
+
+// serveDomainSitemap serves /sitemap.xml and /robots.txt on host, a custom
+// domain or subdomain of username. Only the portfolio's canonical custom
+// domain has a sitemap, and only when the portfolio has no password.
+func serveDomainSitemap(w http.ResponseWriter, r *http.Request, host, username string) {
+	var lastSaved string
+	var locked bool
+	if err := db.QueryRow(`
+		SELECT last_saved, uuid IN (SELECT uuid FROM portfolio_passwords) FROM users WHERE username = ?;
+	`, username).Scan(&lastSaved, &locked); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	loc := "https://" + host + "/"
+	listed := !locked && portfolioURL(username) == loc
+
+	if r.URL.Path == "/robots.txt" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprint(w, "User-agent: *\nDisallow:\n")
+		if listed {
+			fmt.Fprintf(w, "\nSitemap: %ssitemap.xml\n", loc)
+		}
+		return
+	}
+
+	if !listed {
+		http.NotFound(w, r)
+		return
+	}
+	writeSitemap(w, sitemapURLSet{XMLNS: sitemapXMLNS, URLs: []sitemapURL{{Loc: loc, LastMod: lastSaved}}})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyTestDomain gives the user with UUID id the verified custom domain
+// domain, verified at verified.
+func verifyTestDomain(t *testing.T, id, domain string, verified time.Time) {
+	t.Helper()
+	if _, err := db.Exec(`
+		INSERT INTO custom_domains (domain, user_uuid, token, created, verified) VALUES (?, ?, 'token', ?, ?);
+	`, domain, id, verified.Format(time.RFC3339), verified.Format(time.RFC3339)); err != nil {
+		t.Fatalf("adding domain %s: %v", domain, err)
+	}
+}
+
+func sitemapLocs(t *testing.T, res *http.Response) []string {
+	t.Helper()
+	var set sitemapURLSet
+	if err := xml.NewDecoder(res.Body).Decode(&set); err != nil {
+		t.Fatalf("decoding sitemap: %v", err)
+	}
+	var locs []string
+	for _, u := range set.URLs {
+		locs = append(locs, u.Loc)
+	}
+	return locs
+}
+
+func serveHost(host, path string) *http.Response {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Host = host
+	w := httptest.NewRecorder()
+	portfolioHostHandler(http.NotFoundHandler()).ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestSitemap(t *testing.T) {
+	newTestDB(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	verifyTestDomain(t, grace, "grace.example", time.Now())
+
+	w := httptest.NewRecorder()
+	sitemapHandler(w, httptest.NewRequest("GET", "/sitemap.xml", nil))
+	got := sitemapLocs(t, w.Result())
+	if want := []string{frontend + "/ada"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sitemap lists %q, want %q", got, want)
+	}
+}
+
+func TestDomainSitemap(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	verifyTestDomain(t, ada, "ada.example", time.Now().Add(-time.Hour))
+	verifyTestDomain(t, ada, "lovelace.example", time.Now())
+
+	res := serveHost("ada.example", "/sitemap.xml")
+	wantStatus(t, res, http.StatusOK)
+	if got, want := sitemapLocs(t, res), []string{"https://ada.example/"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sitemap on ada.example lists %q, want %q", got, want)
+	}
+	wantStatus(t, serveHost("lovelace.example", "/sitemap.xml"), http.StatusNotFound)
+
+	for _, test := range []struct {
+		host    string
+		sitemap bool
+	}{
+		{"ada.example", true},
+		{"lovelace.example", false},
+	} {
+		res := serveHost(test.host, "/robots.txt")
+		wantStatus(t, res, http.StatusOK)
+		body, _ := io.ReadAll(res.Body)
+		if got := strings.Contains(string(body), "Sitemap: https://ada.example/sitemap.xml"); got != test.sitemap {
+			t.Errorf("robots.txt on %s lists the sitemap: %v, want %v", test.host, got, test.sitemap)
+		}
+	}
+}
+
+func TestPortfolioURL(t *testing.T) {
+	newTestDB(t)
+	createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	verifyTestDomain(t, grace, "hopper.example", time.Now())
+	verifyTestDomain(t, grace, "grace.example", time.Now().Add(-time.Hour))
+	if _, err := db.Exec(`
+		INSERT INTO custom_domains (domain, user_uuid, token, created) VALUES ('pending.example', ?, 'token', '2024-01-01T00:00:00Z');
+	`, grace); err != nil {
+		t.Fatal(err)
+	}
+
+	for username, want := range map[string]string{
+		"ada":   frontend + "/ada",
+		"grace": "https://grace.example/",
+	} {
+		if got := portfolioURL(username); got != want {
+			t.Errorf("portfolioURL(%q) = %q, want %q", username, got, want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+const githubAPI = "https://api.github.com"
+
+// NewGitHub returns a provider logging in with GitHub accounts. GitHub does
+// not speak OpenID Connect, so users are looked up through its API instead
+// of an ID token. c.Issuer is ignored.
+func NewGitHub(c Config) *Provider {
+	return newProvider(c, []string{"read:user", "user:email"}, githubBackend{api: githubAPI})
+}
+
+type githubBackend struct {
+	// api is where GitHub's REST API is.
+	api string
+}
+
+func (githubBackend) endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	return github.Endpoint, nil
+}
+
+func (b githubBackend) identify(ctx context.Context, config *oauth2.Config, token *oauth2.Token, nonce string) (Identity, error) {
+	api := config.Client(ctx, token)
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := b.get(api, "/user", &user); err != nil {
+		return Identity{}, err
+	}
+
+	// The email on the profile may be unverified or hidden, so the primary
+	// one is looked up instead.
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := b.get(api, "/user/emails", &emails); err != nil {
+		return Identity{}, err
+	}
+
+	id := Identity{Subject: strconv.FormatInt(user.ID, 10), Name: user.Name}
+	if id.Name == "" {
+		id.Name = user.Login
+	}
+	for _, email := range emails {
+		if email.Primary {
+			id.Email = email.Email
+			id.EmailVerified = email.Verified
+		}
+	}
+	return id, nil
+}
+
+func (b githubBackend) get(api *http.Client, path string, v any) error {
+	req, err := http.NewRequest("GET", b.api+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, err := api.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("GitHub API %s: %s", path, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
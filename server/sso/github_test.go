@@ -0,0 +1,95 @@
+package sso
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeGitHub serves user and emails as GitHub's API would to a client with
+// the access token "token", returning a backend using it.
+func fakeGitHub(t *testing.T, user any, emails any) githubBackend {
+	t.Helper()
+	mux := http.NewServeMux()
+	serve := func(path string, v any) {
+		mux.HandleFunc("GET "+path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				http.Error(w, "bad credentials", http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(v)
+		})
+	}
+	serve("/user", user)
+	serve("/user/emails", emails)
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return githubBackend{api: srv.URL}
+}
+
+func identifyGitHub(t *testing.T, b githubBackend) Identity {
+	t.Helper()
+	id, err := b.identify(context.Background(), &oauth2.Config{}, &oauth2.Token{AccessToken: "token"}, "")
+	if err != nil {
+		t.Fatalf("identify: %v", err)
+	}
+	return id
+}
+
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+func TestGitHubPrimaryEmail(t *testing.T) {
+	b := fakeGitHub(t,
+		map[string]any{"id": 583231, "login": "octocat", "name": "The Octocat"},
+		[]githubEmail{
+			{Email: "old@example.com", Verified: true},
+			{Email: "octocat@example.com", Primary: true, Verified: true},
+			{Email: "work@example.com"},
+		})
+
+	want := Identity{Subject: "583231", Email: "octocat@example.com", EmailVerified: true, Name: "The Octocat"}
+	if id := identifyGitHub(t, b); id != want {
+		t.Errorf("identify = %+v, want %+v", id, want)
+	}
+}
+
+func TestGitHubUnverifiedPrimaryEmail(t *testing.T) {
+	// A verified secondary email does not stand in for the primary one.
+	b := fakeGitHub(t,
+		map[string]any{"id": 1, "login": "octocat"},
+		[]githubEmail{
+			{Email: "octocat@example.com", Primary: true},
+			{Email: "other@example.com", Verified: true},
+		})
+
+	id := identifyGitHub(t, b)
+	if id.Email != "octocat@example.com" || id.EmailVerified {
+		t.Errorf("identify = %+v, want the primary email, unverified", id)
+	}
+}
+
+func TestGitHubNameFallsBackToLogin(t *testing.T) {
+	b := fakeGitHub(t, map[string]any{"id": 1, "login": "octocat"}, []githubEmail{})
+
+	if id := identifyGitHub(t, b); id.Name != "octocat" {
+		t.Errorf("identify has Name %q, want the login %q", id.Name, "octocat")
+	}
+}
+
+func TestGitHubAPIError(t *testing.T) {
+	b := fakeGitHub(t, map[string]any{"id": 1}, []githubEmail{})
+
+	_, err := b.identify(context.Background(), &oauth2.Config{}, &oauth2.Token{AccessToken: "revoked"}, "")
+	if err == nil {
+		t.Error("identify succeeded with a token GitHub rejects")
+	}
+}
@@ -0,0 +1,93 @@
+package sso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// NewOIDC returns a provider for the OpenID Connect issuer c.Issuer. The
+// issuer's configuration is discovered the first time it is needed, and
+// again after a failed attempt. ID tokens are checked against the keys the
+// issuer publishes.
+func NewOIDC(c Config) *Provider {
+	return newProvider(c, []string{oidc.ScopeOpenID, "email", "profile"}, &oidcBackend{
+		issuer:   c.Issuer,
+		clientID: c.ClientID,
+	})
+}
+
+type oidcBackend struct {
+	issuer   string
+	clientID string
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+}
+
+// discover returns the issuer's configuration, fetching it if it has not
+// been yet.
+func (b *oidcBackend) discover(ctx context.Context) (*oidc.Provider, *oidc.IDTokenVerifier, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.provider == nil {
+		// The provider keeps its context to fetch keys later, so it must
+		// outlive the request that happens to discover it.
+		provider, err := oidc.NewProvider(oidc.ClientContext(context.WithoutCancel(ctx), client), b.issuer)
+		if err != nil {
+			return nil, nil, fmt.Errorf("discovering %s: %w", b.issuer, err)
+		}
+		b.provider = provider
+		b.verifier = provider.Verifier(&oidc.Config{ClientID: b.clientID})
+	}
+	return b.provider, b.verifier, nil
+}
+
+func (b *oidcBackend) endpoint(ctx context.Context) (oauth2.Endpoint, error) {
+	provider, _, err := b.discover(ctx)
+	if err != nil {
+		return oauth2.Endpoint{}, err
+	}
+	return provider.Endpoint(), nil
+}
+
+func (b *oidcBackend) identify(ctx context.Context, config *oauth2.Config, token *oauth2.Token, nonce string) (Identity, error) {
+	_, verifier, err := b.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return Identity{}, errors.New("no ID token in token response")
+	}
+	idToken, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return Identity{}, err
+	}
+	if idToken.Nonce != nonce {
+		return Identity{}, errors.New("ID token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return Identity{}, err
+	}
+
+	return Identity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
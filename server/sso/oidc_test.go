@@ -0,0 +1,190 @@
+package sso_test
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+	"nmilo.ca/portfolio/sso"
+	"nmilo.ca/portfolio/sso/ssotest"
+)
+
+const redirectURL = "http://localhost/auth/test/callback"
+
+// noRedirects is a client that stops at redirects, so that the code the
+// issuer sends back can be read from them.
+var noRedirects = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func newProvider(t *testing.T) (*ssotest.Issuer, *sso.Provider) {
+	t.Helper()
+	issuer := ssotest.NewIssuer()
+	t.Cleanup(issuer.Close)
+	return issuer, sso.NewOIDC(issuer.Config("test", redirectURL))
+}
+
+// authorize logs in at p's issuer with verifier and nonce, returning the code
+// it sends back.
+func authorize(t *testing.T, p *sso.Provider, verifier, nonce string) string {
+	t.Helper()
+	authURL, err := p.AuthCodeURL(context.Background(), "state", verifier, nonce)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+
+	res, err := noRedirects.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorizing: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("authorizing: got status %d, want %d", res.StatusCode, http.StatusFound)
+	}
+
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("parsing redirect: %v", err)
+	}
+	if got := back.Query().Get("state"); got != "state" {
+		t.Fatalf("redirect has state %q, want %q", got, "state")
+	}
+	return back.Query().Get("code")
+}
+
+func TestDiscovery(t *testing.T) {
+	issuer, p := newProvider(t)
+
+	authURL, err := p.AuthCodeURL(context.Background(), "state", oauth2.GenerateVerifier(), "nonce")
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	if !strings.HasPrefix(authURL, issuer.URL+"/authorize?") {
+		t.Errorf("AuthCodeURL = %q, want the discovered endpoint %s/authorize", authURL, issuer.URL)
+	}
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parsing AuthCodeURL: %v", err)
+	}
+	q := parsed.Query()
+	for key, want := range map[string]string{
+		"client_id":             "ssotest-client",
+		"redirect_uri":          redirectURL,
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge_method": "S256",
+	} {
+		if got := q.Get(key); got != want {
+			t.Errorf("AuthCodeURL has %s=%q, want %q", key, got, want)
+		}
+	}
+	if !strings.Contains(q.Get("scope"), "openid") {
+		t.Errorf("AuthCodeURL has scope %q, want openid in it", q.Get("scope"))
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := ssotest.NewIssuer()
+	defer issuer.Close()
+
+	// The issuer says it is issuer.URL, which is not where it was looked
+	// up, so its configuration is not trusted.
+	config := issuer.Config("test", redirectURL)
+	config.Issuer += "/other"
+	p := sso.NewOIDC(config)
+	if _, err := p.AuthCodeURL(context.Background(), "state", oauth2.GenerateVerifier(), "nonce"); err == nil {
+		t.Error("AuthCodeURL succeeded with an issuer serving another issuer's configuration")
+	}
+}
+
+func TestDiscoveryUnreachable(t *testing.T) {
+	issuer := ssotest.NewIssuer()
+	config := issuer.Config("test", redirectURL)
+	issuer.Close()
+
+	p := sso.NewOIDC(config)
+	if _, err := p.AuthCodeURL(context.Background(), "state", oauth2.GenerateVerifier(), "nonce"); err == nil {
+		t.Error("AuthCodeURL succeeded with the issuer down")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer, p := newProvider(t)
+	issuer.LogInAs(ssotest.User{Subject: "42", Email: "ada@example.com", EmailVerified: true, Name: "Ada"})
+
+	verifier := oauth2.GenerateVerifier()
+	code := authorize(t, p, verifier, "nonce")
+	identity, err := p.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+
+	want := sso.Identity{Provider: "test", Subject: "42", Email: "ada@example.com", EmailVerified: true, Name: "Ada"}
+	if identity != want {
+		t.Errorf("Exchange = %+v, want %+v", identity, want)
+	}
+}
+
+func TestExchangeUnverifiedEmail(t *testing.T) {
+	issuer, p := newProvider(t)
+	issuer.LogInAs(ssotest.User{Subject: "42", Email: "ada@example.com"})
+
+	verifier := oauth2.GenerateVerifier()
+	code := authorize(t, p, verifier, "nonce")
+	identity, err := p.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if identity.EmailVerified {
+		t.Error("Exchange says an unverified email is verified")
+	}
+}
+
+func TestExchangeNonceMismatch(t *testing.T) {
+	_, p := newProvider(t)
+
+	verifier := oauth2.GenerateVerifier()
+	code := authorize(t, p, verifier, "nonce")
+	if _, err := p.Exchange(context.Background(), code, verifier, "other-nonce"); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Errorf("Exchange with the wrong nonce = %v, want a nonce error", err)
+	}
+}
+
+func TestExchangeForgedSignature(t *testing.T) {
+	issuer, p := newProvider(t)
+	issuer.ForgeSignatures()
+
+	verifier := oauth2.GenerateVerifier()
+	code := authorize(t, p, verifier, "nonce")
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce"); err == nil {
+		t.Error("Exchange accepted an ID token not signed with the issuer's published key")
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	_, p := newProvider(t)
+
+	code := authorize(t, p, oauth2.GenerateVerifier(), "nonce")
+	if _, err := p.Exchange(context.Background(), code, oauth2.GenerateVerifier(), "nonce"); err == nil {
+		t.Error("Exchange succeeded with a verifier the login was not started with")
+	}
+}
+
+func TestExchangeCodeReused(t *testing.T) {
+	_, p := newProvider(t)
+
+	verifier := oauth2.GenerateVerifier()
+	code := authorize(t, p, verifier, "nonce")
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce"); err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if _, err := p.Exchange(context.Background(), code, verifier, "nonce"); err == nil {
+		t.Error("Exchange succeeded with a code that was already used")
+	}
+}
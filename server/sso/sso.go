@@ -0,0 +1,148 @@
+// Package sso logs users in through outside identity providers: any OpenID
+// Connect issuer, set up through discovery, and GitHub, which only speaks
+// plain OAuth 2.0. Every provider is used the same way: send the user to
+// AuthCodeURL, then trade the code they come back with for their Identity.
+package sso
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Identity is who a provider says logged in.
+type Identity struct {
+	// Provider is the Name of the provider.
+	Provider string
+	// Subject identifies the user to the provider. Unlike their email, it
+	// never changes.
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Config describes a provider.
+type Config struct {
+	// Name identifies the provider in URLs, such as /auth/{name}/login.
+	Name string
+	// Title is shown to users, as in "Log in with {title}".
+	Title string
+	// Issuer is the URL of an OpenID Connect issuer. Its configuration is
+	// discovered from Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes are requested in addition to those a provider always needs.
+	Scopes []string
+}
+
+// backend is what differs between kinds of providers.
+type backend interface {
+	// endpoint returns the provider's OAuth 2.0 endpoints.
+	endpoint(ctx context.Context) (oauth2.Endpoint, error)
+	// identify returns who token was given to. Nonce is the one sent with
+	// the request that gave it.
+	identify(ctx context.Context, config *oauth2.Config, token *oauth2.Token, nonce string) (Identity, error)
+}
+
+// Provider is an identity provider users can log in through.
+type Provider struct {
+	Name  string
+	Title string
+
+	config  oauth2.Config
+	backend backend
+}
+
+// client is used for every request to providers.
+var client = &http.Client{Timeout: 10 * time.Second}
+
+func newProvider(c Config, scopes []string, b backend) *Provider {
+	return &Provider{
+		Name:  c.Name,
+		Title: c.Title,
+		config: oauth2.Config{
+			ClientID:     c.ClientID,
+			ClientSecret: c.ClientSecret,
+			RedirectURL:  c.RedirectURL,
+			Scopes:       append(scopes, c.Scopes...),
+		},
+		backend: b,
+	}
+}
+
+// AuthCodeURL returns the URL to send the user to to log in. State, the PKCE
+// verifier and nonce must be kept to check the user who comes back.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, verifier, nonce string) (string, error) {
+	config, err := p.oauth2Config(ctx)
+	if err != nil {
+		return "", err
+	}
+	return config.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Exchange returns who logged in, given the code they came back with and the
+// verifier and nonce their login was started with.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	config, err := p.oauth2Config(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, client)
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return Identity{}, err
+	}
+
+	id, err := p.backend.identify(ctx, config, token, nonce)
+	if err != nil {
+		return Identity{}, err
+	}
+	id.Provider = p.Name
+	return id, nil
+}
+
+func (p *Provider) oauth2Config(ctx context.Context) (*oauth2.Config, error) {
+	endpoint, err := p.backend.endpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	config := p.config
+	config.Endpoint = endpoint
+	return &config, nil
+}
+
+// Registry is the set of providers users can log in through.
+type Registry struct {
+	providers map[string]*Provider
+}
+
+// Add adds p to r, replacing any provider with the same name.
+func (r *Registry) Add(p *Provider) {
+	if r.providers == nil {
+		r.providers = make(map[string]*Provider)
+	}
+	r.providers[p.Name] = p
+}
+
+// Get returns the provider named name.
+func (r *Registry) Get(name string) (*Provider, bool) {
+	p, ok := r.providers[name]
+	return p, ok
+}
+
+// All returns every provider in r, sorted by title.
+func (r *Registry) All() []*Provider {
+	all := make([]*Provider, 0, len(r.providers))
+	for _, p := range r.providers {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Title < all[j].Title })
+	return all
+}
@@ -0,0 +1,255 @@
+// Package ssotest runs a fake OpenID Connect issuer, so that logging in can be
+// tried without a real identity provider. The issuer approves every login
+// without asking, as whichever user it was last told to.
+package ssotest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"nmilo.ca/portfolio/sso"
+)
+
+const (
+	clientID     = "ssotest-client"
+	clientSecret = "ssotest-secret"
+	keyID        = "ssotest-key"
+)
+
+// User is who the issuer says logs in.
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// grant is a login that was approved and whose code has not been traded for
+// tokens yet.
+type grant struct {
+	user          User
+	redirectURI   string
+	codeChallenge string
+	nonce         string
+}
+
+// Issuer is a fake OpenID Connect issuer listening on a local port.
+type Issuer struct {
+	// URL is the issuer's URL, to be discovered from.
+	URL string
+
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	signer jose.Signer
+	// forger signs with a key that is not published, under the same key ID.
+	forger jose.Signer
+
+	mu     sync.Mutex
+	user   User
+	forge  bool
+	grants map[string]grant
+}
+
+// NewIssuer starts an issuer. It logs everyone in as a user with a verified
+// email until told otherwise by LogInAs.
+func NewIssuer() *Issuer {
+	key, signer := newSigner()
+	_, forger := newSigner()
+
+	i := &Issuer{
+		key:    key,
+		signer: signer,
+		forger: forger,
+		user:   User{Subject: "1", Email: "user@example.com", EmailVerified: true, Name: "Test User"},
+		grants: make(map[string]grant),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", i.discovery)
+	mux.HandleFunc("GET /keys", i.keys)
+	mux.HandleFunc("GET /authorize", i.authorize)
+	mux.HandleFunc("POST /token", i.token)
+	i.server = httptest.NewServer(mux)
+	i.URL = i.server.URL
+	return i
+}
+
+// newSigner returns a new key and a signer of ID tokens with it.
+func newSigner() (*rsa.PrivateKey, jose.Signer) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", keyID),
+	)
+	if err != nil {
+		panic(err)
+	}
+	return key, signer
+}
+
+// Close stops the issuer.
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// LogInAs makes every later login be as u.
+func (i *Issuer) LogInAs(u User) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.user = u
+}
+
+// ForgeSignatures makes later ID tokens be signed with a key the issuer does
+// not publish, as forged ones would be.
+func (i *Issuer) ForgeSignatures() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.forge = true
+}
+
+// Config returns the configuration of a provider named name logging in
+// through i, redirecting back to redirectURL.
+func (i *Issuer) Config(name, redirectURL string) sso.Config {
+	return sso.Config{
+		Name:         name,
+		Title:        "Test issuer",
+		Issuer:       i.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+	}
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (i *Issuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]any{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *Issuer) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{{
+		Key:       &i.key.PublicKey,
+		KeyID:     keyID,
+		Algorithm: string(jose.RS256),
+		Use:       "sig",
+	}}})
+}
+
+// authorize approves the login at once and sends the user back with a code.
+func (i *Issuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != clientID || q.Get("response_type") != "code" {
+		http.Error(w, "unknown client or response type", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE is required", http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "bad redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	i.mu.Lock()
+	i.grants[code] = grant{
+		user:          i.user,
+		redirectURI:   redirect.String(),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+	}
+	i.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+// token trades a code for an access token and a signed ID token.
+func (i *Issuer) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	if id != clientID || secret != clientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	i.mu.Lock()
+	g, ok := i.grants[r.PostFormValue("code")]
+	delete(i.grants, r.PostFormValue("code"))
+	signer := i.signer
+	if i.forge {
+		signer = i.forger
+	}
+	i.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" ||
+		r.PostFormValue("redirect_uri") != g.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(sum[:]) != g.codeChallenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	idToken, err := jwt.Signed(signer).Claims(jwt.Claims{
+		Issuer:   i.URL,
+		Subject:  g.user.Subject,
+		Audience: jwt.Audience{clientID},
+		IssuedAt: jwt.NewNumericDate(now),
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+	}).Claims(map[string]any{
+		"nonce":          g.nonce,
+		"email":          g.user.Email,
+		"email_verified": g.user.EmailVerified,
+		"name":           g.user.Name,
+	}).Serialize()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+
+	"nmilo.ca/portfolio/folio"
+)
+
+// Structured data describes a portfolio to search engines in schema.org
+// terms: a Person, and a CreativeWork for each project they made.
+
+type jsonLDRef struct {
+	ID string `json:"@id"`
+}
+
+type jsonLDPlace struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type jsonLDNode struct {
+	Type         string       `json:"@type"`
+	ID           string       `json:"@id,omitempty"`
+	Name         string       `json:"name,omitempty"`
+	GivenName    string       `json:"givenName,omitempty"`
+	FamilyName   string       `json:"familyName,omitempty"`
+	Description  string       `json:"description,omitempty"`
+	URL          string       `json:"url,omitempty"`
+	Image        string       `json:"image,omitempty"`
+	Genre        string       `json:"genre,omitempty"`
+	HomeLocation *jsonLDPlace `json:"homeLocation,omitempty"`
+	SameAs       []string     `json:"sameAs,omitempty"`
+	Creator      *jsonLDRef   `json:"creator,omitempty"`
+}
+
+type jsonLD struct {
+	Context string       `json:"@context"`
+	Graph   []jsonLDNode `json:"@graph"`
+}
+
+// newJSONLD returns the structured data of the portfolio published under
+// username.
+func newJSONLD(username string, p folio.Portfolio) jsonLD {
+	url := portfolioURL(username)
+	person := jsonLDNode{
+		Type:        "Person",
+		ID:          url + "#person",
+		Name:        strings.TrimSpace(p.FirstName + " " + p.LastName),
+		GivenName:   p.FirstName,
+		FamilyName:  p.LastName,
+		Description: summarize(plainText(p.Bio), metaDescriptionLength),
+		URL:         url,
+		// The links in the bio are taken to be the owner's profiles
+		// elsewhere.
+		SameAs: markdownLinks(p.Bio),
+	}
+	if person.Name == "" {
+		person.Name = username
+	}
+	if p.Location != "" {
+		person.HomeLocation = &jsonLDPlace{Type: "Place", Name: p.Location}
+	}
+
+	graph := []jsonLDNode{person}
+	for _, section := range p.Sections {
+		for _, project := range section.Projects {
+			if project.Name == "" {
+				continue
+			}
+			work := jsonLDNode{
+				Type:        "CreativeWork",
+				Name:        project.Name,
+				Description: summarize(plainText(project.Description), metaDescriptionLength),
+				URL:         project.Link,
+				Image:       project.ImageURL,
+				Genre:       section.Title,
+				Creator:     &jsonLDRef{ID: person.ID},
+			}
+			if project.ID != "" {
+				work.ID = projectPermalinkURL(project.ID)
+			}
+			graph = append(graph, work)
+		}
+	}
+
+	return jsonLD{Context: "https://schema.org", Graph: graph}
+}
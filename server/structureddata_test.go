@@ -0,0 +1,23 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMarkdownLinks(t *testing.T) {
+	for _, test := range []struct {
+		md   string
+		want []string
+	}{
+		{"No links here.", nil},
+		{"[GitHub](https://github.com/ada) and [blog]( http://ada.example/ )", []string{"https://github.com/ada", "http://ada.example/"}},
+		{"[a](https://a.example) [again](https://a.example)", []string{"https://a.example"}},
+		{"![photo](https://a.example/photo.png)", nil},
+		{"[mail](mailto:ada@example.com) [js](javascript:alert(1)) [rel](/about)", nil},
+	} {
+		if got := markdownLinks(test.md); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("markdownLinks(%q) = %q, want %q", test.md, got, test.want)
+		}
+	}
+}
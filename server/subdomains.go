@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Every user's portfolio is also served on their own subdomain of
+// baseDomain, like janedoe.foliospot.io, the same as at /janedoe.
+//
+// Usernames taken before they had to be DNS labels, such as ones with
+// capitals or underscores, or that are now reserved, get no subdomain. They
+// are not renamed, since that would break their links; their owners can
+// rename themselves to get one.
+
+// baseDomain is the domain under which users get subdomains. Subdomains are
+// not served if it is empty.
+var baseDomain string
+
+// isDNSLabel reports whether name can be used as a subdomain. Usernames must
+// be DNS labels so that every user can have one.
+func isDNSLabel(name string) bool {
+	return len(name) <= maxDomainLabelLength && domainLabel.MatchString(name)
+}
+
+// subdomainLabel returns the label under baseDomain of host, if it could be a
+// user's subdomain.
+func subdomainLabel(host string) (string, bool) {
+	if baseDomain == "" {
+		return "", false
+	}
+
+	label, ok := strings.CutSuffix(host, "."+baseDomain)
+	if !ok || !isDNSLabel(label) {
+		return "", false
+	}
+	if _, ok := reservedNames[label]; ok {
+		return "", false
+	}
+	return label, true
+}
+
+// subdomainUsername returns the user whose subdomain host is, if it is one.
+// Only labels that are valid usernames are looked up, so users whose
+// usernames are not valid anymore have none.
+func subdomainUsername(host string) (string, bool) {
+	label, ok := subdomainLabel(host)
+	if !ok || !isValidUsername(label) {
+		return "", false
+	}
+
+	var exists bool
+	if err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE username = ?);`, label).Scan(&exists); err != nil {
+		log.Printf("error looking up subdomain %s: %v\n", host, err)
+		return "", false
+	}
+	return label, exists
+}
+
+// redirectRenamedSubdomain redirects requests to the subdomain of a renamed
+// user to the same path on their new subdomain.
+func redirectRenamedSubdomain(w http.ResponseWriter, r *http.Request, host string) bool {
+	old, ok := subdomainLabel(host)
+	if !ok {
+		return false
+	}
+	return redirectRenamed(w, r, old, func(renamed string) string {
+		return "//" + renamed + "." + baseDomain + r.URL.RequestURI()
+	})
+}
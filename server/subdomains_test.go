@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestSubdomainLabel(t *testing.T) {
+	oldBaseDomain := baseDomain
+	t.Cleanup(func() { baseDomain = oldBaseDomain })
+
+	baseDomain = ""
+	if label, ok := subdomainLabel("ada.foliospot.dev"); ok {
+		t.Errorf("subdomainLabel without a base domain = %q", label)
+	}
+
+	baseDomain = "foliospot.dev"
+	for _, test := range []struct {
+		host, want string
+	}{
+		{"ada.foliospot.dev", "ada"},
+		{"ada-lovelace.foliospot.dev", "ada-lovelace"},
+		{"foliospot.dev", ""},
+		{"a.b.foliospot.dev", ""},
+		{"ada.notfoliospot.dev", ""},
+		{"adafoliospot.dev", ""},
+		{"-ada.foliospot.dev", ""},
+		{"ada_l.foliospot.dev", ""},
+		{"editor.foliospot.dev", ""},
+		{"api.foliospot.dev", ""},
+	} {
+		label, ok := subdomainLabel(test.host)
+		if ok != (test.want != "") || label != test.want {
+			t.Errorf("subdomainLabel(%q) = %q, %v, want %q", test.host, label, ok, test.want)
+		}
+	}
+}
+
+func TestSubdomainUsername(t *testing.T) {
+	newTestDB(t)
+	oldBaseDomain := baseDomain
+	t.Cleanup(func() { baseDomain = oldBaseDomain })
+	baseDomain = "foliospot.dev"
+
+	createTestUser(t, "ada", testIdentity("ada"))
+	// Usernames from before they had to be DNS labels get no subdomain.
+	createTestUser(t, "Grace_H", testIdentity("Grace_H"))
+
+	for host, want := range map[string]string{
+		"ada.foliospot.dev":     "ada",
+		"grace_h.foliospot.dev": "",
+		"Grace_H.foliospot.dev": "",
+		"nobody.foliospot.dev":  "",
+	} {
+		username, ok := subdomainUsername(host)
+		if ok != (want != "") || ok && username != want {
+			t.Errorf("subdomainUsername(%q) = %q, %v, want %q", host, username, ok, want)
+		}
+	}
+}
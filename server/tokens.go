@@ -0,0 +1,273 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"nmilo.ca/portfolio/apis"
+)
+
+// tokenScope limits what a personal access token can be used for.
+type tokenScope string
+
+const (
+	scopeRead           tokenScope = "read"
+	scopeWritePortfolio tokenScope = "write_portfolio"
+	scopeUploadImages   tokenScope = "upload_images"
+
+	// scopeSession is never granted to a token. Endpoints requiring it, like
+	// the ones managing tokens, can only be used from a logged in browser.
+	scopeSession tokenScope = "session"
+)
+
+var tokenScopes = []tokenScope{scopeRead, scopeWritePortfolio, scopeUploadImages}
+
+const tokenPrefix = "fs_"
+const maxTokensPerUser = 50
+
+// hashToken returns the form of a token stored in the database. Tokens are
+// random enough that a fast unsalted hash is fine.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRandomToken returns a random URL-safe string with prefix.
+func newRandomToken(prefix string) string {
+	var b [32]byte
+	Must(rand.Read(b[:]))
+	return prefix + base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// bearerToken returns the token in r's Authorization header, if it has one.
+func bearerToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return strings.TrimSpace(token), ok
+}
+
+// scopeError says a valid token was used for something its scopes do not
+// allow. Since the token is fine, the request is forbidden rather than
+// unauthorized.
+type scopeError struct {
+	scope tokenScope
+}
+
+func (e scopeError) Error() string {
+	if e.scope == scopeSession {
+		return "access tokens cannot be used here"
+	}
+	return fmt.Sprintf("token does not have the %s scope", e.scope)
+}
+
+// loginErrorStatus returns the HTTP status for err, returned by getLogin.
+func loginErrorStatus(err error) int {
+	if errors.As(err, &scopeError{}) {
+		return http.StatusForbidden
+	}
+	return http.StatusUnauthorized
+}
+
+// tokenLogin returns the UUID of the user owning token, if it is valid and
+// grants scope.
+func tokenLogin(token string, scope tokenScope) (uuid.UUID, error) {
+	var idstr, scopes string
+	var expires sql.NullString
+	err := db.QueryRow(`
+		SELECT user_uuid, scopes, expires FROM api_tokens WHERE hash = ?;
+	`, hashToken(token)).Scan(&idstr, &scopes, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, fmt.Errorf("invalid token")
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+
+	now := time.Now()
+	if expires.Valid {
+		if t, err := time.Parse(time.RFC3339, expires.String); err != nil || now.After(t) {
+			return uuid.Nil, fmt.Errorf("token expired")
+		}
+	}
+
+	if !slices.Contains(strings.Fields(scopes), string(scope)) {
+		return uuid.Nil, scopeError{scope}
+	}
+
+	id, err := uuid.Parse(idstr)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("bad user id")
+	}
+
+	if _, err := db.Exec(`UPDATE api_tokens SET last_used = ? WHERE hash = ?;`, now.Format(time.RFC3339), hashToken(token)); err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+type tokenInfo struct {
+	ID       string       `json:"id"`
+	Name     string       `json:"name"`
+	Scopes   []tokenScope `json:"scopes"`
+	Created  string       `json:"created"`
+	Expires  string       `json:"expires,omitempty"`
+	LastUsed string       `json:"lastUsed,omitempty"`
+}
+
+func listTokensHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT id, name, scopes, created, expires, last_used
+		FROM api_tokens
+		WHERE user_uuid = ?
+		ORDER BY created;
+	`, id.String())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]tokenInfo, 0)
+	for rows.Next() {
+		var t tokenInfo
+		var scopes string
+		var expires, lastUsed sql.NullString
+		if err := rows.Scan(&t.ID, &t.Name, &scopes, &t.Created, &expires, &lastUsed); err != nil {
+			return nil, err
+		}
+
+		for _, s := range strings.Fields(scopes) {
+			t.Scopes = append(t.Scopes, tokenScope(s))
+		}
+		t.Expires = expires.String
+		t.LastUsed = lastUsed.String
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// createTokenHandler creates a token for the logged in user. The token itself
+// is only ever returned here; only its hash is kept.
+func createTokenHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		Name          string       `json:"name"`
+		Scopes        []tokenScope `json:"scopes"`
+		ExpiresInDays int          `json:"expiresInDays"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		return nil, apis.NewError("token name must be between 1 and 64 characters", http.StatusBadRequest)
+	}
+
+	if len(req.Scopes) == 0 {
+		return nil, apis.NewError("token must have at least one scope", http.StatusBadRequest)
+	}
+	for _, s := range req.Scopes {
+		if !slices.Contains(tokenScopes, s) {
+			return nil, apis.NewErrorWithData(fmt.Sprintf("unknown scope %q", s), http.StatusBadRequest, tokenScopes)
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	if req.ExpiresInDays < 0 {
+		return nil, apis.NewError("expiresInDays must not be negative", http.StatusBadRequest)
+	}
+
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM api_tokens WHERE user_uuid = ?;`, id.String()).Scan(&count); err != nil {
+		return nil, err
+	}
+	if count >= maxTokensPerUser {
+		return nil, apis.NewError(fmt.Sprintf("you can have at most %d tokens", maxTokensPerUser), http.StatusConflict)
+	}
+
+	now := time.Now()
+	token := newRandomToken(tokenPrefix)
+	info := tokenInfo{
+		ID:      uuid.New().String(),
+		Name:    req.Name,
+		Scopes:  req.Scopes,
+		Created: now.Format(time.RFC3339),
+	}
+
+	var expires sql.NullString
+	if req.ExpiresInDays > 0 {
+		info.Expires = now.AddDate(0, 0, req.ExpiresInDays).Format(time.RFC3339)
+		expires = sql.NullString{String: info.Expires, Valid: true}
+	}
+
+	scopes := make([]string, len(req.Scopes))
+	for i, s := range req.Scopes {
+		scopes[i] = string(s)
+	}
+
+	if _, err := db.Exec(`
+		INSERT INTO api_tokens (id, user_uuid, name, hash, scopes, created, expires)
+		VALUES (?, ?, ?, ?, ?, ?, ?);
+	`, info.ID, id.String(), info.Name, hashToken(token), strings.Join(scopes, " "), info.Created, expires); err != nil {
+		return nil, err
+	}
+
+	sendAccountEmail(id, "token", struct {
+		Name      string
+		Scopes    string
+		EditorURL string
+	}{info.Name, strings.Join(scopes, ", "), frontend + "/editor"})
+
+	return struct {
+		tokenInfo
+		Token string `json:"token"`
+	}{info, token}, nil
+}
+
+func revokeTokenHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	result, err := db.Exec(`DELETE FROM api_tokens WHERE id = ? AND user_uuid = ?;`, req.ID, id.String())
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, apis.StatusNotFound
+	}
+
+	return nil, nil
+}
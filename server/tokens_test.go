@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// createTestToken creates a token with scopes for the user with UUID id,
+// returning it.
+func createTestToken(t *testing.T, id string, scopes ...tokenScope) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"name": "test", "scopes": scopes})
+	res, err := createTokenHandler(loggedInRequest(t, "POST", "/api/create_token", string(body), id))
+	if err != nil {
+		t.Fatalf("creating token: %v", err)
+	}
+	return res.(struct {
+		tokenInfo
+		Token string `json:"token"`
+	}).Token
+}
+
+func bearerRequest(token string) *http.Request {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestTokenLogin(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	token := createTestToken(t, ada, scopeRead, scopeUploadImages)
+
+	for _, test := range []struct {
+		name   string
+		token  string
+		scope  tokenScope
+		status int
+	}{
+		{"granted scope", token, scopeRead, 0},
+		{"other granted scope", token, scopeUploadImages, 0},
+		{"missing scope", token, scopeWritePortfolio, http.StatusForbidden},
+		{"session only", token, scopeSession, http.StatusForbidden},
+		{"unknown token", "fst_nope", scopeRead, http.StatusUnauthorized},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := getLogin(bearerRequest(test.token), test.scope)
+			if test.status == 0 {
+				if err != nil || id.String() != ada {
+					t.Errorf("getLogin = %v, %v, want %s", id, err, ada)
+				}
+			} else if err == nil {
+				t.Errorf("getLogin succeeded, want status %d", test.status)
+			} else if status := loginErrorStatus(err); status != test.status {
+				t.Errorf("getLogin error %q has status %d, want %d", err, status, test.status)
+			}
+		})
+	}
+}
+
+func TestTokenExpired(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	token := createTestToken(t, ada, scopeRead)
+	if _, err := db.Exec(`UPDATE api_tokens SET expires = '2000-01-01T00:00:00Z';`); err != nil {
+		t.Fatalf("expiring token: %v", err)
+	}
+
+	if _, err := getLogin(bearerRequest(token), scopeRead); err == nil || loginErrorStatus(err) != http.StatusUnauthorized {
+		t.Errorf("getLogin with an expired token = %v, want it refused with 401", err)
+	}
+}
+
+func TestTokenRevoked(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	token := createTestToken(t, ada, scopeRead)
+
+	tokens, err := listTokensHandler(loggedInRequest(t, "GET", "/api/list_tokens", "", ada))
+	if err != nil {
+		t.Fatalf("listing tokens: %v", err)
+	}
+	id := tokens.([]tokenInfo)[0].ID
+	if _, err := revokeTokenHandler(loggedInRequest(t, "POST", "/api/revoke_token", `{"id": "`+id+`"}`, ada)); err != nil {
+		t.Fatalf("revoking token: %v", err)
+	}
+
+	if _, err := getLogin(bearerRequest(token), scopeRead); err == nil {
+		t.Error("getLogin succeeded with a revoked token")
+	}
+}
+
+func TestTokenStoredHashed(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	token := createTestToken(t, ada, scopeRead)
+
+	var stored string
+	if err := db.QueryRow(`SELECT hash FROM api_tokens;`).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(stored, token) || stored != hashToken(token) {
+		t.Errorf("api_tokens has %q, want only the token's hash", stored)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/mattn/go-sqlite3"
+	"nmilo.ca/portfolio/apis"
+)
+
+// Users can change their username. The old one keeps redirecting to the new
+// one for usernameGracePeriod, during which nobody else can take it, and is
+// then free again. Users can only rename once every renameCooldown so they
+// cannot hold on to many names at once.
+
+const (
+	usernameGracePeriod = 90 * 24 * time.Hour
+	renameCooldown      = 30 * 24 * time.Hour
+)
+
+// renamedUsername returns the username of the user who gave up old less than
+// usernameGracePeriod ago, and when old stops redirecting to it.
+func renamedUsername(old string) (string, time.Time, bool) {
+	var username, expires string
+	err := db.QueryRow(`
+		SELECT users.username, username_history.expires
+		FROM username_history JOIN users ON users.uuid = username_history.user_uuid
+		WHERE username_history.old_username = ? AND username_history.expires > ?
+		ORDER BY username_history.changed DESC
+		LIMIT 1;
+	`, old, time.Now().Format(time.RFC3339)).Scan(&username, &expires)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("error looking up old username %s: %v\n", old, err)
+		}
+		return "", time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return username, t, true
+}
+
+// redirectRenamed redirects r to url, the page of a renamed user, if old
+// still redirects. Browsers may cache the redirect until the old username
+// becomes available again, but not after.
+func redirectRenamed(w http.ResponseWriter, r *http.Request, old string, url func(username string) string) bool {
+	username, expires, ok := renamedUsername(old)
+	if !ok {
+		return false
+	}
+
+	maxAge := int(time.Until(expires).Seconds())
+	w.Header().Set("Cache-Control", "max-age="+strconv.Itoa(max(maxAge, 0)))
+	http.Redirect(w, r, url(username), http.StatusMovedPermanently)
+	return true
+}
+
+// errUsernameMoved is returned by the API for a username that was changed to
+// username. The client goes to the new username when it sees it.
+func errUsernameMoved(username string) error {
+	return apis.NewErrorWithData("this portfolio has moved", http.StatusMovedPermanently, struct {
+		Username string `json:"username"`
+	}{username})
+}
+
+// renameUserHandler changes the logged in user's username.
+func renameUserHandler(r *http.Request) (any, error) {
+	id, err := requireLogin(r, scopeSession)
+	if err != nil {
+		return nil, err
+	}
+
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, apis.NewError("could not parse json", http.StatusBadRequest)
+	}
+
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Writing first takes the database's write lock, so that the checks below
+	// cannot be passed by two renames at once.
+	if _, err := tx.Exec(`UPDATE users SET username = username WHERE uuid = ?;`, id.String()); err != nil {
+		return nil, err
+	}
+
+	var old string
+	if err := tx.QueryRow(`SELECT username FROM users WHERE uuid = ?;`, id.String()).Scan(&old); err != nil {
+		return nil, err
+	}
+	if req.Username == old {
+		return nil, apis.NewError("that is already your username", http.StatusBadRequest)
+	}
+
+	var lastRename sql.NullString
+	if err := tx.QueryRow(`
+		SELECT MAX(changed) FROM username_history WHERE user_uuid = ?;
+	`, id.String()).Scan(&lastRename); err != nil {
+		return nil, err
+	}
+	if lastRename.Valid {
+		last, err := time.Parse(time.RFC3339, lastRename.String)
+		if err != nil {
+			return nil, err
+		}
+		if next := last.Add(renameCooldown); now.Before(next) {
+			return nil, apis.NewErrorWithData(
+				fmt.Sprintf("you can only change your username once every %d days", renameCooldown/(24*time.Hour)),
+				http.StatusTooManyRequests,
+				struct {
+					Next string `json:"next"`
+				}{next.Format(time.RFC3339)},
+			)
+		}
+	}
+
+	// Users may go back to a username they gave up, even while it is being
+	// held for them, but not to one held for someone else.
+	avail, err := isUsernameAvailable(tx, req.Username, id)
+	if err != nil {
+		return nil, err
+	}
+	if !avail {
+		return nil, apis.NewError("username is not available", http.StatusConflict)
+	}
+
+	if _, err := tx.Exec(`UPDATE users SET username = ? WHERE uuid = ?;`, req.Username, id.String()); err != nil {
+		if isUniqueViolation(err) {
+			return nil, apis.NewError("username is not available", http.StatusConflict)
+		}
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		UPDATE username_history SET expires = ? WHERE old_username = ? AND user_uuid = ? AND expires > ?;
+	`, now.Format(time.RFC3339), req.Username, id.String(), now.Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO username_history (old_username, new_username, user_uuid, changed, expires)
+		VALUES (?, ?, ?, ?, ?);
+	`, old, req.Username, id.String(), now.Format(time.RFC3339), now.Add(usernameGracePeriod).Format(time.RFC3339)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("renamed user %s from %s to %s\n", id, old, req.Username)
+	refreshDirectory(id)
+	refreshSocialCard(id)
+	sendAccountEmail(id, "renamed", struct {
+		Old          string
+		New          string
+		PortfolioURL string
+		Until        string
+	}{old, req.Username, portfolioURL(req.Username), now.Add(usernameGracePeriod).Format("January 2, 2006")})
+
+	return struct {
+		Username string `json:"username"`
+	}{req.Username}, nil
+}
+
+// isUniqueViolation reports whether err is from a statement that broke a
+// UNIQUE constraint.
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// renameReservedUsernames renames, once, the users whose usernames were
+// taken before they became reservedNames, since their pages cannot be
+// reached under them, and emails them their new username. It needs the mailer.
+func renameReservedUsernames() error {
+	type renamed struct {
+		id       uuid.UUID
+		old, new string
+	}
+	var renames []renamed
+
+	err := runMigration("rename_reserved_usernames", func(tx *sql.Tx) error {
+		rows, err := tx.Query(`SELECT uuid, username FROM users;`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var idstr, username string
+			if err := rows.Scan(&idstr, &username); err != nil {
+				rows.Close()
+				return err
+			}
+			if _, reserved := reservedNames[username]; !reserved {
+				continue
+			}
+			id, err := uuid.Parse(idstr)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			renames = append(renames, renamed{id: id, old: username})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for i := range renames {
+			name, err := replacementUsername(tx, renames[i].old, renames[i].id)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Exec(`UPDATE users SET username = ? WHERE uuid = ?;`, name, renames[i].id.String()); err != nil {
+				return err
+			}
+			renames[i].new = name
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, r := range renames {
+		log.Printf("renamed user %s from reserved username %s to %s\n", r.id, r.old, r.new)
+		refreshDirectory(r.id)
+		sendAccountEmail(r.id, "reserved", struct {
+			Old          string
+			New          string
+			PortfolioURL string
+		}{r.old, r.new, portfolioURL(r.new)})
+	}
+	return nil
+}
+
+// replacementUsername returns an available username for the user with UUID
+// id, made from old and a number.
+func replacementUsername(q queryer, old string, id uuid.UUID) (string, error) {
+	base := strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(old))
+	if base == "" {
+		base = "user"
+	}
+	base = base[:min(len(base), 12)]
+
+	for n := 1; ; n++ {
+		name := base + strconv.Itoa(n)
+		avail, err := isUsernameAvailable(q, name, id)
+		if err != nil {
+			return "", err
+		}
+		if avail {
+			return name, nil
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func rename(t *testing.T, id, username string) error {
+	t.Helper()
+	_, err := renameUserHandler(loggedInRequest(t, "POST", "/api/rename_user", `{"username": "`+username+`"}`, id))
+	return err
+}
+
+// ageRenames moves the renames of the user with UUID id back by d.
+func ageRenames(t *testing.T, id string, d time.Duration) {
+	t.Helper()
+	rows, err := db.Query(`SELECT rowid, changed, expires FROM username_history WHERE user_uuid = ?;`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type rename struct {
+		rowid            int64
+		changed, expires time.Time
+	}
+	var renames []rename
+	for rows.Next() {
+		var r rename
+		var changed, expires string
+		if err := rows.Scan(&r.rowid, &changed, &expires); err != nil {
+			t.Fatal(err)
+		}
+		r.changed, _ = time.Parse(time.RFC3339, changed)
+		r.expires, _ = time.Parse(time.RFC3339, expires)
+		renames = append(renames, r)
+	}
+	rows.Close()
+
+	for _, r := range renames {
+		if _, err := db.Exec(`UPDATE username_history SET changed = ?, expires = ? WHERE rowid = ?;`,
+			r.changed.Add(-d).Format(time.RFC3339), r.expires.Add(-d).Format(time.RFC3339), r.rowid); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func servePage(username string) *http.Response {
+	w := httptest.NewRecorder()
+	servePortfolioPage(w, httptest.NewRequest("GET", "/"+username, nil), username, false)
+	return w.Result()
+}
+
+func TestRenameRedirects(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	if err := rename(t, ada, "lovelace"); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+
+	res := servePage("ada")
+	wantRedirect(t, res, http.StatusMovedPermanently, "/lovelace")
+	maxAge, err := strconv.Atoi(strings.TrimPrefix(res.Header.Get("Cache-Control"), "max-age="))
+	if err != nil || time.Duration(maxAge)*time.Second > usernameGracePeriod || time.Duration(maxAge)*time.Second < usernameGracePeriod-time.Minute {
+		t.Errorf("redirect has Cache-Control %q, want a max-age of the grace period", res.Header.Get("Cache-Control"))
+	}
+
+	_, err = requestedPortfolio(httptest.NewRequest("GET", "/api/get_portfolio?username=ada", nil))
+	if errorStatus(err) != http.StatusMovedPermanently {
+		t.Errorf("loading the old username = %v, want 301", err)
+	}
+	if _, err := requestedPortfolio(httptest.NewRequest("GET", "/api/get_portfolio?username=lovelace", nil)); err != nil {
+		t.Errorf("loading the new username: %v", err)
+	}
+
+	// Once the grace period is over, the old username is free again.
+	ageRenames(t, ada, usernameGracePeriod)
+	if res := servePage("ada"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("old username after the grace period has status %d, want 404", res.StatusCode)
+	}
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	if err := rename(t, grace, "ada"); err != nil {
+		t.Errorf("taking a username after its grace period: %v", err)
+	}
+}
+
+func TestRenameHoldsOldUsername(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	if err := rename(t, ada, "lovelace"); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+
+	if err := rename(t, grace, "ada"); errorStatus(err) != http.StatusConflict {
+		t.Errorf("taking a username held for someone else = %v, want 409", err)
+	}
+	if err := rename(t, grace, "lovelace"); errorStatus(err) != http.StatusConflict {
+		t.Errorf("taking someone's username = %v, want 409", err)
+	}
+
+	// The user it is held for can go back to it.
+	ageRenames(t, ada, renameCooldown)
+	if err := rename(t, ada, "ada"); err != nil {
+		t.Fatalf("going back to a held username: %v", err)
+	}
+	if res := servePage("lovelace"); res.StatusCode != http.StatusMovedPermanently {
+		t.Errorf("lovelace has status %d, want 301", res.StatusCode)
+	}
+	if res := servePage("ada"); res.StatusCode != http.StatusOK {
+		t.Errorf("ada has status %d, want 200", res.StatusCode)
+	}
+}
+
+func TestRenameCooldown(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	if err := rename(t, ada, "lovelace"); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+
+	if err := rename(t, ada, "countess"); errorStatus(err) != http.StatusTooManyRequests {
+		t.Errorf("renaming again right away = %v, want 429", err)
+	}
+	ageRenames(t, ada, renameCooldown)
+	if err := rename(t, ada, "countess"); err != nil {
+		t.Errorf("renaming after the cooldown: %v", err)
+	}
+}
+
+func TestRenameInvalid(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+
+	for _, username := range []string{"", "ada", "Not Valid", "api", "editor"} {
+		if err := rename(t, ada, username); err == nil {
+			t.Errorf("renaming to %q succeeded", username)
+		}
+	}
+}
+
+func TestUsernameHeldForHolder(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	if err := rename(t, ada, "lovelace"); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		holder uuid.UUID
+		want   bool
+	}{
+		{"ada", uuid.MustParse(ada), true},
+		{"ada", uuid.Nil, false},
+		{"lovelace", uuid.MustParse(ada), false},
+	} {
+		if avail, err := isUsernameAvailable(db, test.name, test.holder); err != nil || avail != test.want {
+			t.Errorf("isUsernameAvailable(%q, %s) = %v, %v, want %v", test.name, test.holder, avail, err, test.want)
+		}
+	}
+}
+
+func TestIsUniqueViolation(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	createTestUser(t, "grace", testIdentity("grace"))
+
+	_, err := db.Exec(`UPDATE users SET username = 'grace' WHERE uuid = ?;`, ada)
+	if !isUniqueViolation(err) {
+		t.Errorf("taking another user's username: %v, want a UNIQUE violation", err)
+	}
+	_, err = db.Exec(`UPDATE users SET nope = 'grace' WHERE uuid = ?;`, ada)
+	if err == nil || isUniqueViolation(err) {
+		t.Errorf("updating a missing column: %v, want an error that is not a UNIQUE violation", err)
+	}
+}
+
+func TestRenameReservedUsernames(t *testing.T) {
+	newTestDB(t)
+	ada := createTestUser(t, "ada", testIdentity("ada"))
+	grace := createTestUser(t, "grace", testIdentity("grace"))
+	createTestUser(t, "share1", testIdentity("share1"))
+	// Usernames like these could be taken before they were reserved.
+	for id, username := range map[string]string{ada: "share", grace: "sitemap.xml"} {
+		if _, err := db.Exec(`UPDATE users SET username = ? WHERE uuid = ?;`, username, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := renameReservedUsernames(); err != nil {
+		t.Fatalf("renaming: %v", err)
+	}
+	for id, want := range map[string]string{ada: "share2", grace: "sitemapxml1"} {
+		var username string
+		if err := db.QueryRow(`SELECT username FROM users WHERE uuid = ?;`, id).Scan(&username); err != nil {
+			t.Fatal(err)
+		}
+		if username != want {
+			t.Errorf("renamed to %q, want %q", username, want)
+		}
+	}
+
+	var text string
+	if err := db.QueryRow(`
+		SELECT text FROM mail_queue WHERE recipient = 'ada@example.com' ORDER BY queued DESC, rowid DESC LIMIT 1;
+	`).Scan(&text); err != nil {
+		t.Fatalf("finding rename email: %v", err)
+	}
+	if !strings.Contains(text, "share2") {
+		t.Errorf("rename email does not give the new username:\n%s", text)
+	}
+
+	// It only happens once.
+	if _, err := db.Exec(`UPDATE users SET username = 'directory' WHERE uuid = ?;`, ada); err != nil {
+		t.Fatal(err)
+	}
+	if err := renameReservedUsernames(); err != nil {
+		t.Fatalf("renaming again: %v", err)
+	}
+	var username string
+	if err := db.QueryRow(`SELECT username FROM users WHERE uuid = ?;`, ada).Scan(&username); err != nil || username != "directory" {
+		t.Errorf("username after renaming again = %q, %v, want it left alone", username, err)
+	}
+}